@@ -5,21 +5,39 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/tobyjsullivan/btc-frogger/balances"
 	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/drawdown"
+	"github.com/tobyjsullivan/btc-frogger/execution"
+	"github.com/tobyjsullivan/btc-frogger/journal"
+	"github.com/tobyjsullivan/btc-frogger/killswitch"
+	"github.com/tobyjsullivan/btc-frogger/feed"
+	"github.com/tobyjsullivan/btc-frogger/liveorders"
+	"github.com/tobyjsullivan/btc-frogger/metrics"
 	"github.com/tobyjsullivan/btc-frogger/orders"
+	"github.com/tobyjsullivan/btc-frogger/pnl"
 	"github.com/tobyjsullivan/btc-frogger/rates"
 	"github.com/tobyjsullivan/btc-frogger/spread"
 	"github.com/tobyjsullivan/btc-frogger/reporting"
+	"github.com/tobyjsullivan/btc-frogger/risk"
 	"errors"
-	"math"
+	"net/http"
+	"os/signal"
+	"syscall"
 )
 
 const (
 	TICK_DURATION = 30 * time.Second
+
+	// How long shutdown waits for queued reports to be delivered
+	reportFlushTimeout = 10 * time.Second
+
+	// Trading never starts without a reconciled view of our orders, so give up after about a minute
+	maxReconcileAttempts = 6
 )
 
 var (
@@ -28,6 +46,24 @@ var (
 	coinbaseSecretKey  = os.Getenv("COINBASE_API_SECRET_KEY")
 	coinbasePassphrase = os.Getenv("COINBASE_API_PASSPHRASE")
 	dweetThingName = os.Getenv("DWEET_THING_NAME")
+	// Comma separated report sinks, eg "stdout,statsd:localhost:8125". See reporting.ParseSinks.
+	// Defaults to the dweet thing when unset.
+	reportSinks = os.Getenv("REPORT_SINKS")
+	// Either "level2" or "ticker" to source spreads from the websocket feed. Empty uses REST polling only.
+	spreadFeedChannel = os.Getenv("SPREAD_FEED_CHANNEL")
+	// One of "join", "improve:N", "undercut:N", "mid" or "depth". See orders.ParsePricingPolicy.
+	orderPricing = os.Getenv("ORDER_PRICING")
+	// Order journal location. Defaults to journal.jsonl in the working directory.
+	journalPath = os.Getenv("JOURNAL_PATH")
+	// Kill switch halt file. Trading is halted while it exists, including across restarts.
+	// Defaults to halt in the working directory.
+	killSwitchPath = os.Getenv("KILL_SWITCH_PATH")
+	// Local address for the kill switch HTTP endpoint, eg "127.0.0.1:8089". Empty disables.
+	killSwitchAddr = os.Getenv("KILL_SWITCH_ADDR")
+	// Whether resting orders are cancelled when the kill switch is engaged.
+	killSwitchCancel = strings.ToLower(os.Getenv("KILL_SWITCH_CANCEL_ORDERS")) == "true"
+	// Address to serve Prometheus metrics on at /metrics, eg ":9100". Empty disables.
+	metricsAddr = os.Getenv("METRICS_ADDR")
 )
 
 func main() {
@@ -45,59 +81,205 @@ func main() {
 		},
 	}
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if metricsAddr != "" {
+		log.Println("Serving metrics on", metricsAddr)
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			log.Println("metrics endpoint:", http.ListenAndServe(metricsAddr, mux))
+		}()
+	}
 
 	log.Println("Building reporting service...")
-	reportingSvc := reporting.NewService(dweetThingName, dryRun)
+	if reportSinks == "" && dweetThingName != "" {
+		if dryRun {
+			log.Println("DRY_RUN: not reporting to dweet")
+		} else {
+			reportSinks = "dweet:" + dweetThingName
+		}
+	}
+	sinks, err := reporting.ParseSinks(reportSinks)
+	if err != nil {
+		log.Fatalln("report sinks:", err)
+	}
+	reportingSvc := reporting.NewService(sinks, reportDeliveryConfig())
 
 	log.Println("Building balances service...")
 	balanceSvc := balances.NewService(ctx, conn)
 
 	log.Println("Building rate service...")
-	rateSvc := rates.NewService(ctx, conn)
+	rateSvc := rates.NewService(ctx, conn, &rates.Config{
+		MaxDeviation:  envFloat("RATE_MAX_DEVIATION", 0.03),
+		MaxJump:       envFloat("RATE_MAX_JUMP", 0.05),
+		JumpTolerance: envFloat("RATE_JUMP_TOLERANCE", 0.01),
+	})
 
 	log.Println("Building spread service...")
-	spreadSvc := spread.NewService(ctx, conn)
+	var quoteSrc spread.QuoteSource
+	if spreadFeedChannel != "" {
+		log.Println("Sourcing spreads from feed channel:", spreadFeedChannel)
+		feedClient := feed.NewClient(feed.DefaultUrl, nil)
+		market, err := liveorders.NewMarket(feedClient, spreadFeedChannel, coinbase.ProductEthBtc, coinbase.ProductLtcBtc)
+		if err != nil {
+			log.Fatalln("SPREAD_FEED_CHANNEL:", err)
+		}
+		quoteSrc = market
+		go feedClient.Run(ctx)
+	}
+	spreadSvc := spread.NewService(ctx, conn, quoteSrc)
+
+	if journalPath == "" {
+		journalPath = "journal.jsonl"
+	}
+	log.Println("Opening order journal:", journalPath)
+	orderJournal, err := journal.Open(journalPath)
+	if err != nil {
+		log.Fatalln("journal:", err)
+	}
+	defer orderJournal.Close()
+
+	if killSwitchPath == "" {
+		killSwitchPath = "halt"
+	}
+	log.Println("Building kill switch...")
+	killSwitch := killswitch.New(&killswitch.Config{
+		Path:                 killSwitchPath,
+		MaxConsecutiveErrors: envInt("KILL_SWITCH_MAX_ERRORS", 5),
+	})
+	go killSwitch.Run(ctx)
+	if killSwitchAddr != "" {
+		log.Println("Serving kill switch on", killSwitchAddr)
+		go func() {
+			log.Println("kill switch endpoint:", http.ListenAndServe(killSwitchAddr, killSwitch.Handler()))
+		}()
+	}
+
+	log.Println("Building drawdown breaker...")
+	breaker := drawdown.NewBreaker(drawdownConfig())
+
+	log.Println("Building risk engine...")
+	riskEngine := risk.NewEngine(riskLimits(), rateSvc)
+
+	log.Println("Building PnL tracker...")
+	lotMethod, err := pnl.ParseMethod(envString("PNL_LOT_METHOD", string(pnl.MethodFIFO)))
+	if err != nil {
+		log.Fatalln("pnl:", err)
+	}
+	// Opening holdings and lots are kept here so PnL carries across restarts
+	pnlTracker := pnl.NewTracker(lotMethod, rateSvc, envString("PNL_STATE_PATH", "pnl.json"))
 
 	log.Println("Building orders service...")
-	orderSvc := orders.NewService(ctx, conn, spreadSvc, dryRun)
+	if orderPricing == "" {
+		orderPricing = "undercut:1"
+	}
+	pricing, err := orders.ParsePricingPolicy(orderPricing)
+	if err != nil {
+		log.Fatalln("pricing policy:", err)
+	}
+	orderSvc := orders.NewService(ctx, conn, spreadSvc, &orders.Config{
+		Pricing:               pricing,
+		RepriceThresholdTicks: envInt("ORDER_REPRICE_THRESHOLD_TICKS", 2),
+		MaxRepricesPerMinute:  envInt("ORDER_MAX_REPRICES_PER_MINUTE", 10),
+		Escalation:            escalationConfig(),
+		Journal:               orderJournal,
+		Risk:                  riskEngine,
+		KillSwitch:            killSwitch,
+		Fills:                 pnlTracker,
+		DryRun:                dryRun,
+	})
+
+	if killSwitchCancel {
+		killSwitch.OnHalt(func(reason string) {
+			log.Println("Kill switch engaged, cancelling resting orders:", reason)
+			// Halts can come from inside the order loop, which CancelAll waits on
+			go orderSvc.CancelAll()
+		})
+	}
+
+	log.Println("Building execution service...")
+	execSvc := execution.NewService(conn, orderSvc, spreadSvc, executionConfig())
 
 	log.Println("Services initialized.")
 
-	go func(rateSvc *rates.RateSvc, balanceSvc *balances.BalanceSvc){
+	// Work out what happened to anything in flight before a restart before placing anything new
+	log.Println("Reconciling orders with exchange...")
+	for attempt := 1; ; attempt++ {
+		err := orderSvc.Reconcile()
+		if err == nil {
+			break
+		}
+		if attempt == maxReconcileAttempts {
+			log.Fatalln("reconcile: giving up:", err)
+		}
+		log.Println("reconcile:", err)
+		time.Sleep(10 * time.Second)
+	}
+
+	go func(src *reportSources) {
 		ticker := time.Tick(10 * time.Second)
 		for range ticker {
-			distro, err := computeDistribution(rateSvc, balanceSvc)
+			report, err := buildReport(src)
 			if err != nil {
 				log.Println("reporting assets:", err)
 				continue
 			}
-			ethRate, _ := rateSvc.CurrentRate(coinbase.CurrencyEth, coinbase.CurrencyBtc)
-			ltcRate, _ := rateSvc.CurrentRate(coinbase.CurrencyLtc, coinbase.CurrencyBtc)
-			usdRate, _ := rateSvc.CurrentRate(coinbase.CurrencyBtc, coinbase.CurrencyUsd)
-
-			totalAssets := float64(distro.totalAssets) / float64(coinbase.AmountCoin)
-			reportingSvc.ReportMetrics(&reporting.Report{
-				TotalAssets: totalAssets,
-				AssetValueUsd: math.Floor((totalAssets * usdRate) * 100) / 100,
-				BtcBalance: float64(distro.ntvBtcBalance) / float64(coinbase.AmountCoin),
-				EthBalance: float64(distro.ntvEthBalance) / float64(coinbase.AmountCoin),
-				LtcBalance: float64(distro.ntvLtcBalance) / float64(coinbase.AmountCoin),
-				EthRate: ethRate,
-				LtcRate: ltcRate,
-				UsdRate: usdRate,
-			})
+			reportingSvc.ReportMetrics(report)
 		}
-	}(rateSvc, balanceSvc)
-
-	// Run the cycle every tick
+	}(&reportSources{
+		conn:       conn,
+		rateSvc:    rateSvc,
+		balanceSvc: balanceSvc,
+		spreadSvc:  spreadSvc,
+		killSwitch: killSwitch,
+		breaker:    breaker,
+		pnlTracker: pnlTracker,
+		dryRun:     dryRun,
+	})
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+
+	// Run the cycle every tick until asked to stop. The loop's post statement times every
+	// cycle, including those cut short by a continue.
 	ticker := time.NewTicker(TICK_DURATION)
-	for range ticker.C {
+cycles:
+	for cycleStart := time.Now(); ; observeCycle(cycleStart) {
+		select {
+		case sig := <-shutdown:
+			log.Println("Received", sig, "shutting down...")
+			break cycles
+		case <-ticker.C:
+		}
+
+		cycleStart = time.Now()
+		recordRates(rateSvc)
+		recordSpreads(spreadSvc)
+		recordExecution(execSvc)
+
+		if halted, reason := killSwitch.Halted(); halted {
+			log.Println("Trading halted:", reason)
+			if dd := breaker.Status(); dd.Tripped && breaker.Action() == drawdown.ActionPause {
+				handOverPause(killSwitch, breaker, dd)
+			}
+			if killSwitchCancel {
+				orderSvc.CancelAll()
+			}
+			continue
+		}
+
 		// First thing, cancel all pending orders to clear out anything that was unfulfilled last time
-		if dryRun {
-			log.Println("DRY RUN: Skipping order cancel")
-		} else {
-			conn.CancelAllOrders()
+		orderSvc.EndCycle()
+
+		qs := orderSvc.QueueStats()
+		log.Printf("Order queue: depth %d; enqueued %d; coalesced %d; cancelled %d; processed %d\n",
+			qs.Depth, qs.Enqueued, qs.Coalesced, qs.Cancelled, qs.Processed)
+
+		if healthy, reason := rateSvc.Healthy(); !healthy {
+			log.Println("Rates unhealthy, skipping cycle:", reason)
+			continue
 		}
 
 		ethBtcRate, ok := rateSvc.CurrentRate(coinbase.CurrencyEth, coinbase.CurrencyBtc)
@@ -117,12 +299,42 @@ func main() {
 		distro, err := computeDistribution(rateSvc, balanceSvc)
 		if err != nil {
 			log.Println("compute total assets:", err)
+			killSwitch.RecordError(err)
 			continue
 		}
 		log.Printf("Current Holdings: BTC: %s ETH: %s LTC: %s\n", fmtAmount(distro.ntvBtcBalance),
 			fmtAmount(distro.ntvEthBalance), fmtAmount(distro.ntvLtcBalance))
 		log.Printf("Total Assets: %s BTC - %s\n", fmtAmount(distro.totalAssets), time.Now())
 
+		btcUsdRate, _ := rateSvc.CurrentRate(coinbase.CurrencyBtc, coinbase.CurrencyUsd)
+		dd := breaker.Update(distro.totalAssets, btcUsdRate)
+		log.Printf("Drawdown: BTC %.2f%%; USD %.2f%%\n", dd.DrawdownBtc*100, dd.DrawdownUsd*100)
+		if dd.Tripped {
+			if breaker.Action() == drawdown.ActionPause {
+				handOverPause(killSwitch, breaker, dd)
+				continue
+			}
+
+			safe := breaker.SafeAllocation()
+			log.Printf("Drawdown breaker tripped (%s). Targeting safe allocation: ETH %.2f; LTC %.2f\n",
+				dd.Reason, safe.Eth, safe.Ltc)
+			applySafeAllocation(distro, safe)
+		}
+		riskEngine.StartCycle(distro.totalAssets)
+		recordDistribution(distro)
+
+		if !pnlTracker.Opened() {
+			pnlTracker.Open(map[coinbase.Currency]int64{
+				coinbase.CurrencyBtc: distro.ntvBtcBalance,
+				coinbase.CurrencyEth: distro.ntvEthBalance,
+				coinbase.CurrencyLtc: distro.ntvLtcBalance,
+			})
+		}
+		pl := pnlTracker.Summary()
+		log.Printf("PnL: realized %s BTC ($%.2f); unrealized %s BTC ($%.2f); fees %s BTC; alpha %s BTC ($%.2f)\n",
+			fmtAmount(pl.RealizedBtc), pl.RealizedUsd, fmtAmount(pl.UnrealizedBtc), pl.UnrealizedUsd,
+			fmtAmount(pl.FeesBtc), fmtAmount(pl.AlphaBtc), pl.AlphaUsd)
+
 
 		ntvEthDiff, err := rateSvc.Convert(coinbase.CurrencyBtc, coinbase.CurrencyEth, distro.diffEth)
 		if err != nil {
@@ -138,11 +350,11 @@ func main() {
 
 		log.Printf("Trade Goals: %s ETH; %s LTC\n", fmtAmount(ntvEthDiff), fmtAmount(ntvLtcDiff))
 
-		ethBid, _ := spreadSvc.CurrentBid(coinbase.ProductEthBtc)
-		ethAsk, _ := spreadSvc.CurrentAsk(coinbase.ProductEthBtc)
-		ltcBid, _ := spreadSvc.CurrentBid(coinbase.ProductLtcBtc)
-		ltcAsk, _ := spreadSvc.CurrentAsk(coinbase.ProductLtcBtc)
-		log.Printf("Current spreads: ETH/BTC - %d:%d; LTC/BTC - %d:%d\n", ethBid, ethAsk, ltcBid, ltcAsk)
+		for _, pid := range []coinbase.ProductID{coinbase.ProductEthBtc, coinbase.ProductLtcBtc} {
+			if q, ok := spreadSvc.CurrentQuote(pid); ok {
+				log.Printf("Current spread: %s - %d:%d (%s, %s old)\n", pid, q.Bid, q.Ask, q.Source, q.Age())
+			}
+		}
 
 		// There are six potential cases
 		// 1. +BTC, -ETH, -LTC
@@ -153,22 +365,44 @@ func main() {
 		// 6. -BTC, +ETH, +LTC
 		// Although we cannot exchange ETH <-> LTC directly, we can satisfy all of these cases in two trades
 		// NOTE: Since BTC is the intermediary currency, we never actually have to buy or sell it explicitly
+		// Order sizes are limited to available funds; anything on hold is already committed elsewhere.
+		avlBtc, _ := balanceSvc.GetAvailableBalance(coinbase.CurrencyBtc)
+		avlEth, _ := balanceSvc.GetAvailableBalance(coinbase.CurrencyEth)
+		avlLtc, _ := balanceSvc.GetAvailableBalance(coinbase.CurrencyLtc)
+		log.Printf("Available: BTC: %s ETH: %s LTC: %s\n", fmtAmount(avlBtc), fmtAmount(avlEth), fmtAmount(avlLtc))
+
 		// Sell any ETH or LTC first
 		if ntvEthDiff < 0 {
-			orderSvc.PlaceOrder(coinbase.CurrencyEth, coinbase.SideSell, 0-ntvEthDiff)
+			execSvc.Execute(coinbase.CurrencyEth, coinbase.SideSell, minAmount(0-ntvEthDiff, avlEth))
 		}
 		if ntvLtcDiff < 0 {
-			orderSvc.PlaceOrder(coinbase.CurrencyLtc, coinbase.SideSell, 0-ntvLtcDiff)
+			execSvc.Execute(coinbase.CurrencyLtc, coinbase.SideSell, minAmount(0-ntvLtcDiff, avlLtc))
 		}
-		// Then buy any ETH or LTC
+		// Then buy any ETH or LTC with whatever BTC is available
 		if ntvEthDiff > 0 {
-			orderSvc.PlaceOrder(coinbase.CurrencyEth, coinbase.SideBuy, ntvEthDiff)
+			amount, spent := capBuy(rateSvc, coinbase.CurrencyEth, ntvEthDiff, avlBtc)
+			avlBtc -= spent
+			execSvc.Execute(coinbase.CurrencyEth, coinbase.SideBuy, amount)
 		}
 		if ntvLtcDiff > 0 {
-			orderSvc.PlaceOrder(coinbase.CurrencyLtc, coinbase.SideBuy, ntvLtcDiff)
+			amount, spent := capBuy(rateSvc, coinbase.CurrencyLtc, ntvLtcDiff, avlBtc)
+			avlBtc -= spent
+			execSvc.Execute(coinbase.CurrencyLtc, coinbase.SideBuy, amount)
+		}
+		if ntvEthDiff == 0 {
+			execSvc.Idle(coinbase.CurrencyEth)
+		}
+		if ntvLtcDiff == 0 {
+			execSvc.Idle(coinbase.CurrencyLtc)
 		}
+
+		// A cycle that got this far saw no errors, so the errors counted so far weren't consecutive
+		killSwitch.RecordSuccess()
 	}
 
+	ticker.Stop()
+	reportingSvc.Close(reportFlushTimeout)
+
 	log.Println("Done. Goodbye!")
 }
 
@@ -176,6 +410,188 @@ func fmtAmount(amount int64) string {
 	return fmt.Sprintf("%.8f", float64(amount)/coinbase.AmountCoin)
 }
 
+// executionConfig reads how rebalances are split into child orders. EXECUTION_STRATEGY is one
+// of "none" (default), "twap" or "iceberg".
+func executionConfig() *execution.Config {
+	strategy, err := execution.ParseStrategy(os.Getenv("EXECUTION_STRATEGY"))
+	if err != nil {
+		log.Fatalln("EXECUTION_STRATEGY:", err)
+	}
+
+	slice, err := coinbase.ParseAmount(os.Getenv("EXECUTION_SLICE_SIZE"))
+	if err != nil {
+		slice = int64(0.1 * float64(coinbase.AmountCoin))
+	}
+
+	return &execution.Config{
+		Strategy:  strategy,
+		Duration:  time.Duration(envInt("EXECUTION_TWAP_MINUTES", 10)) * time.Minute,
+		SliceSize: slice,
+	}
+}
+
+// reportDeliveryConfig reads queueing and retry settings for reports. Undelivered reports are
+// spooled to REPORT_SPOOL_DIR when set, otherwise dropped.
+func reportDeliveryConfig() *reporting.DeliveryConfig {
+	cfg := reporting.DefaultDeliveryConfig()
+	cfg.QueueSize = envInt("REPORT_QUEUE_SIZE", cfg.QueueSize)
+	cfg.MaxAttempts = envInt("REPORT_MAX_ATTEMPTS", cfg.MaxAttempts)
+	cfg.SpoolDir = os.Getenv("REPORT_SPOOL_DIR")
+
+	return cfg
+}
+
+// handOverPause halts trading for a tripped drawdown breaker. The kill switch owns the pause
+// once its halt file is written, so it persists and is cleared the usual way. Until then the
+// breaker stays tripped so the pause isn't lost on a restart.
+func handOverPause(killSwitch *killswitch.Switch, breaker *drawdown.Breaker, dd drawdown.Status) {
+	if err := killSwitch.Halt("drawdown: " + dd.Reason); err != nil {
+		log.Println("Drawdown halt not persisted, keeping breaker tripped:", err)
+		return
+	}
+	breaker.Reset()
+}
+
+// drawdownConfig reads the circuit breaker thresholds. DRAWDOWN_ACTION is "pause" (default) or
+// "safe", which rebalances to DRAWDOWN_SAFE_ETH and DRAWDOWN_SAFE_LTC with the rest in BTC.
+func drawdownConfig() *drawdown.Config {
+	action, err := drawdown.ParseAction(os.Getenv("DRAWDOWN_ACTION"))
+	if err != nil {
+		log.Fatalln("DRAWDOWN_ACTION:", err)
+	}
+
+	return &drawdown.Config{
+		MaxDrawdownBtc: envFloat("DRAWDOWN_MAX_BTC", 0),
+		MaxDrawdownUsd: envFloat("DRAWDOWN_MAX_USD", 0),
+		Action:         action,
+		Safe: drawdown.Allocation{
+			Eth: envFloat("DRAWDOWN_SAFE_ETH", 0),
+			Ltc: envFloat("DRAWDOWN_SAFE_LTC", 0),
+		},
+		StatePath: os.Getenv("DRAWDOWN_STATE_PATH"),
+	}
+}
+
+// riskLimits reads the pre-trade limits. Notional limits are in BTC and disabled unless set.
+func riskLimits() *risk.Limits {
+	limits := &risk.Limits{
+		MaxPriceDeviation: envFloat("RISK_MAX_PRICE_DEVIATION", 0.05),
+		MaxCycleShare:     envFloat("RISK_MAX_CYCLE_SHARE", 0.5),
+	}
+
+	if v, err := coinbase.ParseAmount(os.Getenv("RISK_MAX_ORDER_BTC")); err == nil {
+		limits.MaxOrderNotional = v
+	}
+	if v, err := coinbase.ParseAmount(os.Getenv("RISK_MAX_HOURLY_TURNOVER_BTC")); err == nil {
+		limits.MaxHourlyTurnover = v
+	}
+	if v, err := coinbase.ParseAmount(os.Getenv("RISK_MAX_DAILY_TURNOVER_BTC")); err == nil {
+		limits.MaxDailyTurnover = v
+	}
+
+	return limits
+}
+
+// escalationConfig builds the maker-to-taker escalation policy from the environment.
+// Escalation is disabled unless ESCALATE_AFTER_CYCLES or ESCALATE_AFTER_MINUTES is set.
+func escalationConfig() *orders.EscalationConfig {
+	cycles := envInt("ESCALATE_AFTER_CYCLES", 0)
+	minutes := envInt("ESCALATE_AFTER_MINUTES", 0)
+	if cycles == 0 && minutes == 0 {
+		return nil
+	}
+
+	mode, err := orders.ParseEscalationMode(os.Getenv("ESCALATE_MODE"))
+	if err != nil {
+		log.Fatalln("ESCALATE_MODE:", err)
+	}
+
+	feeRate, err := strconv.ParseFloat(os.Getenv("TAKER_FEE_RATE"), 64)
+	if err != nil {
+		feeRate = 0.003
+	}
+
+	maxFees, err := coinbase.ParseAmount(os.Getenv("MAX_DAILY_TAKER_FEES_BTC"))
+	if err != nil {
+		maxFees = 0
+	}
+
+	return &orders.EscalationConfig{
+		AfterCycles:       cycles,
+		AfterDuration:     time.Duration(minutes) * time.Minute,
+		Mode:              mode,
+		TakerFeeRate:      feeRate,
+		MaxDailyTakerFees: maxFees,
+	}
+}
+
+// envString reads a setting, falling back to def when unset.
+func envString(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return def
+}
+
+// envInt reads an integer setting, falling back to def when unset or invalid.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid %s: %s\n", name, v)
+		return def
+	}
+
+	return n
+}
+
+// envFloat reads a decimal setting, falling back to def when unset or invalid.
+func envFloat(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("Invalid %s: %s\n", name, v)
+		return def
+	}
+
+	return f
+}
+
+func minAmount(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// capBuy limits a buy of ntvAmount of currency c to what can be paid for with avlBtc.
+// Returns the amount to buy and the BTC it will consume.
+func capBuy(rateSvc *rates.RateSvc, c coinbase.Currency, ntvAmount int64, avlBtc int64) (int64, int64) {
+	cost, err := rateSvc.Convert(c, coinbase.CurrencyBtc, ntvAmount)
+	if err != nil {
+		return 0, 0
+	}
+	if cost <= avlBtc {
+		return ntvAmount, cost
+	}
+
+	affordable, err := rateSvc.Convert(coinbase.CurrencyBtc, c, avlBtc)
+	if err != nil {
+		return 0, 0
+	}
+
+	return affordable, avlBtc
+}
+
 type distribution struct {
 	totalAssets int64
 	ntvBtcBalance int64
@@ -188,7 +604,7 @@ type distribution struct {
 	diffLtc int64
 }
 
-func computeDistribution(rateSvc *rates.RateSvc, balanceSvc *balances.BalanceSvc) (*distribution, error) {
+func computeDistribution(rateSvc rateSource, balanceSvc balanceSource) (*distribution, error) {
 	// Check balance
 	btcNtvBal, ok := balanceSvc.GetNativeBalance(coinbase.CurrencyBtc)
 	if !ok {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/tobyjsullivan/btc-frogger/coinbase"
@@ -14,16 +15,21 @@ const (
 )
 
 type BalanceSvc struct {
-	conn        *coinbase.Conn
-	ntvBalances map[coinbase.Currency]int64
-	logger      *log.Logger
+	conn   *coinbase.Conn
+	logger *log.Logger
+
+	mx           sync.Mutex
+	ntvBalances  map[coinbase.Currency]int64
+	ntvAvailable map[coinbase.Currency]int64
+	updated      time.Time
 }
 
 func NewService(ctx context.Context, conn *coinbase.Conn) *BalanceSvc {
 	svc := &BalanceSvc{
-		conn:        conn,
-		ntvBalances: make(map[coinbase.Currency]int64),
-		logger:      log.New(os.Stdout, "[balances] ", 0),
+		conn:         conn,
+		ntvBalances:  make(map[coinbase.Currency]int64),
+		ntvAvailable: make(map[coinbase.Currency]int64),
+		logger:       log.New(os.Stdout, "[balances] ", 0),
 	}
 
 	go svc.loop(ctx)
@@ -31,12 +37,34 @@ func NewService(ctx context.Context, conn *coinbase.Conn) *BalanceSvc {
 	return svc
 }
 
+// GetNativeBalance returns the total balance for the currency, including funds on hold in open orders.
 func (svc *BalanceSvc) GetNativeBalance(c coinbase.Currency) (int64, bool) {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
 	val, ok := svc.ntvBalances[c]
 
 	return val, ok
 }
 
+// GetAvailableBalance returns the portion of the balance which is free to be used for new orders.
+func (svc *BalanceSvc) GetAvailableBalance(c coinbase.Currency) (int64, bool) {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	val, ok := svc.ntvAvailable[c]
+
+	return val, ok
+}
+
+// LastUpdated is when balances were last refreshed from the exchange. Zero if never.
+func (svc *BalanceSvc) LastUpdated() time.Time {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	return svc.updated
+}
+
 func (svc *BalanceSvc) loop(ctx context.Context) {
 	ticker := time.NewTicker(loopDuration)
 
@@ -57,9 +85,14 @@ func (svc *BalanceSvc) updateBalances() error {
 		return err
 	}
 
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
 	for _, acct := range accounts {
 		svc.ntvBalances[acct.Currency] = acct.Balance
+		svc.ntvAvailable[acct.Currency] = acct.Available
 	}
+	svc.updated = time.Now()
 
 	return nil
 }
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"strconv"
 
 	"github.com/satori/go.uuid"
 )
@@ -26,9 +25,13 @@ func (conn *Conn) GetAccounts() ([]*Account, error) {
 		Currency  string `json:"currency"`
 		Balance   string `json:"balance"`
 		Available string `json:"available"`
+		Hold      string `json:"hold"`
 	}
 	decoder := json.NewDecoder(resp.Body)
 	err = decoder.Decode(&accountsResp)
+	if err != nil {
+		return []*Account{}, err
+	}
 
 	out := []*Account{}
 	for _, acct := range accountsResp {
@@ -37,17 +40,27 @@ func (conn *Conn) GetAccounts() ([]*Account, error) {
 			return []*Account{}, err
 		}
 
-		fBalance, err := strconv.ParseFloat(acct.Balance, 64)
+		balance, err := ParseAmount(acct.Balance)
+		if err != nil {
+			return []*Account{}, err
+		}
+
+		available, err := ParseAmount(acct.Available)
 		if err != nil {
 			return []*Account{}, err
 		}
 
-		balance := int64(fBalance * float64(AmountCoin))
+		hold, err := ParseAmount(acct.Hold)
+		if err != nil {
+			return []*Account{}, err
+		}
 
 		out = append(out, &Account{
-			ID:       id,
-			Currency: Currency(acct.Currency),
-			Balance:  balance,
+			ID:        id,
+			Currency:  Currency(acct.Currency),
+			Balance:   balance,
+			Available: available,
+			Hold:      hold,
 		})
 	}
 
@@ -57,5 +70,10 @@ func (conn *Conn) GetAccounts() ([]*Account, error) {
 type Account struct {
 	ID       uuid.UUID
 	Currency Currency
-	Balance  int64
+	// Balance is the total funds in the account, including those on hold.
+	Balance int64
+	// Available is the portion of Balance that can be used for new orders.
+	Available int64
+	// Hold is the portion of Balance locked in open orders.
+	Hold int64
 }
@@ -0,0 +1,98 @@
+package coinbase
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const amountDecimals = 8
+
+// ParseAmount converts a decimal string as returned by the API (eg, "1.2345000000000000")
+// into a native amount without going through a float. Digits beyond the eighth decimal
+// place are truncated.
+func ParseAmount(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty amount")
+	}
+
+	neg := false
+	switch s[0] {
+	case '-':
+		neg = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" && frac == "" {
+		return 0, errors.New("invalid amount: " + s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if !isDigits(whole) || (frac != "" && !isDigits(frac)) {
+		return 0, errors.New("invalid amount: " + s)
+	}
+
+	if len(frac) > amountDecimals {
+		frac = frac[:amountDecimals]
+	}
+	frac += strings.Repeat("0", amountDecimals-len(frac))
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if w > (math.MaxInt64-f)/AmountCoin {
+		return 0, errors.New("amount out of range: " + s)
+	}
+
+	amount := w*AmountCoin + f
+	if neg {
+		amount = -amount
+	}
+
+	return amount, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseOptionalAmount is ParseAmount for fields which the API omits when not applicable.
+func parseOptionalAmount(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	return ParseAmount(s)
+}
+
+// FormatAmount renders a native amount as the decimal string the API expects.
+func FormatAmount(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%08d", sign, amount/AmountCoin, amount%AmountCoin)
+}
@@ -0,0 +1,67 @@
+package coinbase
+
+import (
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "1", want: 100000000},
+		{in: "1.5", want: 150000000},
+		{in: "0.00000001", want: 1},
+		{in: "1.2345000000000000", want: 123450000},
+		{in: "0.123456789", want: 12345678},
+		{in: ".5", want: 50000000},
+		{in: "5.", want: 500000000},
+		{in: "-2.25", want: -225000000},
+		{in: "+3", want: 300000000},
+		{in: " 4.1 ", want: 410000000},
+		{in: "92233720368.54775807", want: 9223372036854775807},
+		{in: "", wantErr: true},
+		{in: ".", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "1.+5", wantErr: true},
+		{in: "1.-5", wantErr: true},
+		{in: "+-1", wantErr: true},
+		{in: "--1", wantErr: true},
+		{in: "1.2.3", wantErr: true},
+		{in: "1e8", wantErr: true},
+		{in: "92233720368.54775808", wantErr: true},
+		{in: "92233720369", wantErr: true},
+		{in: "99999999999999999999", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseAmount(%q) = %d, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAmount(%q) unexpected error: %s", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmount(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatAmountRoundTrip(t *testing.T) {
+	for _, amount := range []int64{0, 1, 99999999, 100000000, 123456789, -150000000} {
+		got, err := ParseAmount(FormatAmount(amount))
+		if err != nil {
+			t.Fatalf("ParseAmount(FormatAmount(%d)): %s", amount, err)
+		}
+		if got != amount {
+			t.Errorf("round trip of %d gave %d", amount, got)
+		}
+	}
+}
@@ -1,11 +1,12 @@
 package coinbase
 
 import (
-	"fmt"
-	"net/http"
 	"encoding/json"
 	"errors"
-	"strconv"
+	"fmt"
+	"net/http"
+
+	"github.com/satori/go.uuid"
 )
 
 type Book struct {
@@ -13,13 +14,49 @@ type Book struct {
 	Ask int64
 }
 
+var ErrEmptyBookSide = errors.New("order book side is empty")
+
+// CurrentBook returns the best bid and ask for the product.
 func (c *Conn) CurrentBook(p ProductID) (*Book, error) {
-	endpointUrl := getEndpointUrl(fmt.Sprintf("/products/%s/book", p))
+	depth, err := c.fetchDepth(p, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Book{
+		Bid: depth.Bids[0].Price,
+		Ask: depth.Asks[0].Price,
+	}, nil
+}
+
+// BookLevel is the aggregate of all orders resting at a price.
+type BookLevel struct {
+	Price     int64
+	Size      int64
+	NumOrders int
+}
+
+// Depth is a level 2 (aggregated) snapshot of the order book. Both sides are ordered best
+// price first.
+type Depth struct {
+	Sequence uint64
+	Bids     []*BookLevel
+	Asks     []*BookLevel
+}
+
+// CurrentDepth returns the top 50 aggregated price levels on each side of the book.
+func (c *Conn) CurrentDepth(p ProductID) (*Depth, error) {
+	return c.fetchDepth(p, 2)
+}
+
+func (c *Conn) fetchDepth(p ProductID, level int) (*Depth, error) {
+	endpointUrl := getEndpointUrl(fmt.Sprintf("/products/%s/book", p)) + fmt.Sprintf("?level=%d", level)
 
 	resp, err := c.Requester.makeRequest(http.MethodGet, endpointUrl, nil, false)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.New("Unexpected status code: " + resp.Status)
@@ -27,8 +64,9 @@ func (c *Conn) CurrentBook(p ProductID) (*Book, error) {
 
 	// Parse the JSON response
 	var jsResp struct {
-		Bids [][]interface{} `json:"bids"`
-		Asks [][]interface{} `json:"asks"`
+		Sequence uint64           `json:"sequence"`
+		Bids     [][3]interface{} `json:"bids"`
+		Asks     [][3]interface{} `json:"asks"`
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -37,24 +75,183 @@ func (c *Conn) CurrentBook(p ProductID) (*Book, error) {
 		return nil, err
 	}
 
-	strBid := jsResp.Bids[0][0].(string)
-	strAsk := jsResp.Asks[0][0].(string)
+	bids, err := parseBookLevels(jsResp.Bids)
+	if err != nil {
+		return nil, err
+	}
+
+	asks, err := parseBookLevels(jsResp.Asks)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bids) == 0 || len(asks) == 0 {
+		return nil, ErrEmptyBookSide
+	}
+
+	return &Depth{
+		Sequence: jsResp.Sequence,
+		Bids:     bids,
+		Asks:     asks,
+	}, nil
+}
+
+func parseBookLevels(entries [][3]interface{}) ([]*BookLevel, error) {
+	out := make([]*BookLevel, 0, len(entries))
+	for _, e := range entries {
+		strPrice, ok := e[0].(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unexpected price: %v", e[0]))
+		}
+		strSize, ok := e[1].(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unexpected size: %v", e[1]))
+		}
+		numOrders, ok := e[2].(float64)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unexpected order count: %v", e[2]))
+		}
+
+		price, err := ParseAmount(strPrice)
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := ParseAmount(strSize)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, &BookLevel{
+			Price:     price,
+			Size:      size,
+			NumOrders: int(numOrders),
+		})
+	}
+
+	return out, nil
+}
+
+// Levels returns the resting orders on a side of the book: bids for SideBuy and asks for SideSell.
+func (d *Depth) Levels(side OrderSide) []*BookLevel {
+	if side == SideBuy {
+		return d.Bids
+	}
+	return d.Asks
+}
+
+// CumulativeSize returns the total size resting on the side at prices as good as or better than price.
+func (d *Depth) CumulativeSize(side OrderSide, price int64) int64 {
+	var total int64
+	for _, lvl := range d.Levels(side) {
+		if (side == SideBuy && lvl.Price < price) || (side == SideSell && lvl.Price > price) {
+			break
+		}
+		total += lvl.Size
+	}
+
+	return total
+}
+
+// FillPrice returns the limit price an order on the given side needs in order to fill size
+// immediately against the book. A buy walks up the asks and a sell walks down the bids.
+func (d *Depth) FillPrice(side OrderSide, size int64) (int64, error) {
+	opposite := d.Asks
+	if side == SideSell {
+		opposite = d.Bids
+	}
+
+	var filled int64
+	for _, lvl := range opposite {
+		filled += lvl.Size
+		if filled >= size {
+			return lvl.Price, nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Insufficient depth to fill %s", fmtAmount(size)))
+}
+
+type BookOrder struct {
+	OrderID uuid.UUID
+	Price   int64
+	Size    int64
+}
+
+// FullBook is a level 3 (non-aggregated) snapshot of the order book. Sequence is the feed
+// sequence number the snapshot is consistent with.
+type FullBook struct {
+	Sequence uint64
+	Bids     []*BookOrder
+	Asks     []*BookOrder
+}
+
+func (c *Conn) CurrentFullBook(p ProductID) (*FullBook, error) {
+	endpointUrl := getEndpointUrl(fmt.Sprintf("/products/%s/book", p)) + "?level=3"
+
+	resp, err := c.Requester.makeRequest(http.MethodGet, endpointUrl, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Unexpected status code: " + resp.Status)
+	}
+
+	var jsResp struct {
+		Sequence uint64      `json:"sequence"`
+		Bids     [][3]string `json:"bids"`
+		Asks     [][3]string `json:"asks"`
+	}
 
-	fBid, err := strconv.ParseFloat(strBid, 64)
+	decoder := json.NewDecoder(resp.Body)
+	err = decoder.Decode(&jsResp)
 	if err != nil {
 		return nil, err
 	}
 
-	fAsk, err := strconv.ParseFloat(strAsk, 64)
+	bids, err := parseBookOrders(jsResp.Bids)
 	if err != nil {
 		return nil, err
 	}
 
-	bid := int64(fBid * float64(AmountCoin))
-	ask := int64(fAsk * float64(AmountCoin))
+	asks, err := parseBookOrders(jsResp.Asks)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Book{
-		Bid: bid,
-		Ask: ask,
+	return &FullBook{
+		Sequence: jsResp.Sequence,
+		Bids:     bids,
+		Asks:     asks,
 	}, nil
 }
+
+func parseBookOrders(entries [][3]string) ([]*BookOrder, error) {
+	out := make([]*BookOrder, 0, len(entries))
+	for _, e := range entries {
+		price, err := ParseAmount(e[0])
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := ParseAmount(e[1])
+		if err != nil {
+			return nil, err
+		}
+
+		orderId, err := uuid.FromString(e[2])
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, &BookOrder{
+			OrderID: orderId,
+			Price:   price,
+			Size:    size,
+		})
+	}
+
+	return out, nil
+}
@@ -0,0 +1,153 @@
+package coinbase
+
+import (
+	"testing"
+
+	"github.com/satori/go.uuid"
+)
+
+func testDepth() *Depth {
+	return &Depth{
+		Bids: []*BookLevel{
+			{Price: 100, Size: 1 * AmountCoin},
+			{Price: 99, Size: 2 * AmountCoin},
+			{Price: 97, Size: 3 * AmountCoin},
+		},
+		Asks: []*BookLevel{
+			{Price: 101, Size: 1 * AmountCoin},
+			{Price: 102, Size: 2 * AmountCoin},
+			{Price: 105, Size: 3 * AmountCoin},
+		},
+	}
+}
+
+func TestDepthLevels(t *testing.T) {
+	d := testDepth()
+	if got := d.Levels(SideBuy); got[0].Price != 100 {
+		t.Errorf("buy levels start at %d, want the bids", got[0].Price)
+	}
+	if got := d.Levels(SideSell); got[0].Price != 101 {
+		t.Errorf("sell levels start at %d, want the asks", got[0].Price)
+	}
+}
+
+func TestDepthCumulativeSize(t *testing.T) {
+	d := testDepth()
+	tests := []struct {
+		side  OrderSide
+		price int64
+		want  int64
+	}{
+		{side: SideBuy, price: 101, want: 0},
+		{side: SideBuy, price: 100, want: 1 * AmountCoin},
+		{side: SideBuy, price: 98, want: 3 * AmountCoin},
+		{side: SideBuy, price: 90, want: 6 * AmountCoin},
+		{side: SideSell, price: 100, want: 0},
+		{side: SideSell, price: 102, want: 3 * AmountCoin},
+		{side: SideSell, price: 104, want: 3 * AmountCoin},
+		{side: SideSell, price: 110, want: 6 * AmountCoin},
+	}
+
+	for _, tc := range tests {
+		if got := d.CumulativeSize(tc.side, tc.price); got != tc.want {
+			t.Errorf("%s at %d: got %d, want %d", tc.side, tc.price, got, tc.want)
+		}
+	}
+}
+
+func TestDepthFillPrice(t *testing.T) {
+	d := testDepth()
+	tests := []struct {
+		side    OrderSide
+		size    int64
+		want    int64
+		wantErr bool
+	}{
+		// A buy walks up the asks
+		{side: SideBuy, size: AmountCoin / 2, want: 101},
+		{side: SideBuy, size: 1 * AmountCoin, want: 101},
+		{side: SideBuy, size: 1*AmountCoin + 1, want: 102},
+		// Partway into the last level
+		{side: SideBuy, size: 4 * AmountCoin, want: 105},
+		{side: SideBuy, size: 6 * AmountCoin, want: 105},
+		{side: SideBuy, size: 6*AmountCoin + 1, wantErr: true},
+		// A sell walks down the bids
+		{side: SideSell, size: AmountCoin / 2, want: 100},
+		{side: SideSell, size: 3 * AmountCoin, want: 99},
+		{side: SideSell, size: 5 * AmountCoin, want: 97},
+		{side: SideSell, size: 7 * AmountCoin, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := d.FillPrice(tc.side, tc.size)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s %d: got %d, want an error", tc.side, tc.size, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %d: %s", tc.side, tc.size, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s %d: got %d, want %d", tc.side, tc.size, got, tc.want)
+		}
+	}
+
+	if _, err := (&Depth{}).FillPrice(SideBuy, 1); err == nil {
+		t.Error("filled against an empty book")
+	}
+}
+
+func TestParseBookLevels(t *testing.T) {
+	levels, err := parseBookLevels([][3]interface{}{
+		{"0.05", "1.5", float64(3)},
+		{"0.049", "0.25", float64(1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 2 {
+		t.Fatalf("got %d levels, want 2", len(levels))
+	}
+	if l := levels[0]; l.Price != 5000000 || l.Size != 150000000 || l.NumOrders != 3 {
+		t.Errorf("first level: %+v", l)
+	}
+	if l := levels[1]; l.Price != 4900000 || l.Size != 25000000 || l.NumOrders != 1 {
+		t.Errorf("second level: %+v", l)
+	}
+
+	for _, bad := range [][3]interface{}{
+		{0.05, "1", float64(1)},
+		{"0.05", 1.0, float64(1)},
+		{"0.05", "1", "1"},
+		{"x", "1", float64(1)},
+		{"0.05", "x", float64(1)},
+	} {
+		if _, err := parseBookLevels([][3]interface{}{bad}); err == nil {
+			t.Errorf("parsed %v", bad)
+		}
+	}
+}
+
+func TestParseBookOrders(t *testing.T) {
+	id := uuid.NewV4()
+	orders, err := parseBookOrders([][3]string{{"0.05", "1.5", id.String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].OrderID != id || orders[0].Price != 5000000 || orders[0].Size != 150000000 {
+		t.Errorf("got %+v", orders[0])
+	}
+
+	for _, bad := range [][3]string{
+		{"x", "1", id.String()},
+		{"0.05", "x", id.String()},
+		{"0.05", "1", "not-an-id"},
+	} {
+		if _, err := parseBookOrders([][3]string{bad}); err == nil {
+			t.Errorf("parsed %v", bad)
+		}
+	}
+}
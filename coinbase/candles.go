@@ -0,0 +1,65 @@
+package coinbase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Candle is a product's trading activity over one interval. Prices are plain floats like the
+// ticker's.
+type Candle struct {
+	Time   time.Time
+	Low    float64
+	High   float64
+	Open   float64
+	Close  float64
+	Volume float64
+}
+
+// MaxCandles is the most candles the exchange returns for a single request.
+const MaxCandles = 300
+
+// HistoricRates returns candles of the given granularity between start and end, newest first.
+// The range may span at most MaxCandles candles.
+func (c *Conn) HistoricRates(p ProductID, start, end time.Time, granularity time.Duration) ([]*Candle, error) {
+	query := url.Values{}
+	query.Set("start", start.UTC().Format(time.RFC3339))
+	query.Set("end", end.UTC().Format(time.RFC3339))
+	query.Set("granularity", fmt.Sprintf("%d", int(granularity.Seconds())))
+	endpointUrl := getEndpointUrl(fmt.Sprintf("/products/%s/candles", p)) + "?" + query.Encode()
+
+	resp, err := c.Requester.makeRequest(http.MethodGet, endpointUrl, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Unexpected status code: " + resp.Status)
+	}
+
+	// Each candle is [time, low, high, open, close, volume]
+	var jsResp [][6]float64
+	decoder := json.NewDecoder(resp.Body)
+	if err := decoder.Decode(&jsResp); err != nil {
+		return nil, err
+	}
+
+	out := make([]*Candle, 0, len(jsResp))
+	for _, c := range jsResp {
+		out = append(out, &Candle{
+			Time:   time.Unix(int64(c[0]), 0).UTC(),
+			Low:    c[1],
+			High:   c[2],
+			Open:   c[3],
+			Close:  c[4],
+			Volume: c[5],
+		})
+	}
+
+	return out, nil
+}
@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -34,9 +35,37 @@ const (
 	QuoteIncrement = 1000
 )
 
+var ErrNotFound = errors.New("not found")
+
 type ProductID string
 type Currency string
 
+// BaseCurrency is the currency being bought or sold, eg ETH for ETH-BTC.
+func (p ProductID) BaseCurrency() Currency {
+	return Currency(strings.SplitN(string(p), "-", 2)[0])
+}
+
+// QuoteCurrency is the currency prices are expressed in, eg BTC for ETH-BTC.
+func (p ProductID) QuoteCurrency() Currency {
+	parts := strings.SplitN(string(p), "-", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return Currency(parts[1])
+}
+
+// BtcProduct returns the product which trades c against BTC.
+func BtcProduct(c Currency) (ProductID, bool) {
+	switch c {
+	case CurrencyEth:
+		return ProductEthBtc, true
+	case CurrencyLtc:
+		return ProductLtcBtc, true
+	default:
+		return "", false
+	}
+}
+
 type SignedRequester struct {
 	ApiAccessKey  string
 	ApiSecretKey  string
@@ -117,11 +146,18 @@ func (r *SignedRequester) doMakeRequest(method string, urlStr string, body io.Re
 		req.Header.Add("CB-ACCESS-PASSPHRASE", r.ApiPassphrase)
 	}
 
+	start := time.Now()
 	resp, err := http.DefaultClient.Do(req)
+	observeRequest(method, requestPath, start, resp, err)
 	if err != nil {
 		return nil, err
 	}
 
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		log.Println("request error:", resp.StatusCode)
 		var errResp struct {
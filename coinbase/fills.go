@@ -0,0 +1,136 @@
+package coinbase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+type Fill struct {
+	TradeID   int64
+	OrderID   uuid.UUID
+	ProductID ProductID
+	Side      OrderSide
+	Price     int64
+	Size      int64
+	Fee       int64
+	// "M" when our order was the maker, "T" when it took liquidity
+	Liquidity string
+	CreatedAt time.Time
+}
+
+// ListFills returns the most recent fills for the product, newest first.
+func (conn *Conn) ListFills(p ProductID) ([]*Fill, error) {
+	fills, _, err := conn.listFillsPage(p, "")
+	return fills, err
+}
+
+// AllFills pages back through the product's fill history until it reaches since, returning
+// every fill at or after it, newest first. A zero since fetches the entire history.
+func (conn *Conn) AllFills(p ProductID, since time.Time) ([]*Fill, error) {
+	var out []*Fill
+	cursor := ""
+	for {
+		fills, next, err := conn.listFillsPage(p, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range fills {
+			if f.CreatedAt.Before(since) {
+				return out, nil
+			}
+			out = append(out, f)
+		}
+
+		if len(fills) == 0 || next == "" {
+			return out, nil
+		}
+		cursor = next
+
+		// Stay inside the private endpoint rate limit
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// listFillsPage fetches one page of fills older than cursor, or the newest page for an empty
+// cursor. Returns the cursor for the next older page.
+func (conn *Conn) listFillsPage(p ProductID, cursor string) ([]*Fill, string, error) {
+	endpointUrl := getEndpointUrl("/fills") + fmt.Sprintf("?product_id=%s", p)
+	if cursor != "" {
+		endpointUrl += "&after=" + cursor
+	}
+
+	resp, err := conn.Requester.makeRequest(http.MethodGet, endpointUrl, nil, true)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New("Unexpected status code: " + resp.Status)
+	}
+	next := resp.Header.Get("CB-AFTER")
+
+	var fillsResp []struct {
+		TradeID   int64  `json:"trade_id"`
+		OrderID   string `json:"order_id"`
+		ProductID string `json:"product_id"`
+		Side      string `json:"side"`
+		Price     string `json:"price"`
+		Size      string `json:"size"`
+		Fee       string `json:"fee"`
+		Liquidity string `json:"liquidity"`
+		CreatedAt string `json:"created_at"`
+	}
+	decoder := json.NewDecoder(resp.Body)
+	if err := decoder.Decode(&fillsResp); err != nil {
+		return nil, "", err
+	}
+
+	out := make([]*Fill, 0, len(fillsResp))
+	for _, f := range fillsResp {
+		orderId, err := uuid.FromString(f.OrderID)
+		if err != nil {
+			return nil, "", err
+		}
+
+		price, err := ParseAmount(f.Price)
+		if err != nil {
+			return nil, "", err
+		}
+
+		size, err := ParseAmount(f.Size)
+		if err != nil {
+			return nil, "", err
+		}
+
+		fee, err := parseOptionalAmount(f.Fee)
+		if err != nil {
+			return nil, "", err
+		}
+
+		createdAt, err := time.Parse(time.RFC3339Nano, f.CreatedAt)
+		if err != nil {
+			return nil, "", err
+		}
+
+		out = append(out, &Fill{
+			TradeID:   f.TradeID,
+			OrderID:   orderId,
+			ProductID: ProductID(f.ProductID),
+			Side:      OrderSide(f.Side),
+			Price:     price,
+			Size:      size,
+			Fee:       fee,
+			Liquidity: f.Liquidity,
+			CreatedAt: createdAt,
+		})
+	}
+
+	return out, next, nil
+}
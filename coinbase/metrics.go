@@ -0,0 +1,46 @@
+package coinbase
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/metrics"
+)
+
+var (
+	restLatency = metrics.NewHistogramVec("frogger_coinbase_request_duration_seconds",
+		"Latency of REST requests to the exchange.", nil, "method", "endpoint")
+	apiErrors = metrics.NewCounterVec("frogger_coinbase_api_errors_total",
+		"Failed REST requests to the exchange by type.", "type")
+)
+
+// observeRequest records the latency and outcome of a single REST request.
+func observeRequest(method string, path string, start time.Time, resp *http.Response, err error) {
+	restLatency.With(method, endpointLabel(path)).Observe(time.Since(start).Seconds())
+
+	switch {
+	case err != nil:
+		apiErrors.With("network").Inc()
+	case resp.StatusCode == http.StatusNotFound:
+		apiErrors.With("not_found").Inc()
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErrors.With("rate_limited").Inc()
+	case resp.StatusCode >= 500:
+		apiErrors.With("server").Inc()
+	case resp.StatusCode >= 400:
+		apiErrors.With("client").Inc()
+	}
+}
+
+// endpointLabel reduces a request path to its stable parts so IDs don't blow up the series,
+// eg /products/ETH-BTC/book becomes /products/book.
+func endpointLabel(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	label := "/" + parts[0]
+	if parts[0] == "products" && len(parts) > 2 {
+		label += "/" + parts[2]
+	}
+
+	return label
+}
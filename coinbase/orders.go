@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/satori/go.uuid"
 )
 
 const (
@@ -16,47 +18,118 @@ const (
 
 type OrderSide string
 
-func (conn *Conn) PlaceOrder(c Currency, side OrderSide, amountNative int64, price int64) error {
-	log.Printf("PLACE ORDER: %s %s %s", side, fmtAmount(amountNative), c)
-
-	var productId ProductID
-	switch c {
-	case CurrencyEth:
-		productId = ProductEthBtc
-	case CurrencyLtc:
-		productId = ProductLtcBtc
-	default:
-		return errors.New(fmt.Sprintf("Unexpected currency: %s", c))
-	}
-
-	// Price must be a rounded multiple of QuoteIncrement or Coinbase will resp w/ BAD_REQUEST
-	if price % QuoteIncrement != 0 {
-		rndUp := (price % QuoteIncrement) >= (QuoteIncrement / 2)
-		price = (price / QuoteIncrement) * QuoteIncrement
-		if rndUp {
-			price += QuoteIncrement
-		}
+type Order struct {
+	ID uuid.UUID
+	// Nil unless the order was placed with a client order ID
+	ClientOID  uuid.UUID
+	ProductID  ProductID
+	Side       OrderSide
+	Price      int64
+	Size       int64
+	FilledSize int64
+	FillFees   int64
+	Status     string
+	// Settled is true once the order is done and no longer affects balances
+	Settled      bool
+	RejectReason string
+}
+
+// orderJs is the wire format shared by the order endpoints.
+type orderJs struct {
+	ID           string `json:"id"`
+	ClientOID    string `json:"client_oid,omitempty"`
+	ProductID    string `json:"product_id"`
+	Side         string `json:"side"`
+	Price        string `json:"price"`
+	Size         string `json:"size"`
+	FilledSize   string `json:"filled_size"`
+	FillFees     string `json:"fill_fees"`
+	Status       string `json:"status"`
+	Settled      bool   `json:"settled"`
+	RejectReason string `json:"reject_reason,omitempty"`
+}
+
+func (js *orderJs) toOrder() (*Order, error) {
+	id, err := uuid.FromString(js.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Order{
+		ID:           id,
+		ClientOID:    uuid.FromStringOrNil(js.ClientOID),
+		ProductID:    ProductID(js.ProductID),
+		Side:         OrderSide(js.Side),
+		Status:       js.Status,
+		Settled:      js.Settled,
+		RejectReason: js.RejectReason,
+	}
+
+	if o.Price, err = parseOptionalAmount(js.Price); err != nil {
+		return nil, err
+	}
+	if o.Size, err = parseOptionalAmount(js.Size); err != nil {
+		return nil, err
+	}
+	if o.FilledSize, err = parseOptionalAmount(js.FilledSize); err != nil {
+		return nil, err
+	}
+	if o.FillFees, err = parseOptionalAmount(js.FillFees); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// PlaceOrder submits a post-only limit order. An order rejected by the exchange is returned
+// with Status "rejected" rather than as an error so the caller can simply try again later.
+func (conn *Conn) PlaceOrder(c Currency, side OrderSide, amountNative int64, price int64) (*Order, error) {
+	productId, ok := BtcProduct(c)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unexpected currency: %s", c))
 	}
 
-	reqBody := struct {
-		Price     string `json:"price"`
-		Size      string `json:"size"`
-		Side      string `json:"side"`
-		Type      string `json:"type"`
-		ProductID string `json:"product_id"`
-		PostOnly  bool   `json:"post_only"`
-	}{
-		Price:     fmt.Sprintf("%.8f", float64(price)/AmountCoin),
-		Size:      fmt.Sprintf("%.8f", float64(amountNative)/AmountCoin),
-		Side:      string(side),
-		Type:      "limit",
-		ProductID: string(productId),
+	return conn.SubmitOrder(&OrderRequest{
+		ProductID: productId,
+		Side:      side,
+		Type:      OrderTypeLimit,
+		Price:     price,
+		Size:      amountNative,
 		PostOnly:  true,
+	})
+}
+
+// PlaceIOCOrder submits an immediate-or-cancel limit order which takes liquidity up to price.
+// Any unfilled remainder is cancelled by the exchange.
+func (conn *Conn) PlaceIOCOrder(c Currency, side OrderSide, amountNative int64, price int64) (*Order, error) {
+	productId, ok := BtcProduct(c)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unexpected currency: %s", c))
 	}
 
-	reqJs, err := json.Marshal(&reqBody)
+	return conn.SubmitOrder(&OrderRequest{
+		ProductID:   productId,
+		Side:        side,
+		Type:        OrderTypeLimit,
+		Price:       price,
+		Size:        amountNative,
+		TimeInForce: TimeInForceIOC,
+	})
+}
+
+// SubmitOrder validates and places any kind of order. As with PlaceOrder, a rejection by the
+// exchange is reported through the returned order's Status.
+func (conn *Conn) SubmitOrder(req *OrderRequest) (*Order, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("PLACE ORDER: %s %s %s %s", req.Type, req.Side, fmtAmount(req.Size+req.Funds), req.ProductID)
+
+	reqBody := req.toJs()
+	reqJs, err := json.Marshal(reqBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	endpointUrl := getEndpointUrl("/orders")
 
@@ -68,22 +141,108 @@ func (conn *Conn) PlaceOrder(c Currency, side OrderSide, amountNative int64, pri
 	resp, err := conn.Requester.makeRequest(http.MethodPost, endpointUrl, &buf, true)
 	if err != nil {
 		log.Println("order:", err)
-		return err
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var orderResp orderJs
+	decoder := json.NewDecoder(resp.Body)
+	if err := decoder.Decode(&orderResp); err != nil {
+		return nil, err
+	}
+
+	order, err := orderResp.toOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	if order.Status == "rejected" {
+		log.Println("order rejected:", order.RejectReason)
+		return order, nil // Return peacefully so we can try again next time
+	}
+
+	log.Println("order resp:", resp.Status, order.Status)
+
+	return order, nil
+}
+
+// GetOrder returns the current state of an order. Orders which were cancelled before any
+// fill are purged by the exchange and result in ErrNotFound.
+func (conn *Conn) GetOrder(id uuid.UUID) (*Order, error) {
+	endpointUrl := getEndpointUrl(fmt.Sprintf("/orders/%s", id))
+
+	resp, err := conn.Requester.makeRequest(http.MethodGet, endpointUrl, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var orderResp orderJs
+	decoder := json.NewDecoder(resp.Body)
+	if err := decoder.Decode(&orderResp); err != nil {
+		return nil, err
+	}
+
+	return orderResp.toOrder()
+}
+
+// GetOrderByClientOID looks up an order by the client order ID it was placed with. This is the
+// only way to find an order whose placement response was lost.
+func (conn *Conn) GetOrderByClientOID(clientOid uuid.UUID) (*Order, error) {
+	endpointUrl := getEndpointUrl(fmt.Sprintf("/orders/client:%s", clientOid))
+
+	resp, err := conn.Requester.makeRequest(http.MethodGet, endpointUrl, nil, true)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	var orderResp struct {
-		RejectReason string `json:"reject_reason,omitempty"`
-		Status       string `json:"status"`
+	var orderResp orderJs
+	decoder := json.NewDecoder(resp.Body)
+	if err := decoder.Decode(&orderResp); err != nil {
+		return nil, err
 	}
+
+	return orderResp.toOrder()
+}
+
+// ListOpenOrders returns the orders still working on the book, up to the API page size of 100.
+func (conn *Conn) ListOpenOrders() ([]*Order, error) {
+	endpointUrl := getEndpointUrl("/orders") + "?status=open&status=pending&status=active"
+
+	resp, err := conn.Requester.makeRequest(http.MethodGet, endpointUrl, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ordersResp []orderJs
 	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(&orderResp)
+	if err := decoder.Decode(&ordersResp); err != nil {
+		return nil, err
+	}
 
-	if orderResp.Status == "rejected" {
-		log.Println("order rejected:", orderResp.RejectReason)
-		return nil // Return peacefully so we can try again next time
+	out := make([]*Order, 0, len(ordersResp))
+	for _, js := range ordersResp {
+		o, err := js.toOrder()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
 	}
 
-	log.Println("order resp:", resp.Status, orderResp.Status)
+	return out, nil
+}
+
+func (conn *Conn) CancelOrder(id uuid.UUID) error {
+	endpointUrl := getEndpointUrl(fmt.Sprintf("/orders/%s", id))
+
+	resp, err := conn.Requester.makeRequest(http.MethodDelete, endpointUrl, nil, true)
+	if err != nil {
+		log.Println("cancel order:", err)
+		return err
+	}
+	resp.Body.Close()
 
 	return nil
 }
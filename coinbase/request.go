@@ -0,0 +1,209 @@
+package coinbase
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	OrderTypeLimit  = OrderType("limit")
+	OrderTypeMarket = OrderType("market")
+
+	TimeInForceGTC = TimeInForce("GTC")
+	TimeInForceGTT = TimeInForce("GTT")
+	TimeInForceIOC = TimeInForce("IOC")
+	TimeInForceFOK = TimeInForce("FOK")
+
+	CancelAfterMinute = CancelAfter("min")
+	CancelAfterHour   = CancelAfter("hour")
+	CancelAfterDay    = CancelAfter("day")
+
+	// StopLoss triggers when the last trade price falls to StopPrice; StopEntry when it rises to it.
+	StopLoss  = StopType("loss")
+	StopEntry = StopType("entry")
+
+	// Self-trade prevention: decrease and cancel, cancel oldest, cancel newest, cancel both.
+	StpDecrementAndCancel = SelfTradePrevention("dc")
+	StpCancelOldest       = SelfTradePrevention("co")
+	StpCancelNewest       = SelfTradePrevention("cn")
+	StpCancelBoth         = SelfTradePrevention("cb")
+)
+
+type OrderType string
+type TimeInForce string
+type CancelAfter string
+type StopType string
+type SelfTradePrevention string
+
+// OrderRequest describes any order accepted by the exchange. Amounts are native.
+//
+// Limit orders require Price and Size. Market orders require exactly one of Size or Funds,
+// where Funds is the amount of quote currency to spend (buys) or receive (sells). Setting Stop
+// and StopPrice turns either into a stop order which only enters the book once triggered.
+type OrderRequest struct {
+	// Optional; lets the order be identified before the exchange has assigned an ID
+	ClientOID uuid.UUID
+	ProductID ProductID
+	Side      OrderSide
+	Type      OrderType
+
+	Price int64
+	Size  int64
+	Funds int64
+
+	// Limit orders only. Defaults to GTC.
+	TimeInForce TimeInForce
+	// Required with, and only valid for, GTT
+	CancelAfter CancelAfter
+	PostOnly    bool
+
+	Stop      StopType
+	StopPrice int64
+
+	SelfTradePrevention SelfTradePrevention
+}
+
+func (r *OrderRequest) Validate() error {
+	if r.ProductID == "" {
+		return errors.New("order: missing product")
+	}
+
+	if r.Side != SideBuy && r.Side != SideSell {
+		return errors.New(fmt.Sprintf("order: invalid side: %s", r.Side))
+	}
+
+	switch r.Type {
+	case OrderTypeLimit:
+		if r.Price <= 0 {
+			return errors.New("order: limit order requires a price")
+		}
+		if r.Size <= 0 {
+			return errors.New("order: limit order requires a size")
+		}
+		if r.Funds != 0 {
+			return errors.New("order: funds only valid for market orders")
+		}
+
+		switch r.TimeInForce {
+		case "", TimeInForceGTC, TimeInForceGTT, TimeInForceIOC, TimeInForceFOK:
+		default:
+			return errors.New(fmt.Sprintf("order: invalid time in force: %s", r.TimeInForce))
+		}
+
+		if r.TimeInForce == TimeInForceGTT {
+			switch r.CancelAfter {
+			case CancelAfterMinute, CancelAfterHour, CancelAfterDay:
+			default:
+				return errors.New(fmt.Sprintf("order: GTT requires cancel after of min, hour or day; got %q", r.CancelAfter))
+			}
+		} else if r.CancelAfter != "" {
+			return errors.New("order: cancel after only valid with GTT")
+		}
+
+		if r.PostOnly && (r.TimeInForce == TimeInForceIOC || r.TimeInForce == TimeInForceFOK) {
+			return errors.New("order: post only is invalid with IOC or FOK")
+		}
+	case OrderTypeMarket:
+		if (r.Size > 0) == (r.Funds > 0) {
+			return errors.New("order: market order requires exactly one of size or funds")
+		}
+		if r.Size < 0 || r.Funds < 0 {
+			return errors.New("order: negative amount")
+		}
+		if r.Price != 0 {
+			return errors.New("order: price not valid for market orders")
+		}
+		if r.TimeInForce != "" || r.CancelAfter != "" || r.PostOnly {
+			return errors.New("order: time in force and post only not valid for market orders")
+		}
+	default:
+		return errors.New(fmt.Sprintf("order: invalid type: %s", r.Type))
+	}
+
+	switch r.Stop {
+	case "":
+		if r.StopPrice != 0 {
+			return errors.New("order: stop price requires a stop type")
+		}
+	case StopLoss, StopEntry:
+		if r.StopPrice <= 0 {
+			return errors.New("order: stop order requires a stop price")
+		}
+	default:
+		return errors.New(fmt.Sprintf("order: invalid stop: %s", r.Stop))
+	}
+
+	switch r.SelfTradePrevention {
+	case "", StpDecrementAndCancel, StpCancelOldest, StpCancelNewest, StpCancelBoth:
+	default:
+		return errors.New(fmt.Sprintf("order: invalid self-trade prevention: %s", r.SelfTradePrevention))
+	}
+
+	return nil
+}
+
+// requestJs is the wire format of an order request. Empty fields are omitted.
+type requestJs struct {
+	ClientOID   string `json:"client_oid,omitempty"`
+	ProductID   string `json:"product_id"`
+	Side        string `json:"side"`
+	Type        string `json:"type"`
+	Price       string `json:"price,omitempty"`
+	Size        string `json:"size,omitempty"`
+	Funds       string `json:"funds,omitempty"`
+	TimeInForce string `json:"time_in_force,omitempty"`
+	CancelAfter string `json:"cancel_after,omitempty"`
+	PostOnly    bool   `json:"post_only,omitempty"`
+	Stop        string `json:"stop,omitempty"`
+	StopPrice   string `json:"stop_price,omitempty"`
+	Stp         string `json:"stp,omitempty"`
+}
+
+func (r *OrderRequest) toJs() *requestJs {
+	js := &requestJs{
+		ProductID:   string(r.ProductID),
+		Side:        string(r.Side),
+		Type:        string(r.Type),
+		TimeInForce: string(r.TimeInForce),
+		CancelAfter: string(r.CancelAfter),
+		PostOnly:    r.PostOnly,
+		Stop:        string(r.Stop),
+		Stp:         string(r.SelfTradePrevention),
+	}
+
+	if r.ClientOID != uuid.Nil {
+		js.ClientOID = r.ClientOID.String()
+	}
+	if r.Price > 0 {
+		js.Price = FormatAmount(roundToIncrement(r.Price))
+	}
+	if r.Size > 0 {
+		js.Size = FormatAmount(r.Size)
+	}
+	if r.Funds > 0 {
+		js.Funds = FormatAmount(r.Funds)
+	}
+	if r.StopPrice > 0 {
+		js.StopPrice = FormatAmount(roundToIncrement(r.StopPrice))
+	}
+
+	return js
+}
+
+// roundToIncrement rounds price to the nearest multiple of QuoteIncrement. Coinbase responds
+// with BAD_REQUEST to prices off the grid.
+func roundToIncrement(price int64) int64 {
+	if price%QuoteIncrement == 0 {
+		return price
+	}
+
+	rndUp := (price % QuoteIncrement) >= (QuoteIncrement / 2)
+	price = (price / QuoteIncrement) * QuoteIncrement
+	if rndUp {
+		price += QuoteIncrement
+	}
+
+	return price
+}
@@ -0,0 +1,146 @@
+package coinbase
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/satori/go.uuid"
+)
+
+func limitOrder() *OrderRequest {
+	return &OrderRequest{
+		ProductID: ProductEthBtc,
+		Side:      SideBuy,
+		Type:      OrderTypeLimit,
+		Price:     5 * QuoteIncrement,
+		Size:      AmountCoin,
+	}
+}
+
+func marketOrder() *OrderRequest {
+	return &OrderRequest{
+		ProductID: ProductEthBtc,
+		Side:      SideSell,
+		Type:      OrderTypeMarket,
+		Size:      AmountCoin,
+	}
+}
+
+func TestOrderRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     func() *OrderRequest
+		edit    func(*OrderRequest)
+		wantErr bool
+	}{
+		{name: "limit", req: limitOrder},
+		{name: "limit post only", req: limitOrder, edit: func(r *OrderRequest) { r.PostOnly = true }},
+		{name: "limit IOC", req: limitOrder, edit: func(r *OrderRequest) { r.TimeInForce = TimeInForceIOC }},
+		{name: "limit GTT", req: limitOrder, edit: func(r *OrderRequest) { r.TimeInForce, r.CancelAfter = TimeInForceGTT, CancelAfterHour }},
+		{name: "limit stop loss", req: limitOrder, edit: func(r *OrderRequest) { r.Stop, r.StopPrice = StopLoss, 4*QuoteIncrement }},
+		{name: "market size", req: marketOrder},
+		{name: "market funds", req: marketOrder, edit: func(r *OrderRequest) { r.Size, r.Funds = 0, AmountCoin }},
+		{name: "market stop entry", req: marketOrder, edit: func(r *OrderRequest) { r.Stop, r.StopPrice = StopEntry, 6*QuoteIncrement }},
+		{name: "self-trade prevention", req: limitOrder, edit: func(r *OrderRequest) { r.SelfTradePrevention = StpCancelOldest }},
+
+		{name: "no product", req: limitOrder, edit: func(r *OrderRequest) { r.ProductID = "" }, wantErr: true},
+		{name: "bad side", req: limitOrder, edit: func(r *OrderRequest) { r.Side = "hold" }, wantErr: true},
+		{name: "bad type", req: limitOrder, edit: func(r *OrderRequest) { r.Type = "stop" }, wantErr: true},
+		{name: "limit without price", req: limitOrder, edit: func(r *OrderRequest) { r.Price = 0 }, wantErr: true},
+		{name: "limit without size", req: limitOrder, edit: func(r *OrderRequest) { r.Size = 0 }, wantErr: true},
+		{name: "limit with funds", req: limitOrder, edit: func(r *OrderRequest) { r.Funds = AmountCoin }, wantErr: true},
+		{name: "limit bad time in force", req: limitOrder, edit: func(r *OrderRequest) { r.TimeInForce = "DAY" }, wantErr: true},
+		{name: "GTT without cancel after", req: limitOrder, edit: func(r *OrderRequest) { r.TimeInForce = TimeInForceGTT }, wantErr: true},
+		{name: "GTT bad cancel after", req: limitOrder, edit: func(r *OrderRequest) { r.TimeInForce, r.CancelAfter = TimeInForceGTT, "week" }, wantErr: true},
+		{name: "cancel after without GTT", req: limitOrder, edit: func(r *OrderRequest) { r.CancelAfter = CancelAfterDay }, wantErr: true},
+		{name: "post only IOC", req: limitOrder, edit: func(r *OrderRequest) { r.PostOnly, r.TimeInForce = true, TimeInForceIOC }, wantErr: true},
+		{name: "post only FOK", req: limitOrder, edit: func(r *OrderRequest) { r.PostOnly, r.TimeInForce = true, TimeInForceFOK }, wantErr: true},
+		{name: "market size and funds", req: marketOrder, edit: func(r *OrderRequest) { r.Funds = AmountCoin }, wantErr: true},
+		{name: "market neither", req: marketOrder, edit: func(r *OrderRequest) { r.Size = 0 }, wantErr: true},
+		{name: "market negative", req: marketOrder, edit: func(r *OrderRequest) { r.Size = -1 }, wantErr: true},
+		{name: "market negative funds", req: marketOrder, edit: func(r *OrderRequest) { r.Size, r.Funds = AmountCoin, -1 }, wantErr: true},
+		{name: "market with price", req: marketOrder, edit: func(r *OrderRequest) { r.Price = QuoteIncrement }, wantErr: true},
+		{name: "market time in force", req: marketOrder, edit: func(r *OrderRequest) { r.TimeInForce = TimeInForceIOC }, wantErr: true},
+		{name: "market cancel after", req: marketOrder, edit: func(r *OrderRequest) { r.CancelAfter = CancelAfterMinute }, wantErr: true},
+		{name: "market post only", req: marketOrder, edit: func(r *OrderRequest) { r.PostOnly = true }, wantErr: true},
+		{name: "stop without price", req: limitOrder, edit: func(r *OrderRequest) { r.Stop = StopLoss }, wantErr: true},
+		{name: "stop price without stop", req: limitOrder, edit: func(r *OrderRequest) { r.StopPrice = QuoteIncrement }, wantErr: true},
+		{name: "bad stop", req: limitOrder, edit: func(r *OrderRequest) { r.Stop, r.StopPrice = "trailing", QuoteIncrement }, wantErr: true},
+		{name: "bad self-trade prevention", req: limitOrder, edit: func(r *OrderRequest) { r.SelfTradePrevention = "xx" }, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		req := tc.req()
+		if tc.edit != nil {
+			tc.edit(req)
+		}
+
+		err := req.Validate()
+		if tc.wantErr && err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+		if !tc.wantErr && err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+	}
+}
+
+func TestOrderRequestJSON(t *testing.T) {
+	oid := uuid.FromStringOrNil("6d6b5a2e-0d5b-4bb9-9a1e-1c3b7e8f6a10")
+	tests := []struct {
+		name string
+		req  *OrderRequest
+		want string
+	}{
+		{
+			name: "post only limit",
+			req: &OrderRequest{ClientOID: oid, ProductID: ProductEthBtc, Side: SideBuy, Type: OrderTypeLimit,
+				Price: 5000000, Size: 150000000, PostOnly: true},
+			want: `{"client_oid":"6d6b5a2e-0d5b-4bb9-9a1e-1c3b7e8f6a10","product_id":"ETH-BTC","side":"buy","type":"limit","price":"0.05000000","size":"1.50000000","post_only":true}`,
+		},
+		{
+			// Off-grid prices are rounded to the nearest increment
+			name: "IOC limit",
+			req: &OrderRequest{ProductID: ProductLtcBtc, Side: SideSell, Type: OrderTypeLimit,
+				Price: 1234567, Size: AmountCoin, TimeInForce: TimeInForceIOC},
+			want: `{"product_id":"LTC-BTC","side":"sell","type":"limit","price":"0.01235000","size":"1.00000000","time_in_force":"IOC"}`,
+		},
+		{
+			name: "GTT limit",
+			req: &OrderRequest{ProductID: ProductEthBtc, Side: SideBuy, Type: OrderTypeLimit,
+				Price: 5000000, Size: AmountCoin, TimeInForce: TimeInForceGTT, CancelAfter: CancelAfterHour},
+			want: `{"product_id":"ETH-BTC","side":"buy","type":"limit","price":"0.05000000","size":"1.00000000","time_in_force":"GTT","cancel_after":"hour"}`,
+		},
+		{
+			name: "market by size",
+			req:  &OrderRequest{ProductID: ProductEthBtc, Side: SideSell, Type: OrderTypeMarket, Size: 25000000},
+			want: `{"product_id":"ETH-BTC","side":"sell","type":"market","size":"0.25000000"}`,
+		},
+		{
+			name: "market by funds",
+			req:  &OrderRequest{ProductID: ProductEthBtc, Side: SideBuy, Type: OrderTypeMarket, Funds: 10000000},
+			want: `{"product_id":"ETH-BTC","side":"buy","type":"market","funds":"0.10000000"}`,
+		},
+		{
+			name: "stop loss limit",
+			req: &OrderRequest{ProductID: ProductEthBtc, Side: SideSell, Type: OrderTypeLimit,
+				Price: 4900000, Size: AmountCoin, Stop: StopLoss, StopPrice: 4950000, SelfTradePrevention: StpCancelBoth},
+			want: `{"product_id":"ETH-BTC","side":"sell","type":"limit","price":"0.04900000","size":"1.00000000","stop":"loss","stop_price":"0.04950000","stp":"cb"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		if err := tc.req.Validate(); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		got, err := json.Marshal(tc.req.toJs())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, tc.want)
+		}
+	}
+}
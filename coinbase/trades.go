@@ -0,0 +1,88 @@
+package coinbase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Trade is a public trade print on a product.
+type Trade struct {
+	TradeID int64
+	Price   int64
+	Size    int64
+	// The maker's side
+	Side OrderSide
+	Time time.Time
+}
+
+// RecentTrades returns the latest public trades on the product, newest first.
+func (c *Conn) RecentTrades(p ProductID) ([]*Trade, error) {
+	endpointUrl := getEndpointUrl(fmt.Sprintf("/products/%s/trades", p))
+
+	resp, err := c.Requester.makeRequest(http.MethodGet, endpointUrl, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Unexpected status code: " + resp.Status)
+	}
+
+	var jsResp []struct {
+		TradeID int64  `json:"trade_id"`
+		Price   string `json:"price"`
+		Size    string `json:"size"`
+		Side    string `json:"side"`
+		Time    string `json:"time"`
+	}
+	decoder := json.NewDecoder(resp.Body)
+	if err := decoder.Decode(&jsResp); err != nil {
+		return nil, err
+	}
+
+	out := make([]*Trade, 0, len(jsResp))
+	for _, t := range jsResp {
+		price, err := ParseAmount(t.Price)
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := ParseAmount(t.Size)
+		if err != nil {
+			return nil, err
+		}
+
+		at, err := time.Parse(time.RFC3339Nano, t.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, &Trade{
+			TradeID: t.TradeID,
+			Price:   price,
+			Size:    size,
+			Side:    OrderSide(t.Side),
+			Time:    at,
+		})
+	}
+
+	return out, nil
+}
+
+// VWAP is the volume weighted average price of the trades. Returns zero for no volume.
+func VWAP(trades []*Trade) float64 {
+	var notional, volume float64
+	for _, t := range trades {
+		notional += float64(t.Price) * float64(t.Size)
+		volume += float64(t.Size)
+	}
+	if volume == 0 {
+		return 0
+	}
+
+	return notional / volume / AmountCoin
+}
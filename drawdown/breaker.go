@@ -0,0 +1,193 @@
+package drawdown
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+type Action string
+
+const (
+	// Halt trading once tripped.
+	ActionPause = Action("pause")
+	// Rebalance towards the configured safe allocation once tripped.
+	ActionSafe = Action("safe")
+)
+
+// ParseAction validates an action name. The empty string means ActionPause.
+func ParseAction(s string) (Action, error) {
+	switch Action(s) {
+	case "":
+		return ActionPause, nil
+	case ActionPause, ActionSafe:
+		return Action(s), nil
+	default:
+		return "", errors.New(fmt.Sprintf("Unknown drawdown action: %s", s))
+	}
+}
+
+// Allocation is a target share of total assets per currency. BTC takes the remainder.
+type Allocation struct {
+	Eth float64
+	Ltc float64
+}
+
+type Config struct {
+	// Largest tolerated fall from the high-water mark, eg 0.2 for 20%. Zero disables.
+	MaxDrawdownBtc float64
+	MaxDrawdownUsd float64
+	Action         Action
+	Safe           Allocation
+	// High-water marks and the tripped state are saved here so they survive restarts. Delete
+	// the file to reset the breaker. Empty keeps state in memory only.
+	StatePath string
+}
+
+// Status is the breaker's view of the portfolio after the latest update.
+type Status struct {
+	HighBtc     int64   `json:"highBtc"`
+	HighUsd     float64 `json:"highUsd"`
+	DrawdownBtc float64 `json:"drawdownBtc"`
+	DrawdownUsd float64 `json:"drawdownUsd"`
+	Tripped     bool    `json:"tripped"`
+	Reason      string  `json:"reason,omitempty"`
+}
+
+// Breaker tracks the high-water mark of total assets in BTC and in USD and trips when either
+// drawdown crosses its threshold. Once tripped it stays tripped until Reset.
+type Breaker struct {
+	cfg    *Config
+	logger *log.Logger
+
+	mx     sync.Mutex
+	status Status
+}
+
+func NewBreaker(cfg *Config) *Breaker {
+	b := &Breaker{
+		cfg:    cfg,
+		logger: log.New(os.Stdout, "[drawdown] ", 0),
+	}
+
+	if cfg.StatePath != "" {
+		content, err := ioutil.ReadFile(cfg.StatePath)
+		if err == nil {
+			err = json.Unmarshal(content, &b.status)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			b.logger.Println("load state:", err)
+		}
+		if b.status.Tripped {
+			b.logger.Println("Starting tripped:", b.status.Reason)
+		}
+	}
+
+	return b
+}
+
+// Update records the latest total assets, in native BTC, and the BTC/USD rate. A zero usdRate
+// skips the USD measure.
+func (b *Breaker) Update(totalBtc int64, usdRate float64) Status {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	changed := false
+	if totalBtc > b.status.HighBtc {
+		b.status.HighBtc = totalBtc
+		changed = true
+	}
+	if b.status.HighBtc > 0 {
+		b.status.DrawdownBtc = 1.0 - float64(totalBtc)/float64(b.status.HighBtc)
+	}
+
+	if usdRate > 0 {
+		totalUsd := float64(totalBtc) / coinbase.AmountCoin * usdRate
+		if totalUsd > b.status.HighUsd {
+			b.status.HighUsd = totalUsd
+			changed = true
+		}
+		if b.status.HighUsd > 0 {
+			b.status.DrawdownUsd = 1.0 - totalUsd/b.status.HighUsd
+		}
+	}
+
+	if !b.status.Tripped {
+		if b.cfg.MaxDrawdownBtc > 0 && b.status.DrawdownBtc >= b.cfg.MaxDrawdownBtc {
+			b.trip(fmt.Sprintf("BTC drawdown %.2f%% from high of %s", b.status.DrawdownBtc*100,
+				coinbase.FormatAmount(b.status.HighBtc)))
+			changed = true
+		} else if b.cfg.MaxDrawdownUsd > 0 && b.status.DrawdownUsd >= b.cfg.MaxDrawdownUsd {
+			b.trip(fmt.Sprintf("USD drawdown %.2f%% from high of $%.2f", b.status.DrawdownUsd*100,
+				b.status.HighUsd))
+			changed = true
+		}
+	}
+
+	if changed {
+		b.save()
+	}
+
+	return b.status
+}
+
+// Status returns the state as of the last update.
+func (b *Breaker) Status() Status {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return b.status
+}
+
+func (b *Breaker) Action() Action {
+	return b.cfg.Action
+}
+
+func (b *Breaker) SafeAllocation() Allocation {
+	return b.cfg.Safe
+}
+
+// Reset clears a trip and restarts the high-water marks from the next update.
+func (b *Breaker) Reset() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.status = Status{}
+	b.save()
+}
+
+// trip must be called with mx held.
+func (b *Breaker) trip(reason string) {
+	b.status.Tripped = true
+	b.status.Reason = reason
+	b.logger.Println("TRIPPED:", reason)
+}
+
+// save must be called with mx held.
+func (b *Breaker) save() {
+	if b.cfg.StatePath == "" {
+		return
+	}
+
+	content, err := json.Marshal(&b.status)
+	if err != nil {
+		b.logger.Println("marshal state:", err)
+		return
+	}
+
+	// Write then rename so a crash never leaves a truncated state file
+	tmp := b.cfg.StatePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		b.logger.Println("save state:", err)
+		return
+	}
+	if err := os.Rename(tmp, b.cfg.StatePath); err != nil {
+		b.logger.Println("save state:", err)
+	}
+}
@@ -0,0 +1,178 @@
+package drawdown
+
+import (
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+const coin = int64(coinbase.AmountCoin)
+
+func testBreaker(cfg *Config) *Breaker {
+	b := NewBreaker(cfg)
+	b.logger = log.New(ioutil.Discard, "", 0)
+	return b
+}
+
+type reading struct {
+	btc int64
+	usd float64
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		readings []reading
+		want     Status
+		// The reason starts with this when tripped
+		wantReason string
+	}{
+		{
+			name:     "new highs",
+			cfg:      Config{MaxDrawdownBtc: 0.1, MaxDrawdownUsd: 0.1},
+			readings: []reading{{coin, 1000}, {2 * coin, 1000}, {3 * coin, 1000}},
+			want:     Status{HighBtc: 3 * coin, HighUsd: 3000},
+		},
+		{
+			name:     "below threshold",
+			cfg:      Config{MaxDrawdownBtc: 0.3},
+			readings: []reading{{4 * coin, 0}, {3 * coin, 0}},
+			want:     Status{HighBtc: 4 * coin, DrawdownBtc: 0.25},
+		},
+		{
+			name:       "btc trip",
+			cfg:        Config{MaxDrawdownBtc: 0.25},
+			readings:   []reading{{4 * coin, 0}, {3 * coin, 0}},
+			want:       Status{HighBtc: 4 * coin, DrawdownBtc: 0.25, Tripped: true},
+			wantReason: "BTC drawdown 25.00%",
+		},
+		// BTC holds steady while its dollar value falls
+		{
+			name:       "usd trip",
+			cfg:        Config{MaxDrawdownBtc: 0.25, MaxDrawdownUsd: 0.1},
+			readings:   []reading{{coin, 1000}, {coin, 850}},
+			want:       Status{HighBtc: coin, HighUsd: 1000, DrawdownUsd: 0.15, Tripped: true},
+			wantReason: "USD drawdown 15.00%",
+		},
+		{
+			name:     "usd disabled",
+			cfg:      Config{MaxDrawdownBtc: 0.25},
+			readings: []reading{{coin, 1000}, {coin, 500}},
+			want:     Status{HighBtc: coin, HighUsd: 1000, DrawdownUsd: 0.5},
+		},
+		// Without a rate the USD measure is left as it was
+		{
+			name:     "no usd rate",
+			cfg:      Config{MaxDrawdownUsd: 0.1},
+			readings: []reading{{coin, 1000}, {coin / 2, 0}},
+			want:     Status{HighBtc: coin, HighUsd: 1000, DrawdownBtc: 0.5},
+		},
+		// Recovering past the old high doesn't clear a trip
+		{
+			name:       "stays tripped",
+			cfg:        Config{MaxDrawdownBtc: 0.1},
+			readings:   []reading{{10 * coin, 0}, {8 * coin, 0}, {12 * coin, 0}},
+			want:       Status{HighBtc: 12 * coin, Tripped: true},
+			wantReason: "BTC drawdown 20.00%",
+		},
+	}
+
+	for _, tc := range tests {
+		b := testBreaker(&tc.cfg)
+		var got Status
+		for _, r := range tc.readings {
+			got = b.Update(r.btc, r.usd)
+		}
+
+		if got.HighBtc != tc.want.HighBtc || math.Abs(got.HighUsd-tc.want.HighUsd) > 1e-6 {
+			t.Errorf("%s: high water marks %d, $%.2f; want %d, $%.2f", tc.name, got.HighBtc, got.HighUsd,
+				tc.want.HighBtc, tc.want.HighUsd)
+		}
+		if math.Abs(got.DrawdownBtc-tc.want.DrawdownBtc) > 1e-9 || math.Abs(got.DrawdownUsd-tc.want.DrawdownUsd) > 1e-9 {
+			t.Errorf("%s: drawdowns %f, %f; want %f, %f", tc.name, got.DrawdownBtc, got.DrawdownUsd,
+				tc.want.DrawdownBtc, tc.want.DrawdownUsd)
+		}
+		if got.Tripped != tc.want.Tripped || !strings.HasPrefix(got.Reason, tc.wantReason) {
+			t.Errorf("%s: tripped %v (%s); want %v (%s)", tc.name, got.Tripped, got.Reason, tc.want.Tripped, tc.wantReason)
+		}
+		if b.Status() != got {
+			t.Errorf("%s: Status %+v differs from the last update %+v", tc.name, b.Status(), got)
+		}
+	}
+}
+
+func TestStatePathRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drawdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	cfg := &Config{MaxDrawdownBtc: 0.1, StatePath: filepath.Join(dir, "drawdown.json")}
+
+	b := testBreaker(cfg)
+	b.Update(10*coin, 1000)
+	tripped := b.Update(8*coin, 1000)
+	if !tripped.Tripped {
+		t.Fatal("expected a trip")
+	}
+
+	// A restart picks up where it left off
+	b = testBreaker(cfg)
+	if got := b.Status(); got != tripped {
+		t.Fatalf("restored %+v, want %+v", got, tripped)
+	}
+	if got := b.Update(11*coin, 1000); !got.Tripped || got.HighBtc != 11*coin {
+		t.Errorf("after restart: %+v", got)
+	}
+
+	// A reset survives the next restart too, and the next update sets new marks
+	b.Reset()
+	b = testBreaker(cfg)
+	if got := b.Status(); got != (Status{}) {
+		t.Fatalf("restored %+v after reset", got)
+	}
+	if got := b.Update(5*coin, 1000); got.Tripped || got.HighBtc != 5*coin || got.HighUsd != 5000 {
+		t.Errorf("first update after reset: %+v", got)
+	}
+
+	if _, err := os.Stat(cfg.StatePath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary state file left behind: %v", err)
+	}
+}
+
+func TestCorruptStateStartsFresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drawdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "drawdown.json")
+	if err := ioutil.WriteFile(path, []byte(`{"highBtc":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := testBreaker(&Config{StatePath: path})
+	if got := b.Status(); got.Tripped || got.HighBtc != 0 {
+		t.Errorf("loaded %+v from a corrupt file", got)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for s, want := range map[string]Action{"": ActionPause, "pause": ActionPause, "safe": ActionSafe} {
+		if got, err := ParseAction(s); err != nil || got != want {
+			t.Errorf("%q: got %s (%v), want %s", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"Pause", "halt"} {
+		if _, err := ParseAction(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
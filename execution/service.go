@@ -0,0 +1,341 @@
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/orders"
+	"github.com/tobyjsullivan/btc-frogger/spread"
+)
+
+const (
+	StrategyNone    = Strategy("none")
+	StrategyTwap    = Strategy("twap")
+	StrategyIceberg = Strategy("iceberg")
+)
+
+// Strategy determines how a parent order is split into child orders.
+//
+// StrategyNone sends whatever remains of the parent every tick. StrategyTwap spreads the parent
+// evenly over Config.Duration. StrategyIceberg only ever shows Config.SliceSize at a time.
+type Strategy string
+
+// ParseStrategy validates a strategy name. The empty string means StrategyNone.
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "":
+		return StrategyNone, nil
+	case StrategyNone, StrategyTwap, StrategyIceberg:
+		return Strategy(s), nil
+	default:
+		return "", errors.New(fmt.Sprintf("Unknown execution strategy: %s", s))
+	}
+}
+
+type Config struct {
+	Strategy Strategy
+	// TWAP: time over which each parent is worked.
+	Duration time.Duration
+	// Iceberg: visible size of each child. TWAP: minimum child size.
+	SliceSize int64
+}
+
+// OrderPlacer is where child orders are sent; satisfied by orders.OrderSvc.
+type OrderPlacer interface {
+	PlaceOrder(c coinbase.Currency, side coinbase.OrderSide, ntvAmount int64) *orders.Handle
+}
+
+// FillSource lists recent fills on a product; satisfied by coinbase.Conn.
+type FillSource interface {
+	ListFills(pid coinbase.ProductID) ([]*coinbase.Fill, error)
+}
+
+// QuoteSource provides the arrival price of new parents; satisfied by spread.SpreadSvc.
+type QuoteSource interface {
+	CurrentQuote(pid coinbase.ProductID) (*spread.Quote, bool)
+}
+
+// parent is a rebalance to be worked over several ticks.
+type parent struct {
+	currency     coinbase.Currency
+	productID    coinbase.ProductID
+	side         coinbase.OrderSide
+	total        int64
+	arrivalPrice int64
+	start        time.Time
+
+	// Every child placed so far. Fills are attributed to the parent by their order ID.
+	children []*orders.Handle
+	filled   int64
+	notional float64 // sum of price*size over fills, for the average price
+	fees     int64
+	trades   map[int64]bool
+}
+
+// Progress summarises a parent order's execution.
+type Progress struct {
+	Currency     coinbase.Currency
+	Side         coinbase.OrderSide
+	Total        int64
+	Filled       int64
+	ArrivalPrice int64
+	AvgFillPrice int64
+	// Positive slippage means the fills were worse than the arrival price.
+	SlippageBps float64
+	Fees        int64
+	Started     time.Time
+}
+
+type ExecutionSvc struct {
+	conn      FillSource
+	placer    OrderPlacer
+	spreadSvc QuoteSource
+	cfg       *Config
+	logger    *log.Logger
+
+	mx        sync.Mutex
+	parents   map[coinbase.Currency]*parent
+	completed map[coinbase.Currency]*Progress
+}
+
+func NewService(conn FillSource, placer OrderPlacer, spreadSvc QuoteSource, cfg *Config) *ExecutionSvc {
+	return &ExecutionSvc{
+		conn:      conn,
+		placer:    placer,
+		spreadSvc: spreadSvc,
+		cfg:       cfg,
+		logger:    log.New(os.Stdout, "[execution] ", 0),
+		parents:   make(map[coinbase.Currency]*parent),
+		completed: make(map[coinbase.Currency]*Progress),
+	}
+}
+
+// Execute is called once per tick with the currently outstanding rebalance for c. The first
+// call starts a parent order; later calls on the same side re-target it to what is still
+// outstanding while keeping its fill history. A change of side completes the old parent.
+func (svc *ExecutionSvc) Execute(c coinbase.Currency, side coinbase.OrderSide, ntvAmount int64) {
+	pid, ok := coinbase.BtcProduct(c)
+	if !ok {
+		svc.logger.Println("Unexpected currency:", c)
+		return
+	}
+
+	svc.mx.Lock()
+	p, ok := svc.parents[c]
+	svc.mx.Unlock()
+
+	if ok {
+		svc.updateFills(p)
+		if p.side != side {
+			svc.complete(p)
+			ok = false
+		}
+	}
+
+	if !ok {
+		p = svc.newParent(c, pid, side, ntvAmount)
+		if p == nil {
+			return
+		}
+	} else {
+		// The outstanding amount is recomputed from balances every tick
+		svc.mx.Lock()
+		p.total = p.filled + ntvAmount
+		svc.mx.Unlock()
+	}
+
+	child := svc.childSize(p)
+	svc.logger.Printf("%s %s parent: %s of %s filled; sending %s\n", p.side, p.currency,
+		fmtAmount(p.filled), fmtAmount(p.total), fmtAmount(child))
+	if child > 0 {
+		h := svc.placer.PlaceOrder(c, side, child)
+		svc.mx.Lock()
+		p.children = append(p.children, h)
+		svc.mx.Unlock()
+	}
+}
+
+// Idle is called on ticks where no rebalance is needed for c, completing any working parent.
+func (svc *ExecutionSvc) Idle(c coinbase.Currency) {
+	svc.mx.Lock()
+	p, ok := svc.parents[c]
+	svc.mx.Unlock()
+
+	if ok {
+		svc.updateFills(p)
+		svc.complete(p)
+	}
+}
+
+// Progress returns the state of every working parent order.
+func (svc *ExecutionSvc) Progress() []*Progress {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	out := make([]*Progress, 0, len(svc.parents))
+	for _, p := range svc.parents {
+		out = append(out, p.progress())
+	}
+
+	return out
+}
+
+// Completed returns the final state of the last parent order completed for each currency.
+func (svc *ExecutionSvc) Completed() []*Progress {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	out := make([]*Progress, 0, len(svc.completed))
+	for _, prog := range svc.completed {
+		out = append(out, prog)
+	}
+
+	return out
+}
+
+func (svc *ExecutionSvc) newParent(c coinbase.Currency, pid coinbase.ProductID, side coinbase.OrderSide, ntvAmount int64) *parent {
+	quote, ok := svc.spreadSvc.CurrentQuote(pid)
+	if !ok {
+		svc.logger.Println("Spread wasnt ready:", pid)
+		return nil
+	}
+
+	p := &parent{
+		currency:     c,
+		productID:    pid,
+		side:         side,
+		total:        ntvAmount,
+		arrivalPrice: (quote.Bid + quote.Ask) / 2,
+		start:        time.Now(),
+		trades:       make(map[int64]bool),
+	}
+
+	svc.mx.Lock()
+	svc.parents[c] = p
+	svc.mx.Unlock()
+
+	svc.logger.Printf("New %s %s parent for %s at arrival price %d\n", side, c, fmtAmount(ntvAmount), p.arrivalPrice)
+
+	return p
+}
+
+// childSize returns how much of the parent to send this tick.
+func (svc *ExecutionSvc) childSize(p *parent) int64 {
+	remaining := p.total - p.filled
+	if remaining <= 0 {
+		return 0
+	}
+
+	var child int64
+	switch svc.cfg.Strategy {
+	case StrategyIceberg:
+		child = svc.cfg.SliceSize
+	case StrategyTwap:
+		if svc.cfg.Duration <= 0 {
+			child = remaining
+			break
+		}
+		elapsed := float64(time.Since(p.start)) / float64(svc.cfg.Duration)
+		if elapsed > 1 {
+			elapsed = 1
+		}
+		// Send enough to be back on schedule as of now
+		target := int64(float64(p.total) * elapsed)
+		child = target - p.filled
+		if child < svc.cfg.SliceSize {
+			child = svc.cfg.SliceSize
+		}
+	default:
+		child = remaining
+	}
+
+	if child > remaining || child <= 0 {
+		child = remaining
+	}
+
+	return child
+}
+
+// updateFills attributes fills on the parent's product to it when they belong to one of its
+// child orders.
+func (svc *ExecutionSvc) updateFills(p *parent) {
+	if svc.cfg.Strategy == StrategyNone {
+		return
+	}
+
+	fills, err := svc.conn.ListFills(p.productID)
+	if err != nil {
+		svc.logger.Println("fills:", err)
+		return
+	}
+
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	ours := make(map[uuid.UUID]bool)
+	for _, h := range p.children {
+		for _, id := range h.OrderIDs() {
+			ours[id] = true
+		}
+	}
+
+	for _, f := range fills {
+		if !ours[f.OrderID] || p.trades[f.TradeID] {
+			continue
+		}
+
+		p.trades[f.TradeID] = true
+		p.filled += f.Size
+		p.notional += float64(f.Price) * float64(f.Size)
+		p.fees += f.Fee
+	}
+}
+
+func (svc *ExecutionSvc) complete(p *parent) {
+	svc.mx.Lock()
+	if cur, ok := svc.parents[p.currency]; ok && cur == p {
+		delete(svc.parents, p.currency)
+	}
+	prog := p.progress()
+	svc.completed[p.currency] = prog
+	svc.mx.Unlock()
+
+	svc.logger.Printf("Completed %s %s parent: filled %s of %s; avg price %d vs arrival %d (%.1f bps); fees %s\n",
+		prog.Side, prog.Currency, fmtAmount(prog.Filled), fmtAmount(prog.Total), prog.AvgFillPrice,
+		prog.ArrivalPrice, prog.SlippageBps, fmtAmount(prog.Fees))
+}
+
+func (p *parent) progress() *Progress {
+	prog := &Progress{
+		Currency:     p.currency,
+		Side:         p.side,
+		Total:        p.total,
+		Filled:       p.filled,
+		ArrivalPrice: p.arrivalPrice,
+		Fees:         p.fees,
+		Started:      p.start,
+	}
+
+	if p.filled > 0 {
+		prog.AvgFillPrice = int64(p.notional / float64(p.filled))
+		if p.arrivalPrice > 0 {
+			slippage := float64(prog.AvgFillPrice-p.arrivalPrice) / float64(p.arrivalPrice) * 10000
+			if p.side == coinbase.SideSell {
+				slippage = -slippage
+			}
+			prog.SlippageBps = slippage
+		}
+	}
+
+	return prog
+}
+
+func fmtAmount(amount int64) string {
+	return fmt.Sprintf("%.8f", float64(amount)/coinbase.AmountCoin)
+}
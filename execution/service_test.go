@@ -0,0 +1,176 @@
+package execution
+
+import (
+	"io/ioutil"
+	"log"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/orders"
+	"github.com/tobyjsullivan/btc-frogger/spread"
+)
+
+const coin = int64(coinbase.AmountCoin)
+
+// fakeExchange places child orders under fresh IDs and serves whatever fills the test adds.
+type fakeExchange struct {
+	placed []*orders.Intent
+	ids    []uuid.UUID
+	fills  []*coinbase.Fill
+}
+
+func (x *fakeExchange) PlaceOrder(c coinbase.Currency, side coinbase.OrderSide, ntvAmount int64) *orders.Handle {
+	intent := &orders.Intent{Currency: c, Side: side, NtvAmount: ntvAmount}
+	id := uuid.NewV4()
+	x.placed = append(x.placed, intent)
+	x.ids = append(x.ids, id)
+	return orders.PlacedHandle(intent, id)
+}
+
+func (x *fakeExchange) ListFills(pid coinbase.ProductID) ([]*coinbase.Fill, error) {
+	var out []*coinbase.Fill
+	for _, f := range x.fills {
+		if f.ProductID == pid {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+func (x *fakeExchange) fill(orderID uuid.UUID, tradeID int64, price, size int64) {
+	x.fills = append(x.fills, &coinbase.Fill{
+		TradeID:   tradeID,
+		OrderID:   orderID,
+		ProductID: coinbase.ProductEthBtc,
+		Price:     price,
+		Size:      size,
+		Fee:       size / 1000,
+	})
+}
+
+func (x *fakeExchange) CurrentQuote(pid coinbase.ProductID) (*spread.Quote, bool) {
+	return &spread.Quote{Bid: 99, Ask: 101, Time: time.Now()}, true
+}
+
+func testService(cfg *Config) (*ExecutionSvc, *fakeExchange) {
+	x := &fakeExchange{}
+	svc := NewService(x, x, x, cfg)
+	svc.logger = log.New(ioutil.Discard, "", 0)
+	return svc, x
+}
+
+func TestChildSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *Config
+		total   int64
+		filled  int64
+		started time.Duration
+		want    int64
+	}{
+		{name: "none", cfg: &Config{Strategy: StrategyNone}, total: coin, filled: coin / 4, want: 3 * coin / 4},
+		{name: "iceberg slice", cfg: &Config{Strategy: StrategyIceberg, SliceSize: coin / 3}, total: coin, want: coin / 3},
+		// The last child is whatever is left, not a full slice
+		{name: "iceberg last", cfg: &Config{Strategy: StrategyIceberg, SliceSize: coin / 3}, total: coin, filled: 3 * (coin / 3), want: 1},
+		{name: "iceberg done", cfg: &Config{Strategy: StrategyIceberg, SliceSize: coin / 3}, total: coin, filled: coin, want: 0},
+		{name: "twap no duration", cfg: &Config{Strategy: StrategyTwap}, total: coin, filled: coin / 2, want: coin / 2},
+		// Ahead of schedule still sends the minimum slice
+		{name: "twap ahead", cfg: &Config{Strategy: StrategyTwap, Duration: time.Hour, SliceSize: coin / 100}, total: coin, filled: coin / 2, started: time.Minute, want: coin / 100},
+		// Past the end the schedule asks for all of it, to the last unit
+		{name: "twap overdue", cfg: &Config{Strategy: StrategyTwap, Duration: time.Minute, SliceSize: coin / 100}, total: coin + 3, filled: coin / 3, started: time.Hour, want: coin + 3 - coin/3},
+		{name: "twap slice over remaining", cfg: &Config{Strategy: StrategyTwap, Duration: time.Hour, SliceSize: coin}, total: coin, filled: coin - 7, started: time.Minute, want: 7},
+	}
+
+	for _, tc := range tests {
+		svc, _ := testService(tc.cfg)
+		p := &parent{total: tc.total, filled: tc.filled, start: time.Now().Add(-tc.started)}
+		if got := svc.childSize(p); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestChildSizeTwapOnSchedule(t *testing.T) {
+	svc, _ := testService(&Config{Strategy: StrategyTwap, Duration: 10 * time.Minute, SliceSize: 1})
+	p := &parent{total: coin, start: time.Now().Add(-5 * time.Minute)}
+
+	got := svc.childSize(p)
+	if math.Abs(float64(got-coin/2)) > float64(coin)/100 {
+		t.Errorf("halfway through: got %d, want about %d", got, coin/2)
+	}
+}
+
+func TestExecuteAttributesFillsByChild(t *testing.T) {
+	svc, x := testService(&Config{Strategy: StrategyIceberg, SliceSize: coin / 2})
+
+	svc.Execute(coinbase.CurrencyEth, coinbase.SideBuy, coin)
+	if len(x.placed) != 1 || x.placed[0].NtvAmount != coin/2 {
+		t.Fatalf("first child: %+v", x.placed)
+	}
+
+	// A fill on our child, listed twice, and one on an order that isn't ours
+	x.fill(x.ids[0], 1, 102, 3*coin/10)
+	x.fill(x.ids[0], 1, 102, 3*coin/10)
+	x.fill(uuid.NewV4(), 2, 90, coin)
+
+	svc.Execute(coinbase.CurrencyEth, coinbase.SideBuy, 7*coin/10)
+	prog := svc.Progress()
+	if len(prog) != 1 || prog[0].Filled != 3*coin/10 || prog[0].Total != coin {
+		t.Fatalf("progress after the first fill: %+v", prog[0])
+	}
+	if len(x.placed) != 2 || x.placed[1].NtvAmount != coin/2 {
+		t.Fatalf("second child: %+v", x.placed[1])
+	}
+
+	// The last child is only what remains
+	x.fill(x.ids[1], 3, 104, coin/2)
+	svc.Execute(coinbase.CurrencyEth, coinbase.SideBuy, coin/5)
+	if len(x.placed) != 3 || x.placed[2].NtvAmount != coin/5 {
+		t.Fatalf("last child: %+v", x.placed[2])
+	}
+
+	svc.Idle(coinbase.CurrencyEth)
+	if prog := svc.Progress(); len(prog) != 0 {
+		t.Errorf("still working after idle: %+v", prog)
+	}
+	done := svc.Completed()
+	if len(done) != 1 {
+		t.Fatalf("completed: %+v", done)
+	}
+	got := done[0]
+	if got.Filled != 8*coin/10 || got.Fees != 8*coin/10/1000 {
+		t.Errorf("filled %d with fees %d", got.Filled, got.Fees)
+	}
+	// 0.3 @ 102 and 0.5 @ 104 against an arrival mid of 100
+	if got.ArrivalPrice != 100 || got.AvgFillPrice != 103 {
+		t.Errorf("arrival %d, average %d", got.ArrivalPrice, got.AvgFillPrice)
+	}
+	if math.Abs(got.SlippageBps-300) > 0.001 {
+		t.Errorf("slippage: got %.2f bps, want 300", got.SlippageBps)
+	}
+}
+
+func TestExecuteSideChangeCompletesParent(t *testing.T) {
+	svc, x := testService(&Config{Strategy: StrategyIceberg, SliceSize: coin})
+
+	svc.Execute(coinbase.CurrencyEth, coinbase.SideSell, coin)
+	x.fill(x.ids[0], 1, 98, coin/2)
+	svc.Execute(coinbase.CurrencyEth, coinbase.SideBuy, coin/4)
+
+	done := svc.Completed()
+	if len(done) != 1 || done[0].Side != coinbase.SideSell || done[0].Filled != coin/2 {
+		t.Fatalf("completed: %+v", done)
+	}
+	// Selling below arrival is slippage too
+	if done[0].SlippageBps <= 0 {
+		t.Errorf("sell slippage: got %.2f bps, want positive", done[0].SlippageBps)
+	}
+
+	prog := svc.Progress()
+	if len(prog) != 1 || prog[0].Side != coinbase.SideBuy || prog[0].Total != coin/4 || prog[0].Filled != 0 {
+		t.Errorf("new parent: %+v", prog[0])
+	}
+}
@@ -0,0 +1,410 @@
+package feed
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+const (
+	DefaultUrl = "wss://ws-feed.gdax.com"
+
+	StateDisconnected = State("disconnected")
+	StateConnecting   = State("connecting")
+	StateConnected    = State("connected")
+
+	ChannelHeartbeat = "heartbeat"
+	ChannelFull      = "full"
+	ChannelLevel2    = "level2"
+	ChannelTicker    = "ticker"
+	ChannelMatches   = "matches"
+	ChannelUser      = "user"
+
+	defaultMinBackoff       = 1 * time.Second
+	defaultMaxBackoff       = 60 * time.Second
+	defaultHeartbeatTimeout = 5 * time.Second
+	writeTimeout            = 10 * time.Second
+)
+
+type State string
+
+type Auth struct {
+	ApiAccessKey  string
+	ApiSecretKey  string
+	ApiPassphrase string
+}
+
+// Client maintains a websocket connection to the feed. Dropped connections are redialed with
+// exponential backoff and all channels are resubscribed. The heartbeat channel is always
+// subscribed for every product so that a connection which goes silent is detected and replaced.
+type Client struct {
+	url    string
+	auth   *Auth
+	logger *log.Logger
+
+	minBackoff       time.Duration
+	maxBackoff       time.Duration
+	heartbeatTimeout time.Duration
+
+	mx            sync.Mutex
+	writeMx       sync.Mutex
+	conn          *websocket.Conn
+	state         State
+	subscriptions map[string]map[coinbase.ProductID]bool
+	handler       func(msg []byte)
+	handlers      map[coinbase.ProductID]func(msg []byte)
+	onStates      []func(State)
+}
+
+func NewClient(url string, auth *Auth) *Client {
+	return &Client{
+		url:              url,
+		auth:             auth,
+		logger:           log.New(os.Stdout, "[feed] ", 0),
+		minBackoff:       defaultMinBackoff,
+		maxBackoff:       defaultMaxBackoff,
+		heartbeatTimeout: defaultHeartbeatTimeout,
+		state:            StateDisconnected,
+		subscriptions:    make(map[string]map[coinbase.ProductID]bool),
+		handlers:         make(map[coinbase.ProductID]func(msg []byte)),
+	}
+}
+
+// OnMessage sets the handler which receives every text message from the feed that is not
+// routed to a product handler. It is called from the connection's read loop, so messages are
+// delivered one at a time and in order.
+func (c *Client) OnMessage(handler func(msg []byte)) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.handler = handler
+}
+
+// HandleProduct routes all messages for the product, on any channel, to handler. Passing a
+// nil handler removes the route.
+func (c *Client) HandleProduct(pid coinbase.ProductID, handler func(msg []byte)) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if handler == nil {
+		delete(c.handlers, pid)
+		return
+	}
+	c.handlers[pid] = handler
+}
+
+// Subscribe adds the products to the channel. The subscription is sent immediately if the
+// client is connected and is restored on every reconnect.
+func (c *Client) Subscribe(channel string, productIDs ...coinbase.ProductID) error {
+	c.mx.Lock()
+	subs, ok := c.subscriptions[channel]
+	if !ok {
+		subs = make(map[coinbase.ProductID]bool)
+		c.subscriptions[channel] = subs
+	}
+	for _, pid := range productIDs {
+		subs[pid] = true
+	}
+	conn := c.conn
+	c.mx.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	return c.send(conn, "subscribe", map[string][]coinbase.ProductID{
+		channel:          productIDs,
+		ChannelHeartbeat: productIDs,
+	})
+}
+
+// Unsubscribe removes the products from the channel. Heartbeats for a product stop once it
+// has no other subscriptions.
+func (c *Client) Unsubscribe(channel string, productIDs ...coinbase.ProductID) error {
+	c.mx.Lock()
+	if subs, ok := c.subscriptions[channel]; ok {
+		for _, pid := range productIDs {
+			delete(subs, pid)
+		}
+		if len(subs) == 0 {
+			delete(c.subscriptions, channel)
+		}
+	}
+
+	var idle []coinbase.ProductID
+	active := c.activeProducts()
+	for _, pid := range productIDs {
+		if !active[pid] {
+			idle = append(idle, pid)
+		}
+	}
+	conn := c.conn
+	c.mx.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	channels := map[string][]coinbase.ProductID{
+		channel: productIDs,
+	}
+	if len(idle) > 0 {
+		channels[ChannelHeartbeat] = idle
+	}
+	return c.send(conn, "unsubscribe", channels)
+}
+
+// activeProducts returns every product with at least one subscription. Must be called with mx held.
+func (c *Client) activeProducts() map[coinbase.ProductID]bool {
+	active := make(map[coinbase.ProductID]bool)
+	for ch, subs := range c.subscriptions {
+		if ch == ChannelHeartbeat {
+			continue
+		}
+		for pid := range subs {
+			active[pid] = true
+		}
+	}
+
+	return active
+}
+
+// OnStateChange registers a callback for connection state changes. Callbacks are invoked in
+// the same goroutine as the message handler, so a StateConnected callback is always seen
+// before any message on the new connection.
+func (c *Client) OnStateChange(callback func(State)) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.onStates = append(c.onStates, callback)
+}
+
+func (c *Client) State() State {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return c.state
+}
+
+// Run connects to the feed and keeps reconnecting until ctx is done.
+func (c *Client) Run(ctx context.Context) {
+	backoff := c.minBackoff
+	for {
+		c.setState(StateConnecting)
+		connected, err := c.runConnection(ctx)
+		c.setState(StateDisconnected)
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if connected {
+			backoff = c.minBackoff
+		}
+		c.logger.Println("connection lost:", err)
+
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		c.logger.Println("Reconnecting in", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff = c.nextBackoff(backoff)
+	}
+}
+
+// nextBackoff doubles the delay before the next attempt, up to maxBackoff.
+func (c *Client) nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > c.maxBackoff {
+		backoff = c.maxBackoff
+	}
+
+	return backoff
+}
+
+// runConnection dials, subscribes and reads until the connection fails. The returned bool
+// indicates whether the connection was ever established.
+func (c *Client) runConnection(ctx context.Context) (bool, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(c.url, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// Unblock the read when the context is cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	// Register the connection before resubscribing so that concurrent Subscribe calls are either
+	// included in the resubscription or sent on this connection afterwards.
+	c.mx.Lock()
+	c.conn = conn
+	channels := make(map[string][]coinbase.ProductID)
+	active := c.activeProducts()
+	for ch, subs := range c.subscriptions {
+		for pid := range subs {
+			channels[ch] = append(channels[ch], pid)
+		}
+	}
+	for pid := range active {
+		channels[ChannelHeartbeat] = append(channels[ChannelHeartbeat], pid)
+	}
+	c.mx.Unlock()
+	defer func() {
+		c.mx.Lock()
+		c.conn = nil
+		c.mx.Unlock()
+	}()
+
+	if len(channels) > 0 {
+		if err := c.send(conn, "subscribe", channels); err != nil {
+			return false, err
+		}
+	}
+
+	c.logger.Println("Connected:", c.url)
+	c.setState(StateConnected)
+
+	for {
+		// Heartbeats arrive every second for each product so a quiet connection has stalled
+		c.mx.Lock()
+		watch := len(c.activeProducts()) > 0
+		c.mx.Unlock()
+		if watch {
+			conn.SetReadDeadline(time.Now().Add(c.heartbeatTimeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		t, message, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+
+		if t != websocket.TextMessage {
+			return true, errors.New("unexpected message type: " + strconv.Itoa(t))
+		}
+
+		c.dispatch(message)
+	}
+}
+
+// dispatch routes a message to its product handler, falling back to the general handler.
+func (c *Client) dispatch(message []byte) {
+	var header struct {
+		ProductID coinbase.ProductID `json:"product_id"`
+	}
+	if err := json.Unmarshal(message, &header); err != nil {
+		c.logger.Println("unmarshal:", err)
+		return
+	}
+
+	c.mx.Lock()
+	handler, ok := c.handlers[header.ProductID]
+	if !ok || header.ProductID == "" {
+		handler = c.handler
+	}
+	c.mx.Unlock()
+
+	if handler != nil {
+		handler(message)
+	}
+}
+
+func (c *Client) send(conn *websocket.Conn, msgType string, channels map[string][]coinbase.ProductID) error {
+	msg, err := c.signedMessage(msgType, channels)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Printf("%s: %s\n", msgType, msg)
+
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+type channelSpec struct {
+	Name       string               `json:"name"`
+	ProductIDs []coinbase.ProductID `json:"product_ids"`
+}
+
+func (c *Client) signedMessage(msgType string, channels map[string][]coinbase.ProductID) ([]byte, error) {
+	specs := make([]*channelSpec, 0, len(channels))
+	for name, pids := range channels {
+		specs = append(specs, &channelSpec{
+			Name:       name,
+			ProductIDs: pids,
+		})
+	}
+
+	subscribeMsg := struct {
+		Type       string         `json:"type"`
+		Channels   []*channelSpec `json:"channels"`
+		Signature  string         `json:"signature,omitempty"`
+		Key        string         `json:"key,omitempty"`
+		Passphrase string         `json:"passphrase,omitempty"`
+		Timestamp  string         `json:"timestamp,omitempty"`
+	}{
+		Type:     msgType,
+		Channels: specs,
+	}
+
+	if c.auth != nil && c.auth.ApiAccessKey != "" {
+		secret, err := base64.StdEncoding.DecodeString(c.auth.ApiSecretKey)
+		if err != nil {
+			return nil, err
+		}
+
+		timestamp := strconv.FormatInt(time.Now().UTC().Unix(), 10)
+		signature := coinbase.ComputeRequestSignature(timestamp, http.MethodGet, "/users/self", "", secret)
+
+		subscribeMsg.Signature = base64.StdEncoding.EncodeToString(signature)
+		subscribeMsg.Key = c.auth.ApiAccessKey
+		subscribeMsg.Passphrase = c.auth.ApiPassphrase
+		subscribeMsg.Timestamp = timestamp
+	}
+
+	return json.Marshal(&subscribeMsg)
+}
+
+func (c *Client) setState(s State) {
+	c.mx.Lock()
+	if c.state == s {
+		c.mx.Unlock()
+		return
+	}
+	c.state = s
+	callbacks := append([]func(State){}, c.onStates...)
+	c.mx.Unlock()
+
+	c.logger.Println("State:", s)
+	for _, cb := range callbacks {
+		cb(s)
+	}
+}
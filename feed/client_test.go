@@ -0,0 +1,325 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+const testWait = 2 * time.Second
+
+// testServer accepts websocket connections and hands them to the test. While refuse is set,
+// connection attempts are rejected before the upgrade.
+type testServer struct {
+	*httptest.Server
+	conns chan *websocket.Conn
+
+	mx       sync.Mutex
+	refuse   bool
+	attempts []time.Time
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{conns: make(chan *websocket.Conn, 10)}
+	upgrader := websocket.Upgrader{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mx.Lock()
+		ts.attempts = append(ts.attempts, time.Now())
+		refuse := ts.refuse
+		ts.mx.Unlock()
+		if refuse {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ts.conns <- conn
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func (ts *testServer) url() string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func (ts *testServer) accept(t *testing.T) *websocket.Conn {
+	select {
+	case conn := <-ts.conns:
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	case <-time.After(testWait):
+		t.Fatal("client didn't connect")
+		return nil
+	}
+}
+
+// testClient runs a client against the server until the test ends. States it passes through
+// are sent on the returned channel.
+func testClient(t *testing.T, ts *testServer, setup func(*Client)) (*Client, chan State) {
+	c := NewClient(ts.url(), nil)
+	c.logger = log.New(ioutil.Discard, "", 0)
+	c.minBackoff = 10 * time.Millisecond
+	c.maxBackoff = 40 * time.Millisecond
+
+	states := make(chan State, 100)
+	c.OnStateChange(func(s State) { states <- s })
+	if setup != nil {
+		setup(c)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return c, states
+}
+
+func awaitState(t *testing.T, states chan State, want State) {
+	timeout := time.After(testWait)
+	for {
+		select {
+		case s := <-states:
+			if s == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("never reached state %s", want)
+		}
+	}
+}
+
+// readRequest returns the type of a subscribe or unsubscribe message and its sorted product
+// IDs by channel.
+func readRequest(t *testing.T, conn *websocket.Conn) (string, map[string][]string) {
+	conn.SetReadDeadline(time.Now().Add(testWait))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req struct {
+		Type     string         `json:"type"`
+		Channels []*channelSpec `json:"channels"`
+	}
+	if err := json.Unmarshal(msg, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	channels := make(map[string][]string)
+	for _, spec := range req.Channels {
+		for _, pid := range spec.ProductIDs {
+			channels[spec.Name] = append(channels[spec.Name], string(pid))
+		}
+		sort.Strings(channels[spec.Name])
+	}
+
+	return req.Type, channels
+}
+
+func expectRequest(t *testing.T, conn *websocket.Conn, wantType string, want map[string][]string) {
+	gotType, got := readRequest(t, conn)
+	if gotType != wantType {
+		t.Errorf("got a %s request, want %s", gotType, wantType)
+	}
+	if len(got) != len(want) {
+		t.Errorf("channels: got %v, want %v", got, want)
+	}
+	for name, pids := range want {
+		if strings.Join(got[name], ",") != strings.Join(pids, ",") {
+			t.Errorf("%s: got %v, want %v", name, got[name], pids)
+		}
+	}
+}
+
+func TestClientResubscribesAfterReconnect(t *testing.T) {
+	ts := newTestServer(t)
+	_, states := testClient(t, ts, func(c *Client) {
+		c.Subscribe(ChannelTicker, coinbase.ProductEthBtc, coinbase.ProductLtcBtc)
+	})
+	want := map[string][]string{
+		ChannelTicker:    {"ETH-BTC", "LTC-BTC"},
+		ChannelHeartbeat: {"ETH-BTC", "LTC-BTC"},
+	}
+
+	conn := ts.accept(t)
+	expectRequest(t, conn, "subscribe", want)
+	awaitState(t, states, StateConnected)
+
+	conn.Close()
+	awaitState(t, states, StateDisconnected)
+
+	conn = ts.accept(t)
+	expectRequest(t, conn, "subscribe", want)
+	awaitState(t, states, StateConnected)
+}
+
+func TestClientReplacesStalledConnection(t *testing.T) {
+	ts := newTestServer(t)
+	_, states := testClient(t, ts, func(c *Client) {
+		c.heartbeatTimeout = 100 * time.Millisecond
+		c.Subscribe(ChannelTicker, coinbase.ProductEthBtc)
+	})
+
+	conn := ts.accept(t)
+	readRequest(t, conn)
+	awaitState(t, states, StateConnected)
+
+	// Heartbeats keep the connection alive past the timeout
+	for i := 0; i < 5; i++ {
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"heartbeat","product_id":"ETH-BTC"}`))
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case s := <-states:
+		t.Fatalf("changed to %s while heartbeats were arriving", s)
+	default:
+	}
+
+	// Then the feed goes quiet without closing
+	awaitState(t, states, StateDisconnected)
+	conn = ts.accept(t)
+	readRequest(t, conn)
+	awaitState(t, states, StateConnected)
+}
+
+func TestClientBacksOff(t *testing.T) {
+	ts := newTestServer(t)
+	ts.refuse = true
+	testClient(t, ts, nil)
+
+	deadline := time.Now().Add(testWait)
+	for {
+		ts.mx.Lock()
+		n := len(ts.attempts)
+		ts.mx.Unlock()
+		if n >= 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d connection attempts", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	// Delays double from 10ms up to the 40ms cap, plus up to half again in jitter
+	for i, min := range []time.Duration{10, 20, 40, 40} {
+		min *= time.Millisecond
+		if gap := ts.attempts[i+1].Sub(ts.attempts[i]); gap < min {
+			t.Errorf("attempt %d came %s after the last, want at least %s", i+2, gap, min)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	c := NewClient("", nil)
+	backoff := c.minBackoff
+	for _, want := range []time.Duration{2, 4, 8, 16, 32, 60, 60} {
+		backoff = c.nextBackoff(backoff)
+		if backoff != want*time.Second {
+			t.Errorf("got %s, want %s", backoff, want*time.Second)
+		}
+	}
+}
+
+func TestClientRuntimeSubscriptions(t *testing.T) {
+	ts := newTestServer(t)
+	c, states := testClient(t, ts, func(c *Client) {
+		c.Subscribe(ChannelTicker, coinbase.ProductEthBtc)
+	})
+	conn := ts.accept(t)
+	readRequest(t, conn)
+	awaitState(t, states, StateConnected)
+
+	c.Subscribe(ChannelLevel2, coinbase.ProductEthBtc, coinbase.ProductLtcBtc)
+	expectRequest(t, conn, "subscribe", map[string][]string{
+		ChannelLevel2:    {"ETH-BTC", "LTC-BTC"},
+		ChannelHeartbeat: {"ETH-BTC", "LTC-BTC"},
+	})
+
+	// ETH-BTC is still on the ticker so keeps its heartbeat
+	c.Unsubscribe(ChannelLevel2, coinbase.ProductEthBtc, coinbase.ProductLtcBtc)
+	expectRequest(t, conn, "unsubscribe", map[string][]string{
+		ChannelLevel2:    {"ETH-BTC", "LTC-BTC"},
+		ChannelHeartbeat: {"LTC-BTC"},
+	})
+
+	c.Unsubscribe(ChannelTicker, coinbase.ProductEthBtc)
+	expectRequest(t, conn, "unsubscribe", map[string][]string{
+		ChannelTicker:    {"ETH-BTC"},
+		ChannelHeartbeat: {"ETH-BTC"},
+	})
+
+	// Only what is still subscribed comes back after a reconnect
+	c.Subscribe(ChannelMatches, coinbase.ProductBtcUsd)
+	readRequest(t, conn)
+	conn.Close()
+	conn = ts.accept(t)
+	expectRequest(t, conn, "subscribe", map[string][]string{
+		ChannelMatches:   {"BTC-USD"},
+		ChannelHeartbeat: {"BTC-USD"},
+	})
+}
+
+func TestClientRoutesByProduct(t *testing.T) {
+	ts := newTestServer(t)
+	routed := make(chan string, 10)
+	general := make(chan string, 10)
+	c, states := testClient(t, ts, func(c *Client) {
+		c.OnMessage(func(msg []byte) { general <- string(msg) })
+		c.HandleProduct(coinbase.ProductEthBtc, func(msg []byte) { routed <- string(msg) })
+	})
+	conn := ts.accept(t)
+	awaitState(t, states, StateConnected)
+
+	eth := `{"type":"ticker","product_id":"ETH-BTC"}`
+	ltc := `{"type":"ticker","product_id":"LTC-BTC"}`
+	subs := `{"type":"subscriptions"}`
+	for _, msg := range []string{eth, ltc, subs} {
+		conn.WriteMessage(websocket.TextMessage, []byte(msg))
+	}
+	expectMsg(t, routed, eth)
+	expectMsg(t, general, ltc)
+	expectMsg(t, general, subs)
+
+	// Without its route a product falls back to the general handler
+	c.HandleProduct(coinbase.ProductEthBtc, nil)
+	conn.WriteMessage(websocket.TextMessage, []byte(eth))
+	expectMsg(t, general, eth)
+}
+
+func expectMsg(t *testing.T, msgs chan string, want string) {
+	select {
+	case got := <-msgs:
+		if got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	case <-time.After(testWait):
+		t.Errorf("never received %s", want)
+	}
+}
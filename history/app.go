@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/journal"
+)
+
+// Lists the order history recorded in the journal.
+//
+//	history -journal journal.jsonl -types submit,response -product ETH-BTC -since 24h
+func main() {
+	log.SetPrefix("[history] ")
+	log.SetFlags(0)
+
+	path := flag.String("journal", os.Getenv("JOURNAL_PATH"), "path to the order journal")
+	types := flag.String("types", "", "comma separated entry types (intent, submit, response, fill, cancel, taker_fee)")
+	product := flag.String("product", "", "only entries for this product, eg ETH-BTC")
+	orderId := flag.String("order", "", "only entries for this exchange order ID")
+	clientOid := flag.String("client-oid", "", "only entries for this client order ID")
+	since := flag.Duration("since", 0, "only entries newer than this, eg 24h")
+	flag.Parse()
+
+	if *path == "" {
+		log.Fatalln("No journal specified. Use -journal or JOURNAL_PATH.")
+	}
+
+	filter := &journal.Filter{
+		ProductID: coinbase.ProductID(*product),
+		OrderID:   *orderId,
+		ClientOID: *clientOid,
+	}
+	if *types != "" {
+		for _, t := range strings.Split(*types, ",") {
+			filter.Types = append(filter.Types, journal.EntryType(strings.TrimSpace(t)))
+		}
+	}
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+
+	entries, err := journal.Read(*path, filter)
+	if err != nil {
+		log.Fatalln("read journal:", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SEQ\tTIME\tTYPE\tPRODUCT\tSIDE\tPRICE\tSIZE\tFEE\tORDER\tCLIENT OID\tSTATUS\tMESSAGE")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Seq, e.Time.Format(time.RFC3339), e.Type, productOrCurrency(e), e.Side,
+			fmtOptional(e.Price), fmtOptional(e.Size), fmtOptional(e.Fee),
+			e.OrderID, e.ClientOID, e.Status, e.Message)
+	}
+	w.Flush()
+}
+
+func productOrCurrency(e *journal.Entry) string {
+	if e.ProductID != "" {
+		return string(e.ProductID)
+	}
+	return string(e.Currency)
+}
+
+func fmtOptional(amount int64) string {
+	if amount == 0 {
+		return ""
+	}
+	return coinbase.FormatAmount(amount)
+}
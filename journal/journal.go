@@ -0,0 +1,251 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+const (
+	// An order the bot decided it wants, before pricing
+	TypeIntent = EntryType("intent")
+	// An order request about to be sent to the exchange
+	TypeSubmit = EntryType("submit")
+	// The exchange's answer to a submitted request
+	TypeResponse = EntryType("response")
+	TypeFill     = EntryType("fill")
+	TypeCancel   = EntryType("cancel")
+	// Taker fees actually charged on an escalated IOC order, counted against the daily budget
+	TypeTakerFee = EntryType("taker_fee")
+)
+
+type EntryType string
+
+// Entry is a single journal record. Amounts are native and fields not relevant to the entry
+// type are left empty.
+type Entry struct {
+	Seq         uint64               `json:"seq"`
+	Time        time.Time            `json:"time"`
+	Type        EntryType            `json:"type"`
+	ProductID   coinbase.ProductID   `json:"productId,omitempty"`
+	Currency    coinbase.Currency    `json:"currency,omitempty"`
+	Side        coinbase.OrderSide   `json:"side,omitempty"`
+	OrderType   coinbase.OrderType   `json:"orderType,omitempty"`
+	TimeInForce coinbase.TimeInForce `json:"timeInForce,omitempty"`
+	OrderID     string               `json:"orderId,omitempty"`
+	ClientOID   string               `json:"clientOid,omitempty"`
+	TradeID     int64                `json:"tradeId,omitempty"`
+	Price       int64                `json:"price,omitempty"`
+	Size        int64                `json:"size,omitempty"`
+	Fee         int64                `json:"fee,omitempty"`
+	Status      string               `json:"status,omitempty"`
+	Message     string               `json:"message,omitempty"`
+}
+
+// Journal is an append-only log of everything the bot does with orders, stored as one JSON
+// object per line. Every append is fsynced before returning.
+type Journal struct {
+	path string
+
+	mx      sync.Mutex
+	f       *os.File
+	lastSeq uint64
+	trades  map[tradeKey]bool
+}
+
+// Trade IDs are only unique within a product.
+type tradeKey struct {
+	productID coinbase.ProductID
+	tradeID   int64
+}
+
+func Open(path string) (*Journal, error) {
+	j := &Journal{
+		path:   path,
+		trades: make(map[tradeKey]bool),
+	}
+
+	// Recover the sequence and the fills already recorded
+	torn, err := j.scan(func(e *Entry) {
+		j.lastSeq = e.Seq
+		if e.Type == TypeFill {
+			j.trades[tradeKey{e.ProductID, e.TradeID}] = true
+		}
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.f = f
+
+	// Drop a line torn by a crash so it doesn't end up in the middle of the file
+	if torn >= 0 {
+		if err := f.Truncate(torn); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	// Terminate a complete final entry missing its newline so the next entry starts cleanly
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := f.Write([]byte{'\n'}); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return j, nil
+}
+
+func (j *Journal) Close() error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	return j.f.Close()
+}
+
+// Append assigns the entry a sequence number and time, if unset, and durably writes it.
+func (j *Journal) Append(e *Entry) error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	j.lastSeq++
+	e.Seq = j.lastSeq
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if _, err := j.f.Write(line); err != nil {
+		return err
+	}
+	if e.Type == TypeFill {
+		j.trades[tradeKey{e.ProductID, e.TradeID}] = true
+	}
+
+	return j.f.Sync()
+}
+
+// HasFill reports whether a fill for the product's trade has already been recorded.
+func (j *Journal) HasFill(productID coinbase.ProductID, tradeID int64) bool {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	return j.trades[tradeKey{productID, tradeID}]
+}
+
+// Filter selects journal entries. Zero-valued fields match everything.
+type Filter struct {
+	Types     []EntryType
+	ProductID coinbase.ProductID
+	OrderID   string
+	ClientOID string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f *Filter) matches(e *Entry) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if e.Type == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.ProductID != "" && e.ProductID != f.ProductID {
+		return false
+	}
+	if f.OrderID != "" && e.OrderID != f.OrderID {
+		return false
+	}
+	if f.ClientOID != "" && e.ClientOID != f.ClientOID {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+// Query returns every entry matching the filter, oldest first.
+func (j *Journal) Query(filter *Filter) ([]*Entry, error) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	out := []*Entry{}
+	_, err := j.scan(func(e *Entry) {
+		if filter.matches(e) {
+			out = append(out, e)
+		}
+	})
+
+	return out, err
+}
+
+// Read opens the journal at path read-only and returns the matching entries.
+func Read(path string, filter *Filter) ([]*Entry, error) {
+	j := &Journal{path: path}
+	return j.Query(filter)
+}
+
+// scan calls fn for each entry, oldest first. A malformed final line, as left by a crash
+// mid-write, is skipped and its offset returned so it can be dropped; -1 means there was none.
+// A malformed line anywhere else means the journal is corrupt and is an error.
+func (j *Journal) scan(fn func(e *Entry)) (int64, error) {
+	f, err := os.Open(j.path)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var offset int64
+	torn := int64(-1)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if torn >= 0 {
+			return -1, errors.New(fmt.Sprintf("journal %s: malformed entry on line %d", j.path, line-1))
+		}
+
+		b := scanner.Bytes()
+		var e Entry
+		if err := json.Unmarshal(b, &e); err != nil {
+			torn = offset
+		} else {
+			fn(&e)
+		}
+		offset += int64(len(b)) + 1
+	}
+
+	return torn, scanner.Err()
+}
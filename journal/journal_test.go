@@ -0,0 +1,82 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+func tempJournal(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "journal.jsonl")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenDropsTornFinalLine(t *testing.T) {
+	path := tempJournal(t, `{"seq":1,"type":"intent"}`+"\n"+`{"seq":2,"ty`)
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Append(&Entry{Type: TypeCancel}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	entries, err := Read(path, &Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Seq != 2 || entries[1].Type != TypeCancel {
+		t.Fatalf("unexpected entries after reopen: %+v", entries)
+	}
+}
+
+func TestScanRejectsCorruptMiddleLine(t *testing.T) {
+	path := tempJournal(t, `{"seq":1,"type":"intent"}`+"\n"+"garbage\n"+`{"seq":3,"type":"cancel"}`+"\n")
+
+	if _, err := Open(path); err == nil {
+		t.Fatal("expected an error opening a journal with a corrupt entry")
+	}
+	if _, err := Read(path, &Filter{}); err == nil {
+		t.Fatal("expected an error reading a journal with a corrupt entry")
+	}
+}
+
+func TestHasFillKeyedByProduct(t *testing.T) {
+	path := tempJournal(t, "")
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Append(&Entry{Type: TypeFill, ProductID: coinbase.ProductEthBtc, TradeID: 7}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	if !j.HasFill(coinbase.ProductEthBtc, 7) {
+		t.Error("ETH-BTC trade 7 should be recorded")
+	}
+	if j.HasFill(coinbase.ProductLtcBtc, 7) {
+		t.Error("LTC-BTC trade 7 should not be recorded")
+	}
+}
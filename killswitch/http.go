@@ -0,0 +1,46 @@
+package killswitch
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Handler serves the switch over HTTP. It is meant to be bound to a local address only.
+//
+//	GET  /status             "running" or "halted: <reason>"
+//	POST /halt?reason=...    engage the switch
+//	POST /resume             clear the switch
+func (sw *Switch) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if halted, reason := sw.Halted(); halted {
+			fmt.Fprintln(w, "halted:", reason)
+			return
+		}
+		fmt.Fprintln(w, "running")
+	})
+
+	mux.HandleFunc("/halt", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := sw.Halt(r.URL.Query().Get("reason")); err != nil {
+			fmt.Fprintln(w, "halted, but not persisted:", err)
+			return
+		}
+		fmt.Fprintln(w, "halted")
+	})
+
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sw.Clear()
+		fmt.Fprintln(w, "running")
+	})
+
+	return mux
+}
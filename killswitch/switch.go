@@ -0,0 +1,249 @@
+package killswitch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const pollInterval = 1 * time.Second
+
+var (
+	// ErrHalted is returned for any order attempted while the switch is engaged.
+	ErrHalted = errors.New("trading halted by kill switch")
+	// ErrNotPersisted is returned by Halt when there is no halt file to record the halt in.
+	ErrNotPersisted = errors.New("no halt file configured")
+)
+
+type Config struct {
+	// The halt file. Trading is halted whenever it exists and its contents are the reason.
+	// Creating it by hand halts trading; deleting it clears the halt. Empty keeps the state
+	// in memory only, so it does not survive a restart and Halt reports it as not persisted.
+	Path string
+	// Halt after this many consecutive order or API errors. Zero disables.
+	MaxConsecutiveErrors int
+}
+
+// Switch is the global trading halt. Once engaged it stays engaged, across restarts when
+// a Path is configured, until explicitly cleared.
+type Switch struct {
+	cfg    *Config
+	logger *log.Logger
+
+	mx        sync.Mutex
+	halted    bool
+	reason    string
+	persisted bool // the halt file records the current halt
+	errCount  int
+	onHalt    []func(reason string)
+}
+
+func New(cfg *Config) *Switch {
+	sw := &Switch{
+		cfg:    cfg,
+		logger: log.New(os.Stdout, "[killswitch] ", 0),
+	}
+
+	sw.sync()
+	if halted, reason := sw.Halted(); halted {
+		sw.logger.Println("Starting halted:", reason)
+	}
+
+	return sw
+}
+
+// Run watches the halt file and signals until ctx is done. SIGUSR1 halts and SIGUSR2 clears.
+func (sw *Switch) Run(ctx context.Context) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigs:
+			if sig == syscall.SIGUSR2 {
+				sw.Clear()
+			} else {
+				sw.Halt("signal " + sig.String())
+			}
+		case <-ticker.C:
+			sw.sync()
+		}
+	}
+}
+
+// OnHalt registers fn to be called whenever the switch is engaged.
+func (sw *Switch) OnHalt(fn func(reason string)) {
+	sw.mx.Lock()
+	defer sw.mx.Unlock()
+
+	sw.onHalt = append(sw.onHalt, fn)
+}
+
+// Halted reports whether trading is halted and why.
+func (sw *Switch) Halted() (bool, string) {
+	sw.mx.Lock()
+	defer sw.mx.Unlock()
+
+	return sw.halted, sw.reason
+}
+
+// Check returns ErrHalted while the switch is engaged.
+func (sw *Switch) Check() error {
+	if halted, _ := sw.Halted(); halted {
+		return ErrHalted
+	}
+
+	return nil
+}
+
+// Halt engages the switch. It is a no-op if already halted. If the halt file can't be written
+// the switch is engaged in memory regardless and the error returned; writing the file is
+// retried on each poll so the halt survives a restart once it succeeds.
+func (sw *Switch) Halt(reason string) error {
+	return sw.halt(reason, false)
+}
+
+func (sw *Switch) halt(reason string, fromFile bool) error {
+	if reason == "" {
+		reason = "manual"
+	}
+
+	sw.mx.Lock()
+	if sw.halted {
+		var err error
+		if fromFile {
+			sw.persisted = true
+		} else if !sw.persisted {
+			err = sw.persist()
+		}
+		sw.mx.Unlock()
+		return err
+	}
+	// Persist before flipping state so the file watcher never sees us halted without a file
+	sw.reason = reason
+	var err error
+	if fromFile {
+		sw.persisted = true
+	} else if err = sw.persist(); err != nil {
+		sw.logger.Println("write halt file:", err)
+	}
+	sw.halted = true
+	callbacks := append([]func(string){}, sw.onHalt...)
+	sw.mx.Unlock()
+
+	sw.logger.Println("HALTED:", reason)
+
+	for _, fn := range callbacks {
+		fn(reason)
+	}
+
+	return err
+}
+
+// persist writes the halt file for the current reason. Must be called with mx held.
+func (sw *Switch) persist() error {
+	if sw.cfg.Path == "" {
+		return ErrNotPersisted
+	}
+
+	if err := ioutil.WriteFile(sw.cfg.Path, []byte(sw.reason+"\n"), 0644); err != nil {
+		return err
+	}
+	sw.persisted = true
+
+	return nil
+}
+
+// Clear releases the switch and resets the error count.
+func (sw *Switch) Clear() {
+	if sw.cfg.Path != "" {
+		if err := os.Remove(sw.cfg.Path); err != nil && !os.IsNotExist(err) {
+			sw.logger.Println("remove halt file:", err)
+			return
+		}
+	}
+
+	sw.mx.Lock()
+	defer sw.mx.Unlock()
+
+	if sw.halted {
+		sw.logger.Println("Cleared. Trading resumed.")
+	}
+	sw.halted = false
+	sw.reason = ""
+	sw.persisted = false
+	sw.errCount = 0
+}
+
+// RecordError counts a failed order or API call, halting once MaxConsecutiveErrors is reached.
+func (sw *Switch) RecordError(err error) {
+	if sw.cfg.MaxConsecutiveErrors <= 0 {
+		return
+	}
+
+	sw.mx.Lock()
+	sw.errCount++
+	count := sw.errCount
+	sw.mx.Unlock()
+
+	if count >= sw.cfg.MaxConsecutiveErrors {
+		sw.Halt("consecutive errors: " + err.Error())
+	}
+}
+
+// RecordSuccess resets the consecutive error count.
+func (sw *Switch) RecordSuccess() {
+	sw.mx.Lock()
+	defer sw.mx.Unlock()
+
+	sw.errCount = 0
+}
+
+// sync picks up the halt file being created or removed out of band. A halt whose file was
+// never written is not cleared by its absence; the write is retried instead.
+func (sw *Switch) sync() {
+	if sw.cfg.Path == "" {
+		return
+	}
+
+	content, err := ioutil.ReadFile(sw.cfg.Path)
+	if os.IsNotExist(err) {
+		sw.mx.Lock()
+		defer sw.mx.Unlock()
+		if sw.halted && !sw.persisted {
+			sw.persist()
+			return
+		}
+		if sw.halted {
+			sw.logger.Println("Halt file removed. Trading resumed.")
+		}
+		sw.halted = false
+		sw.reason = ""
+		sw.persisted = false
+		sw.errCount = 0
+		return
+	}
+	if err != nil {
+		sw.logger.Println("read halt file:", err)
+		return
+	}
+
+	reason := strings.TrimSpace(string(content))
+	if reason == "" {
+		reason = "halt file present"
+	}
+	sw.halt(reason, true)
+}
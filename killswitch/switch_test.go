@@ -0,0 +1,122 @@
+package killswitch
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "killswitch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestHaltHoldsWhenFileUnwritable(t *testing.T) {
+	// The parent directory doesn't exist, so the halt file can't be written
+	path := filepath.Join(tempDir(t), "missing", "halt")
+	sw := New(&Config{Path: path})
+
+	if err := sw.Halt("test"); err == nil {
+		t.Fatal("expected an error writing the halt file")
+	}
+	if halted, _ := sw.Halted(); !halted {
+		t.Fatal("switch should be halted in memory")
+	}
+
+	sw.sync()
+	if err := sw.Check(); err != ErrHalted {
+		t.Fatalf("halt lost after sync: %v", err)
+	}
+
+	// Once the file can be written the halt is persisted and behaves as usual
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sw.sync()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("halt file not written on retry: %v", err)
+	}
+	if halted, reason := sw.Halted(); !halted || reason != "test" {
+		t.Fatalf("got halted %v (%s) after retry", halted, reason)
+	}
+
+	os.Remove(path)
+	sw.sync()
+	if halted, _ := sw.Halted(); halted {
+		t.Fatal("removing a persisted halt file should clear the halt")
+	}
+}
+
+func TestHaltFileCreatedOutOfBand(t *testing.T) {
+	path := filepath.Join(tempDir(t), "halt")
+	sw := New(&Config{Path: path})
+
+	if err := ioutil.WriteFile(path, []byte("maintenance\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sw.sync()
+	if halted, reason := sw.Halted(); !halted || reason != "maintenance" {
+		t.Fatalf("got halted %v (%s)", halted, reason)
+	}
+
+	sw.Clear()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("halt file not removed by Clear: %v", err)
+	}
+	if err := sw.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsecutiveErrors(t *testing.T) {
+	sw := New(&Config{Path: filepath.Join(tempDir(t), "halt"), MaxConsecutiveErrors: 3})
+	fail := errors.New("timeout")
+
+	// A success between errors starts the count again
+	for _, step := range []string{"error", "error", "success", "error", "error", "success", "error"} {
+		if step == "error" {
+			sw.RecordError(fail)
+		} else {
+			sw.RecordSuccess()
+		}
+		if halted, reason := sw.Halted(); halted {
+			t.Fatalf("halted after non-consecutive errors: %s", reason)
+		}
+	}
+
+	sw.RecordError(fail)
+	sw.RecordError(fail)
+	if halted, reason := sw.Halted(); !halted || reason != "consecutive errors: timeout" {
+		t.Fatalf("got halted %v (%s) after three consecutive errors", halted, reason)
+	}
+
+	// Clearing starts the count over
+	sw.Clear()
+	sw.RecordError(fail)
+	sw.RecordError(fail)
+	if halted, _ := sw.Halted(); halted {
+		t.Fatal("errors from before the clear still counted")
+	}
+}
+
+func TestHaltWithoutPath(t *testing.T) {
+	sw := New(&Config{})
+
+	if err := sw.Halt("test"); err != ErrNotPersisted {
+		t.Fatalf("got %v, want ErrNotPersisted", err)
+	}
+	if err := sw.Check(); err != ErrHalted {
+		t.Fatalf("not halted in memory: %v", err)
+	}
+
+	sw.Clear()
+	if err := sw.Check(); err != nil {
+		t.Fatalf("still halted after clear: %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
-import "github.com/tobyjsullivan/btc-frogger/liveorders"
+import (
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/liveorders"
+)
 
 func main() {
-	liveorders.RunOrders()
+	liveorders.RunOrders(coinbase.ProductEthBtc, coinbase.ProductLtcBtc)
 }
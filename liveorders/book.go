@@ -0,0 +1,178 @@
+package liveorders
+
+import (
+	"sync"
+
+	"github.com/satori/go.uuid"
+)
+
+type order struct {
+	OrderID       uuid.UUID
+	Sequence      uint64
+	Price         int64
+	RemainingSize int64
+	Side          string
+}
+
+// orderBook is a level 3 book for a single product. sequence is the feed sequence of the
+// last message applied to the book.
+//
+// Resting orders are indexed both by ID and by price level so the best prices can be read
+// without scanning every order.
+type orderBook struct {
+	mx         sync.Mutex
+	sequence   uint64
+	OpenOrders map[uuid.UUID]*order
+	bids       *bookSide
+	asks       *bookSide
+}
+
+func newOrderBook() *orderBook {
+	return &orderBook{
+		OpenOrders: make(map[uuid.UUID]*order),
+		bids:       newBookSide(true),
+		asks:       newBookSide(false),
+	}
+}
+
+// reset discards the current contents of the book and replaces them with the orders in the snapshot.
+func (b *orderBook) reset(sequence uint64, orders []*order) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.sequence = sequence
+	b.OpenOrders = make(map[uuid.UUID]*order, len(orders))
+	b.bids = newBookSide(true)
+	b.asks = newBookSide(false)
+	for _, o := range orders {
+		b.OpenOrders[o.OrderID] = o
+		b.side(o.Side).add(o.Price, o.RemainingSize)
+	}
+}
+
+func (b *orderBook) lastSequence() uint64 {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return b.sequence
+}
+
+func (b *orderBook) setSequence(sequence uint64) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.sequence = sequence
+}
+
+func (b *orderBook) openOrder(order *order) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if existing, ok := b.OpenOrders[order.OrderID]; ok {
+		b.side(existing.Side).remove(existing.Price, existing.RemainingSize)
+	}
+
+	b.OpenOrders[order.OrderID] = order
+	b.side(order.Side).add(order.Price, order.RemainingSize)
+}
+
+func (b *orderBook) closeOrder(orderId uuid.UUID) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	o, ok := b.OpenOrders[orderId]
+	if !ok {
+		return
+	}
+
+	delete(b.OpenOrders, orderId)
+	b.side(o.Side).remove(o.Price, o.RemainingSize)
+}
+
+// fillOrder reduces the remaining size of a resting order after a match. Orders which are
+// completely filled are removed from the book.
+func (b *orderBook) fillOrder(orderId uuid.UUID, size int64) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	o, ok := b.OpenOrders[orderId]
+	if !ok {
+		return
+	}
+
+	if size >= o.RemainingSize {
+		delete(b.OpenOrders, orderId)
+		b.side(o.Side).remove(o.Price, o.RemainingSize)
+		return
+	}
+
+	o.RemainingSize -= size
+	b.side(o.Side).reduce(o.Price, size)
+}
+
+// changeOrder updates the size of a resting order. Changes to orders which are not on the
+// book (eg, still in the received state) are ignored.
+func (b *orderBook) changeOrder(orderId uuid.UUID, newSize int64) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	o, ok := b.OpenOrders[orderId]
+	if !ok {
+		return
+	}
+
+	if newSize <= 0 {
+		delete(b.OpenOrders, orderId)
+		b.side(o.Side).remove(o.Price, o.RemainingSize)
+		return
+	}
+
+	b.side(o.Side).reduce(o.Price, o.RemainingSize-newSize)
+	o.RemainingSize = newSize
+}
+
+func (b *orderBook) numOrders() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return len(b.OpenOrders)
+}
+
+// bestBid returns the highest buy price on the book.
+func (b *orderBook) bestBid() (int64, bool) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return b.bids.best()
+}
+
+// bestAsk returns the lowest sell price on the book.
+func (b *orderBook) bestAsk() (int64, bool) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return b.asks.best()
+}
+
+// depth returns up to n aggregated price levels on the side, best first.
+func (b *orderBook) depth(side string, n int) []priceLevel {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return b.side(side).depth(n)
+}
+
+// cumulativeSize returns the total size on the side resting at prices as good as or better than price.
+func (b *orderBook) cumulativeSize(side string, price int64) int64 {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return b.side(side).cumulativeSize(price)
+}
+
+func (b *orderBook) side(side string) *bookSide {
+	if side == SIDE_BUY {
+		return b.bids
+	}
+	return b.asks
+}
@@ -0,0 +1,139 @@
+package liveorders
+
+import "sort"
+
+// priceLevel aggregates all resting orders at a single price.
+type priceLevel struct {
+	Price     int64
+	Size      int64
+	NumOrders int
+}
+
+// bookSide indexes one side of the book by price. prices is kept sorted in ascending order so
+// the best level is at one end and lookups are a binary search.
+type bookSide struct {
+	bids   bool
+	levels map[int64]*priceLevel
+	prices []int64
+}
+
+func newBookSide(bids bool) *bookSide {
+	return &bookSide{
+		bids:   bids,
+		levels: make(map[int64]*priceLevel),
+	}
+}
+
+func (s *bookSide) add(price, size int64) {
+	if lvl, ok := s.levels[price]; ok {
+		lvl.Size += size
+		lvl.NumOrders++
+		return
+	}
+
+	s.levels[price] = &priceLevel{
+		Price:     price,
+		Size:      size,
+		NumOrders: 1,
+	}
+
+	i := sort.Search(len(s.prices), func(i int) bool { return s.prices[i] >= price })
+	s.prices = append(s.prices, 0)
+	copy(s.prices[i+1:], s.prices[i:])
+	s.prices[i] = price
+}
+
+// set replaces the aggregated size at a price, as reported by the level2 channel. A zero size
+// removes the level.
+func (s *bookSide) set(price, size int64) {
+	lvl, ok := s.levels[price]
+	if !ok {
+		if size > 0 {
+			s.add(price, size)
+		}
+		return
+	}
+
+	if size > 0 {
+		lvl.Size = size
+		return
+	}
+
+	s.drop(price)
+}
+
+// reduce takes size away from the level without removing an order from it.
+func (s *bookSide) reduce(price, size int64) {
+	if lvl, ok := s.levels[price]; ok {
+		lvl.Size -= size
+	}
+}
+
+// remove takes an order of the given remaining size out of the level, dropping the level
+// when it is empty.
+func (s *bookSide) remove(price, size int64) {
+	lvl, ok := s.levels[price]
+	if !ok {
+		return
+	}
+
+	lvl.Size -= size
+	lvl.NumOrders--
+	if lvl.NumOrders > 0 {
+		return
+	}
+
+	s.drop(price)
+}
+
+func (s *bookSide) drop(price int64) {
+	delete(s.levels, price)
+	i := sort.Search(len(s.prices), func(i int) bool { return s.prices[i] >= price })
+	if i < len(s.prices) && s.prices[i] == price {
+		s.prices = append(s.prices[:i], s.prices[i+1:]...)
+	}
+}
+
+// level returns the price of the i-th best level.
+func (s *bookSide) level(i int) int64 {
+	if s.bids {
+		return s.prices[len(s.prices)-1-i]
+	}
+	return s.prices[i]
+}
+
+func (s *bookSide) best() (int64, bool) {
+	if len(s.prices) == 0 {
+		return 0, false
+	}
+
+	return s.level(0), true
+}
+
+// depth returns up to n levels, best first.
+func (s *bookSide) depth(n int) []priceLevel {
+	if n > len(s.prices) || n < 0 {
+		n = len(s.prices)
+	}
+
+	out := make([]priceLevel, n)
+	for i := 0; i < n; i++ {
+		out[i] = *s.levels[s.level(i)]
+	}
+
+	return out
+}
+
+// cumulativeSize returns the total size resting at prices as good as or better than price.
+func (s *bookSide) cumulativeSize(price int64) int64 {
+	var total int64
+	for i := 0; i < len(s.prices); i++ {
+		p := s.level(i)
+		if (s.bids && p < price) || (!s.bids && p > price) {
+			break
+		}
+		total += s.levels[p].Size
+	}
+
+	return total
+}
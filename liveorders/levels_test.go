@@ -0,0 +1,246 @@
+package liveorders
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/satori/go.uuid"
+)
+
+// scanHighBuy and scanLowSell are the map scans the price level index replaced. They are
+// kept here as the reference for correctness and the baseline for the benchmarks.
+func scanHighBuy(orders map[uuid.UUID]*order) (int64, bool) {
+	var high int64
+	found := false
+	for _, o := range orders {
+		if o.Side != SIDE_BUY {
+			continue
+		}
+		if !found || o.Price > high {
+			high = o.Price
+			found = true
+		}
+	}
+
+	return high, found
+}
+
+func scanLowSell(orders map[uuid.UUID]*order) (int64, bool) {
+	var low int64
+	found := false
+	for _, o := range orders {
+		if o.Side != SIDE_SELL {
+			continue
+		}
+		if !found || o.Price < low {
+			low = o.Price
+			found = true
+		}
+	}
+
+	return low, found
+}
+
+// scanDepth aggregates the side's orders into levels, best first.
+func scanDepth(orders map[uuid.UUID]*order, side string) []priceLevel {
+	levels := make(map[int64]*priceLevel)
+	for _, o := range orders {
+		if o.Side != side {
+			continue
+		}
+		lvl, ok := levels[o.Price]
+		if !ok {
+			lvl = &priceLevel{Price: o.Price}
+			levels[o.Price] = lvl
+		}
+		lvl.Size += o.RemainingSize
+		lvl.NumOrders++
+	}
+
+	out := make([]priceLevel, 0, len(levels))
+	for _, lvl := range levels {
+		out = append(out, *lvl)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if side == SIDE_BUY {
+			return out[i].Price > out[j].Price
+		}
+		return out[i].Price < out[j].Price
+	})
+
+	return out
+}
+
+func randomOrder(r *rand.Rand) *order {
+	side := SIDE_BUY
+	price := int64(5000000 - r.Intn(200)*1000)
+	if r.Intn(2) == 0 {
+		side = SIDE_SELL
+		price = int64(5001000 + r.Intn(200)*1000)
+	}
+
+	return &order{
+		OrderID:       uuid.NewV4(),
+		Price:         price,
+		RemainingSize: int64(1 + r.Intn(1000000)),
+		Side:          side,
+	}
+}
+
+func assertMatchesScan(t *testing.T, b *orderBook) {
+	t.Helper()
+
+	wantBid, wantBidOk := scanHighBuy(b.OpenOrders)
+	gotBid, gotBidOk := b.bestBid()
+	if gotBid != wantBid || gotBidOk != wantBidOk {
+		t.Fatalf("bestBid = %d,%v; scan gives %d,%v", gotBid, gotBidOk, wantBid, wantBidOk)
+	}
+
+	wantAsk, wantAskOk := scanLowSell(b.OpenOrders)
+	gotAsk, gotAskOk := b.bestAsk()
+	if gotAsk != wantAsk || gotAskOk != wantAskOk {
+		t.Fatalf("bestAsk = %d,%v; scan gives %d,%v", gotAsk, gotAskOk, wantAsk, wantAskOk)
+	}
+
+	for _, side := range []string{SIDE_BUY, SIDE_SELL} {
+		want := scanDepth(b.OpenOrders, side)
+		got := b.depth(side, -1)
+		if len(got) != len(want) {
+			t.Fatalf("%s depth has %d levels; scan gives %d", side, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s level %d = %+v; scan gives %+v", side, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestBookSideOrdering(t *testing.T) {
+	bids := newBookSide(true)
+	asks := newBookSide(false)
+	for _, p := range []int64{300, 100, 200, 200} {
+		bids.add(p, 1)
+		asks.add(p, 1)
+	}
+
+	if best, _ := bids.best(); best != 300 {
+		t.Errorf("best bid = %d, want 300", best)
+	}
+	if best, _ := asks.best(); best != 100 {
+		t.Errorf("best ask = %d, want 100", best)
+	}
+
+	got := asks.depth(10)
+	if len(got) != 3 || got[1].Price != 200 || got[1].Size != 2 || got[1].NumOrders != 2 {
+		t.Errorf("ask depth = %+v", got)
+	}
+
+	if size := bids.cumulativeSize(200); size != 3 {
+		t.Errorf("bid cumulative size to 200 = %d, want 3", size)
+	}
+	if size := asks.cumulativeSize(200); size != 3 {
+		t.Errorf("ask cumulative size to 200 = %d, want 3", size)
+	}
+
+	bids.remove(300, 1)
+	if best, _ := bids.best(); best != 200 {
+		t.Errorf("best bid after removal = %d, want 200", best)
+	}
+
+	asks.set(100, 0)
+	asks.set(50, 5)
+	if best, _ := asks.best(); best != 50 {
+		t.Errorf("best ask after set = %d, want 50", best)
+	}
+
+	empty := newBookSide(true)
+	if _, ok := empty.best(); ok {
+		t.Error("empty side reports a best price")
+	}
+}
+
+func TestOrderBookMatchesScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	b := newOrderBook()
+
+	var ids []uuid.UUID
+	for i := 0; i < 5000; i++ {
+		switch op := r.Intn(10); {
+		case op < 5 || len(ids) == 0:
+			o := randomOrder(r)
+			b.openOrder(o)
+			ids = append(ids, o.OrderID)
+		case op < 7:
+			i := r.Intn(len(ids))
+			b.closeOrder(ids[i])
+			ids = append(ids[:i], ids[i+1:]...)
+		case op < 9:
+			b.fillOrder(ids[r.Intn(len(ids))], int64(r.Intn(600000)))
+		default:
+			b.changeOrder(ids[r.Intn(len(ids))], int64(r.Intn(600000)))
+		}
+
+		if i%100 == 0 {
+			assertMatchesScan(t, b)
+		}
+	}
+	assertMatchesScan(t, b)
+}
+
+func benchmarkBook(n int) *orderBook {
+	r := rand.New(rand.NewSource(1))
+	orders := make([]*order, n)
+	for i := range orders {
+		orders[i] = randomOrder(r)
+	}
+
+	b := newOrderBook()
+	b.reset(1, orders)
+
+	return b
+}
+
+func BenchmarkBestBidLevels(b *testing.B) {
+	book := benchmarkBook(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		book.bestBid()
+	}
+}
+
+func BenchmarkBestBidMapScan(b *testing.B) {
+	book := benchmarkBook(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scanHighBuy(book.OpenOrders)
+	}
+}
+
+func BenchmarkDepthLevels(b *testing.B) {
+	book := benchmarkBook(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		book.depth(SIDE_SELL, 50)
+	}
+}
+
+func BenchmarkDepthMapScan(b *testing.B) {
+	book := benchmarkBook(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scanDepth(book.OpenOrders, SIDE_SELL)
+	}
+}
+
+func BenchmarkOpenCloseLevels(b *testing.B) {
+	book := benchmarkBook(10000)
+	r := rand.New(rand.NewSource(2))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o := randomOrder(r)
+		book.openOrder(o)
+		book.closeOrder(o.OrderID)
+	}
+}
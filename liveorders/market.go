@@ -0,0 +1,285 @@
+package liveorders
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/feed"
+)
+
+// Market tracks the best bid and ask for a set of products from either the level2 or the
+// ticker channel. Quotes are only reported while the feed is connected.
+type Market struct {
+	channel string
+	logger  *log.Logger
+
+	mx        sync.Mutex
+	connected bool
+	books     map[coinbase.ProductID]*orderBook
+	updated   map[coinbase.ProductID]time.Time
+	tickers   map[coinbase.ProductID]*tickerQuote
+}
+
+type tickerQuote struct {
+	bid int64
+	ask int64
+}
+
+// NewMarket subscribes the client to channel (feed.ChannelLevel2 or feed.ChannelTicker) for
+// each product.
+func NewMarket(client *feed.Client, channel string, productIDs ...coinbase.ProductID) (*Market, error) {
+	if channel != feed.ChannelLevel2 && channel != feed.ChannelTicker {
+		return nil, errors.New("unsupported market channel: " + channel)
+	}
+
+	m := &Market{
+		channel: channel,
+		logger:  log.New(os.Stdout, "[market] ", 0),
+		books:   make(map[coinbase.ProductID]*orderBook),
+		updated: make(map[coinbase.ProductID]time.Time),
+		tickers: make(map[coinbase.ProductID]*tickerQuote),
+	}
+
+	client.OnStateChange(m.stateChanged)
+	for _, pid := range productIDs {
+		pid := pid
+		client.HandleProduct(pid, func(msg []byte) {
+			m.handle(pid, msg)
+		})
+	}
+	client.Subscribe(channel, productIDs...)
+
+	return m, nil
+}
+
+// Quote returns the best bid and ask for the product and when they were last updated. ok is
+// false if the feed is down or no data has been received for the product.
+func (m *Market) Quote(pid coinbase.ProductID) (bid int64, ask int64, at time.Time, ok bool) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if !m.connected {
+		return 0, 0, time.Time{}, false
+	}
+
+	at, ok = m.updated[pid]
+	if !ok {
+		return 0, 0, time.Time{}, false
+	}
+
+	if t, isTicker := m.tickers[pid]; isTicker {
+		return t.bid, t.ask, at, true
+	}
+
+	book, ok := m.books[pid]
+	if !ok {
+		return 0, 0, time.Time{}, false
+	}
+
+	bid, bidOk := book.bestBid()
+	ask, askOk := book.bestAsk()
+	if !bidOk || !askOk {
+		return 0, 0, time.Time{}, false
+	}
+
+	return bid, ask, at, true
+}
+
+// Depth returns up to levels aggregated price levels per side. Only available when tracking
+// the level2 channel.
+func (m *Market) Depth(pid coinbase.ProductID, levels int) (*coinbase.Depth, bool) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	book, ok := m.books[pid]
+	if !m.connected || !ok {
+		return nil, false
+	}
+
+	bids := toBookLevels(book.depth(SIDE_BUY, levels))
+	asks := toBookLevels(book.depth(SIDE_SELL, levels))
+	if len(bids) == 0 || len(asks) == 0 {
+		return nil, false
+	}
+
+	return &coinbase.Depth{
+		Bids: bids,
+		Asks: asks,
+	}, true
+}
+
+func toBookLevels(levels []priceLevel) []*coinbase.BookLevel {
+	out := make([]*coinbase.BookLevel, len(levels))
+	for i, lvl := range levels {
+		out[i] = &coinbase.BookLevel{
+			Price:     lvl.Price,
+			Size:      lvl.Size,
+			NumOrders: lvl.NumOrders,
+		}
+	}
+
+	return out
+}
+
+func (m *Market) stateChanged(state feed.State) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.connected = state == feed.StateConnected
+	if !m.connected {
+		// Everything is stale until the new connection delivers fresh snapshots
+		m.books = make(map[coinbase.ProductID]*orderBook)
+		m.updated = make(map[coinbase.ProductID]time.Time)
+		m.tickers = make(map[coinbase.ProductID]*tickerQuote)
+	}
+}
+
+func (m *Market) handle(pid coinbase.ProductID, msg []byte) {
+	var parsed struct {
+		Type    string      `json:"type"`
+		Bids    [][2]string `json:"bids"`
+		Asks    [][2]string `json:"asks"`
+		Changes [][3]string `json:"changes"`
+		BestBid string      `json:"best_bid"`
+		BestAsk string      `json:"best_ask"`
+	}
+	if err := json.Unmarshal(msg, &parsed); err != nil {
+		m.logger.Println("unmarshal:", err)
+		return
+	}
+
+	var err error
+	switch parsed.Type {
+	case "snapshot":
+		err = m.loadSnapshot(pid, parsed.Bids, parsed.Asks)
+	case "l2update":
+		err = m.applyChanges(pid, parsed.Changes)
+	case "ticker":
+		err = m.updateTicker(pid, parsed.BestBid, parsed.BestAsk)
+	default:
+		return
+	}
+
+	if err != nil {
+		m.logger.Println(parsed.Type+":", pid, err)
+	}
+}
+
+func (m *Market) loadSnapshot(pid coinbase.ProductID, bids, asks [][2]string) error {
+	book := newOrderBook()
+	for _, lvl := range bids {
+		if err := setLevel(book.bids, lvl[0], lvl[1]); err != nil {
+			return err
+		}
+	}
+	for _, lvl := range asks {
+		if err := setLevel(book.asks, lvl[0], lvl[1]); err != nil {
+			return err
+		}
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.books[pid] = book
+	m.updated[pid] = time.Now()
+
+	return nil
+}
+
+type levelChange struct {
+	side  string
+	price int64
+	size  int64
+}
+
+// applyChanges applies an l2update to the book. The whole batch is parsed before any of it is
+// applied, so a bad entry leaves the book as it was.
+func (m *Market) applyChanges(pid coinbase.ProductID, changes [][3]string) error {
+	parsed := make([]levelChange, 0, len(changes))
+	for _, change := range changes {
+		side, err := parseSide(change[0])
+		if err != nil {
+			return err
+		}
+
+		price, size, err := parseLevel(change[1], change[2])
+		if err != nil {
+			return err
+		}
+
+		parsed = append(parsed, levelChange{side: side, price: price, size: size})
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	book, ok := m.books[pid]
+	if !ok {
+		// Updates before the snapshot cannot be applied
+		return nil
+	}
+
+	book.mx.Lock()
+	defer book.mx.Unlock()
+
+	for _, change := range parsed {
+		book.side(change.side).set(change.price, change.size)
+	}
+	m.updated[pid] = time.Now()
+
+	return nil
+}
+
+func (m *Market) updateTicker(pid coinbase.ProductID, strBid, strAsk string) error {
+	bid, err := coinbase.ParseAmount(strBid)
+	if err != nil {
+		return err
+	}
+
+	ask, err := coinbase.ParseAmount(strAsk)
+	if err != nil {
+		return err
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.tickers[pid] = &tickerQuote{
+		bid: bid,
+		ask: ask,
+	}
+	m.updated[pid] = time.Now()
+
+	return nil
+}
+
+func setLevel(side *bookSide, strPrice, strSize string) error {
+	price, size, err := parseLevel(strPrice, strSize)
+	if err != nil {
+		return err
+	}
+
+	side.set(price, size)
+
+	return nil
+}
+
+func parseLevel(strPrice, strSize string) (int64, int64, error) {
+	price, err := coinbase.ParseAmount(strPrice)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, err := coinbase.ParseAmount(strSize)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return price, size, nil
+}
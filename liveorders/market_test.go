@@ -0,0 +1,55 @@
+package liveorders
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/feed"
+)
+
+func testMarket() *Market {
+	return &Market{
+		channel:   feed.ChannelLevel2,
+		logger:    log.New(ioutil.Discard, "", 0),
+		connected: true,
+		books:     make(map[coinbase.ProductID]*orderBook),
+		updated:   make(map[coinbase.ProductID]time.Time),
+		tickers:   make(map[coinbase.ProductID]*tickerQuote),
+	}
+}
+
+func TestMarketLevel2(t *testing.T) {
+	pid := coinbase.ProductEthBtc
+	m := testMarket()
+	m.handle(pid, []byte(`{"type":"snapshot","bids":[["0.05","1"]],"asks":[["0.06","2"]]}`))
+	m.handle(pid, []byte(`{"type":"l2update","changes":[["buy","0.055","3"],["sell","0.06","0"],["sell","0.07","1"]]}`))
+
+	bid, ask, _, ok := m.Quote(pid)
+	if !ok || bid != 5500000 || ask != 7000000 {
+		t.Errorf("got %d/%d (%t), want 0.055/0.07", bid, ask, ok)
+	}
+}
+
+func TestMarketRejectsBadBatch(t *testing.T) {
+	pid := coinbase.ProductEthBtc
+	m := testMarket()
+	m.handle(pid, []byte(`{"type":"snapshot","bids":[["0.05","1"]],"asks":[["0.06","2"]]}`))
+
+	// The first change is valid but the batch isn't, so none of it lands
+	m.handle(pid, []byte(`{"type":"l2update","changes":[["buy","0.055","3"],["sell","bad","1"]]}`))
+	m.handle(pid, []byte(`{"type":"l2update","changes":[["buy","0.056","3"],["both","0.06","1"]]}`))
+
+	depth, ok := m.Depth(pid, 10)
+	if !ok {
+		t.Fatal("no depth")
+	}
+	if len(depth.Bids) != 1 || depth.Bids[0].Price != 5000000 || depth.Bids[0].Size != coinbase.AmountCoin {
+		t.Errorf("bids changed: %+v", depth.Bids[0])
+	}
+	if len(depth.Asks) != 1 || depth.Asks[0].Size != 2*coinbase.AmountCoin {
+		t.Errorf("asks changed: %+v", depth.Asks[0])
+	}
+}
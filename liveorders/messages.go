@@ -0,0 +1,144 @@
+package liveorders
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+// feedMsg holds the union of fields used by the full channel message types.
+type feedMsg struct {
+	Type          string `json:"type"`
+	ProductID     string `json:"product_id"`
+	Sequence      uint64 `json:"sequence"`
+	OrderID       string `json:"order_id"`
+	MakerOrderID  string `json:"maker_order_id"`
+	Side          string `json:"side"`
+	Price         string `json:"price"`
+	Size          string `json:"size"`
+	RemainingSize string `json:"remaining_size"`
+	NewSize       string `json:"new_size"`
+	Reason        string `json:"reason"`
+	Message       string `json:"message"`
+}
+
+func parseFeedMessage(msg []byte) (*feedMsg, error) {
+	parsed := &feedMsg{}
+	if err := json.Unmarshal(msg, parsed); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+type openMsg struct {
+	OrderID       uuid.UUID
+	Sequence      uint64
+	ProductID     string
+	Side          string
+	Price         int64
+	RemainingSize int64
+}
+
+type doneMsg struct {
+	OrderID uuid.UUID
+}
+
+type matchMsg struct {
+	MakerOrderID uuid.UUID
+	Size         int64
+}
+
+type changeMsg struct {
+	OrderID uuid.UUID
+	NewSize int64
+}
+
+func parseOpenMessage(msg *feedMsg) (*openMsg, error) {
+	orderId, err := uuid.FromString(msg.OrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	price, err := coinbase.ParseAmount(msg.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := coinbase.ParseAmount(msg.RemainingSize)
+	if err != nil {
+		return nil, err
+	}
+
+	side, err := parseSide(msg.Side)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openMsg{
+		OrderID:       orderId,
+		Sequence:      msg.Sequence,
+		ProductID:     msg.ProductID,
+		Side:          side,
+		Price:         price,
+		RemainingSize: size,
+	}, nil
+}
+
+func parseDoneMessage(msg *feedMsg) (*doneMsg, error) {
+	orderId, err := uuid.FromString(msg.OrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &doneMsg{
+		OrderID: orderId,
+	}, nil
+}
+
+func parseMatchMessage(msg *feedMsg) (*matchMsg, error) {
+	makerOrderId, err := uuid.FromString(msg.MakerOrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := coinbase.ParseAmount(msg.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &matchMsg{
+		MakerOrderID: makerOrderId,
+		Size:         size,
+	}, nil
+}
+
+func parseChangeMessage(msg *feedMsg) (*changeMsg, error) {
+	orderId, err := uuid.FromString(msg.OrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	newSize, err := coinbase.ParseAmount(msg.NewSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &changeMsg{
+		OrderID: orderId,
+		NewSize: newSize,
+	}, nil
+}
+
+func parseSide(side string) (string, error) {
+	switch side {
+	case "buy":
+		return SIDE_BUY, nil
+	case "sell":
+		return SIDE_SELL, nil
+	default:
+		return "", errors.New("Unknown side: " + side)
+	}
+}
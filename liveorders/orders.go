@@ -1,24 +1,14 @@
 package liveorders
 
-
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
-	"github.com/gorilla/websocket"
-	"net/url"
-	"encoding/json"
-	"github.com/satori/go.uuid"
-	"strconv"
-	"errors"
-	"sync"
 	"time"
-	"net/http"
-	"encoding/base64"
-	"github.com/tobyjsullivan/btc-frogger/coinbase"
-)
 
-const (
-	PRODUCT_ID_ETH_BTC = "ETH-BTC"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/feed"
 )
 
 const (
@@ -26,395 +16,120 @@ const (
 	SIDE_SELL = "sell"
 )
 
-func RunOrders() {
+// RunOrders maintains a level 3 book for each product from the full channel, logging the
+// state of each book periodically.
+func RunOrders(productIDs ...coinbase.ProductID) {
 	// Create a logger
 	logger := log.New(os.Stdout, "[orders] ", 0)
 	logger.Println("Logger instantiated.")
 
-	// Initialize order book
-	logger.Println("Creating order books...")
-	ethOrderBook := newOrderBook()
-	logger.Println("Order book created.")
-
-	// Connect to GDAX
-	logger.Println("Connecting to GDAX...")
-	url, err := url.Parse("wss://ws-feed.gdax.com")
-	if err != nil {
-		logger.Fatalln("parse:", err)
-	}
-	logger.Println("url:", url.String())
-
-	conn, r, err := websocket.DefaultDialer.Dial(url.String(), nil)
-	if err != nil {
-		logger.Fatalln("dial:", err)
-	}
-	defer conn.Close()
-	logger.Println("Connected:", r.Status)
-
-	// Compute sig for authenticated subscribe
-	timestamp := strconv.FormatInt(time.Now().UTC().Unix(), 10)
-	method := http.MethodGet
-	path := "/users/self"
-	accessKey := os.Getenv("COINBASE_API_ACCESS_KEY")
-	passphrase := os.Getenv("COINBASE_API_PASSPHRASE")
-	bSecretKey, err := base64.StdEncoding.DecodeString(os.Getenv("COINBASE_API_SECRET_KEY"))
-	if err != nil {
-		logger.Panicln("decode:", err)
+	// REST connection used to load book snapshots
+	restConn := &coinbase.Conn{
+		Requester: &coinbase.SignedRequester{},
 	}
-	signature := coinbase.ComputeRequestSignature(timestamp, method, path, "", bSecretKey)
 
-	// Send a subscribe message
-	logger.Println("Generating subscribe message...")
-	subscribeMsg := struct {
-		Type string `json:"type"`
-		ProductIDs []string `json:"product_ids"`
-		Signature string `json:"signature"`
-		Key string `json:"key"`
-		Passphrase string `json:"passphrase"`
-		Timestamp string `json:"timestamp"`
-	}{
-		Type: "subscribe",
-		ProductIDs: []string{PRODUCT_ID_ETH_BTC},
-		Signature: base64.StdEncoding.EncodeToString(signature),
-		Key: accessKey,
-		Passphrase: passphrase,
-		Timestamp: timestamp,
-	}
-	msg, err := json.Marshal(&subscribeMsg)
-	if err != nil {
-		logger.Fatalln("marshal:", err)
-	}
-
-	logger.Printf("Sending subscribe message: %s\n", msg)
-	err = conn.WriteMessage(websocket.TextMessage, msg)
-	if err != nil {
-		logger.Fatalln("write:", err)
+	// Initialize order books
+	logger.Println("Creating order books...")
+	snapshots := make(chan *snapshotResult, len(productIDs))
+	syncs := make(map[coinbase.ProductID]*bookSync)
+	for _, pid := range productIDs {
+		syncs[pid] = newBookSync(pid, restConn, snapshots, logger)
 	}
-	logger.Println("Subscribe message sent.")
-
-	// Print order count
-	go func() {
-		ticker := time.Tick(100 * time.Millisecond)
-		for range ticker {
-			logger.Printf("ETH ORDER BOOK: %d open orders\n", ethOrderBook.numOrders())
-		}
-	}()
-
-	// Print high buy order
-	go func() {
-		ticker := time.Tick(300 * time.Millisecond)
-		for range ticker {
-			high := ethOrderBook.highBuy()
+	logger.Println("Order books created.")
 
-			logger.Printf("HIGH BUY: %.8f\n", high)
-		}
-	}()
-
-	// Print low sell order
+	// Connect to GDAX
+	logger.Println("Connecting to GDAX...")
+	client := feed.NewClient(feed.DefaultUrl, &feed.Auth{
+		ApiAccessKey:  os.Getenv("COINBASE_API_ACCESS_KEY"),
+		ApiSecretKey:  os.Getenv("COINBASE_API_SECRET_KEY"),
+		ApiPassphrase: os.Getenv("COINBASE_API_PASSPHRASE"),
+	})
+
+	// Messages and connection state changes share a queue so they are handled in order
+	events := make(chan *feedEvent, 2000)
+	client.OnMessage(func(msg []byte) {
+		events <- &feedEvent{msg: msg}
+	})
+	client.OnStateChange(func(state feed.State) {
+		events <- &feedEvent{state: state}
+	})
+	for pid := range syncs {
+		pid := pid
+		client.HandleProduct(pid, func(msg []byte) {
+			events <- &feedEvent{productID: pid, msg: msg}
+		})
+	}
+	client.Subscribe(feed.ChannelFull, productIDs...)
+
+	// Print book stats
 	go func() {
 		ticker := time.Tick(300 * time.Millisecond)
 		for range ticker {
-			high := ethOrderBook.lowSell()
-
-			logger.Printf("LOW SELL: %.8f\n", high)
-		}
-	}()
+			for pid, s := range syncs {
+				high, _ := s.book.bestBid()
+				low, _ := s.book.bestAsk()
 
-	// Read incoming messages
-	done := make(chan struct{})
-	messageQueue := make(chan string, 2000)
-	go func(c *websocket.Conn, queue chan string, done chan struct{}, logger *log.Logger) {
-		defer close(done)
-		logger.Println("Checking for messages...")
-
-		for {
-			t, message, err := c.ReadMessage()
-			if err != nil {
-				logger.Println("read:", err)
-				return
-			}
-
-			switch t {
-			case websocket.TextMessage:
-				//logger.Printf("Received message: %s\n", message)
-				queue <- string(message)
-			case websocket.BinaryMessage:
-				logger.Println("Received a binary message...")
-				return
-			default:
-				logger.Println("Unexpected case.", t)
-				return
+				logger.Printf("%s ORDER BOOK: %d open orders; HIGH BUY: %s; LOW SELL: %s\n", pid,
+					s.book.numOrders(), fmtAmount(high), fmtAmount(low))
 			}
 		}
-	}(conn, messageQueue, done, logger)
+	}()
 
-	// Parse messages and update stats
-	go func(queue chan string, done chan struct{}, logger *log.Logger) {
-		defer close(done)
+	// Apply messages to the books one at a time, in the order they were received
+	go func(events chan *feedEvent, snapshots chan *snapshotResult, logger *log.Logger) {
 		for {
 			select {
-			case msg := <-queue:
-				go processMessage(ethOrderBook, msg, logger)
+			case ev := <-events:
+				if ev.state == feed.StateConnected {
+					// Anything missed while disconnected is unrecoverable, so start over from a snapshot
+					for _, s := range syncs {
+						s.restart()
+					}
+					continue
+				}
+				if ev.msg != nil {
+					processMessage(syncs[ev.productID], ev.msg, logger)
+				}
+			case res := <-snapshots:
+				if s, ok := syncs[res.productID]; ok {
+					s.loadSnapshot(res)
+				}
 			}
 		}
-	}(messageQueue, done, logger)
+	}(events, snapshots, logger)
 
-	select {
-	case <-done:
-		logger.Println("Done. Goodbye.")
-	}
+	client.Run(context.Background())
 }
 
-func processMessage(ethOrderBook *orderBook, msg string, logger *log.Logger) {
-	parsedMsg := struct {
-		Type string `json:"type"`
-		//OrderId string `json:"order_id"`
-		//ClientOID string `json:"client_oid"`
-		//Side string `json:"side"`
-		//Reason string `json:"reason"`
-		//ProductID string `json:"product_id"`
-		//Price string `json:"price"`
-		//RemainingSize string `json:"remaining_size"`
-		//Size string `json:"size"`
-		//Sequence int `json:"sequence"`
-		//Time string `json:"time"`
-		//OrderType string `json:"order_type"`
+type feedEvent struct {
+	productID coinbase.ProductID
+	msg       []byte
+	state     feed.State
+}
 
-	}{}
-	err := json.Unmarshal([]byte(msg), &parsedMsg)
+func processMessage(s *bookSync, msg []byte, logger *log.Logger) {
+	parsedMsg, err := parseFeedMessage(msg)
 	if err != nil {
-		logger.Println("type unmarshal:", err)
+		logger.Println("unmarshal:", err)
 		return
 	}
 
-	//logger.Println("Message received:", parsedMsg.Type)
 	switch parsedMsg.Type {
-	case "open":
-		openMsg, err := parseOpenMessage(msg, logger)
-		if err != nil {
-			logger.Println("parse open:", err)
-			return
-		}
-
-		order := &order{
-			OrderID: openMsg.OrderID,
-			Sequence: openMsg.Sequence,
-			Price: openMsg.Price,
-			RemainingSize: openMsg.RemainingSize,
-			Side: openMsg.Side,
-		}
-
-		switch openMsg.ProductID {
-		case PRODUCT_ID_ETH_BTC:
-			ethOrderBook.openOrder(order)
-		default:
-			logger.Println("Unknown product ID:", openMsg.ProductID)
-			return
-		}
-	case "done":
-		doneMsg, err := parseDoneMessage(msg, logger)
-		if err != nil {
-			logger.Println("parse done:", err)
-			return
-		}
-
-		ethOrderBook.closeOrder(doneMsg.OrderID)
-	case "match":
-		matchMsg, err := parseMatchMessage(msg, logger)
-		if err != nil {
-			logger.Println("parse match:", err)
-			return
-		}
-		ethOrderBook.closeOrder(matchMsg.MakerOrderID)
-	case "received":
-		// IGNORE
-	default:
-		logger.Println("Message not processed:", parsedMsg.Type)
-	}
-
-}
-
-func parseDoneMessage(msg string, logger *log.Logger) (*doneMsg, error) {
-	parsed := struct {
-		OrderID string `json:"order_id"`
-	}{}
-
-	err := json.Unmarshal([]byte(msg), &parsed)
-	if err != nil {
-		logger.Println("open unmarshal:", err)
-		return nil, err
-	}
-
-	orderId, err := uuid.FromString(parsed.OrderID)
-	if err != nil {
-		logger.Println("orderId:", err)
-		return nil, err
-	}
-
-	return &doneMsg{
-		OrderID: orderId,
-	}, nil
-}
-
-func parseMatchMessage(msg string, logger *log.Logger) (*matchMsg, error) {
-	parsed := struct {
-		MakerOrderId string `json:"maker_order_id"`
-	}{}
-
-	err := json.Unmarshal([]byte(msg), &parsed)
-	if err != nil {
-		logger.Println("open unmarshal:", err)
-		return nil, err
-	}
-
-	makerOrderId, err := uuid.FromString(parsed.MakerOrderId)
-	if err != nil {
-		logger.Println("orderId:", err)
-		return nil, err
-	}
-
-	return &matchMsg{
-		MakerOrderID: makerOrderId,
-	}, nil
-}
-
-func parseOpenMessage(msg string, logger *log.Logger) (*openMsg, error) {
-	parsed := struct {
-		Side string `json:"side"`
-		Price string `json:"price"`
-		OrderID string `json:"order_id"`
-		RemainingSize string `json:"remaining_size"`
-		ProductID string `json:"product_id"`
-		Sequence uint64 `json:"sequence"`
-		Time string `json:"time"`
-	}{}
-
-	err := json.Unmarshal([]byte(msg), &parsed)
-	if err != nil {
-		logger.Println("open unmarshal:", err)
-		return nil, err
-	}
-
-	orderId, err := uuid.FromString(parsed.OrderID)
-	if err != nil {
-		logger.Println("orderId:", err)
-		return nil, err
-	}
-
-	price, err := strconv.ParseFloat(parsed.Price, 64)
-	if err != nil {
-		logger.Println("parse price:", err)
-		return nil, err
-	}
-
-	size, err := strconv.ParseFloat(parsed.Price, 64)
-	if err != nil {
-		logger.Println("parse size:", err)
-		return nil, err
-	}
-
-	var side string
-	switch parsed.Side {
-	case "buy":
-		side = SIDE_BUY
-	case "sell":
-		side = SIDE_SELL
-	default:
-		logger.Println("side: unknown side:", parsed.Side)
-		return nil, errors.New("Unknown side: "+ parsed.Side)
-	}
-
-	return &openMsg{
-		OrderID: orderId,
-		Sequence: parsed.Sequence,
-		ProductID: parsed.ProductID,
-		Side: side,
-		Price: price,
-		RemainingSize: size,
-	}, nil
-}
-
-type openMsg struct {
-	OrderID uuid.UUID
-	Sequence uint64
-	ProductID string
-	Side string
-	Price float64
-	RemainingSize float64
-}
-
-type doneMsg struct {
-	OrderID uuid.UUID
-}
-
-type matchMsg struct {
-	MakerOrderID uuid.UUID
-}
-
-type orderBook struct {
-	mx sync.Mutex
-	OpenOrders map[uuid.UUID]*order
-}
-
-func newOrderBook() *orderBook {
-	return &orderBook{
-		OpenOrders: make(map[uuid.UUID]*order),
-	}
-}
-
-func (b *orderBook) openOrder(order *order) {
-	b.mx.Lock()
-	defer b.mx.Unlock()
-
-	b.OpenOrders[order.OrderID] = order
-}
-
-func (b *orderBook) closeOrder(orderId uuid.UUID) {
-	b.mx.Lock()
-	defer b.mx.Unlock()
-
-	delete(b.OpenOrders, orderId)
-}
-
-func (b *orderBook) numOrders() int {
-	return len(b.OpenOrders)
-}
-
-func (b *orderBook) highBuy() float64 {
-	var high float64
-	for _, o := range b.OpenOrders {
-		if o.Side != SIDE_BUY {
-			continue
-		}
-
-		if o.Price > high {
-			high = o.Price
-		}
+	case "subscriptions", "heartbeat":
+		return
+	case "error":
+		logger.Println("feed error:", parsedMsg.Message, parsedMsg.Reason)
+		return
 	}
 
-	return high
-}
-
-func (b *orderBook) lowSell() float64 {
-	var low float64
-	for _, o := range b.OpenOrders {
-		if o.Side != SIDE_SELL {
-			continue
-		}
-
-		if o.Price < low {
-			low = o.Price
-		}
+	if s == nil {
+		logger.Println("Unknown product ID:", parsedMsg.ProductID)
+		return
 	}
 
-	return low
+	s.handle(parsedMsg)
 }
 
-type order struct {
-	OrderID uuid.UUID
-	Sequence uint64
-	Price float64
-	RemainingSize float64
-	Side string
+func fmtAmount(amount int64) string {
+	return fmt.Sprintf("%.8f", float64(amount)/coinbase.AmountCoin)
 }
-
@@ -0,0 +1,213 @@
+package liveorders
+
+import (
+	"log"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+const (
+	snapshotRetryDelay = 5 * time.Second
+	maxBufferedMsgs    = 50000
+)
+
+type snapshotResult struct {
+	productID  coinbase.ProductID
+	generation uint64
+	book       *coinbase.FullBook
+	err        error
+}
+
+// bookSync keeps an orderBook consistent with the feed. Until a snapshot has been loaded, feed
+// messages are buffered. Once loaded, messages are applied strictly in sequence and any gap
+// triggers a fresh snapshot. Each resync starts a new generation, and snapshots fetched for an
+// earlier one are discarded when they arrive.
+//
+// bookSync is not safe for concurrent use; all calls are expected from the single goroutine
+// which consumes the feed.
+type bookSync struct {
+	productID coinbase.ProductID
+	book      *orderBook
+	fetch     func(coinbase.ProductID) (*coinbase.FullBook, error)
+	snapshots chan<- *snapshotResult
+	logger    *log.Logger
+
+	syncing    bool
+	generation uint64
+	buffer     []*feedMsg
+}
+
+func newBookSync(pid coinbase.ProductID, conn *coinbase.Conn, snapshots chan<- *snapshotResult, logger *log.Logger) *bookSync {
+	return &bookSync{
+		productID: pid,
+		book:      newOrderBook(),
+		fetch:     conn.CurrentFullBook,
+		snapshots: snapshots,
+		logger:    logger,
+	}
+}
+
+// resync discards the current state and requests a new snapshot. Messages received in the
+// meantime are buffered.
+func (s *bookSync) resync() {
+	if s.syncing {
+		return
+	}
+
+	s.logger.Println("Resyncing order book:", s.productID)
+	s.syncing = true
+	s.generation++
+	s.buffer = nil
+	go s.fetchSnapshot(s.generation, 0)
+}
+
+// restart forces a resync even if one is already underway, discarding anything buffered and
+// any snapshot still in flight.
+func (s *bookSync) restart() {
+	s.syncing = false
+	s.resync()
+}
+
+func (s *bookSync) fetchSnapshot(generation uint64, delay time.Duration) {
+	time.Sleep(delay)
+
+	book, err := s.fetch(s.productID)
+	s.snapshots <- &snapshotResult{
+		productID:  s.productID,
+		generation: generation,
+		book:       book,
+		err:        err,
+	}
+}
+
+// loadSnapshot replaces the book contents with the snapshot and replays buffered messages
+// which are newer than it.
+func (s *bookSync) loadSnapshot(res *snapshotResult) {
+	if !s.syncing || res.generation != s.generation {
+		// Requested before the latest resync and possibly older than the gap behind it
+		return
+	}
+
+	if res.err != nil {
+		s.logger.Println("snapshot:", s.productID, res.err)
+		go s.fetchSnapshot(s.generation, snapshotRetryDelay)
+		return
+	}
+
+	orders := make([]*order, 0, len(res.book.Bids)+len(res.book.Asks))
+	for _, o := range res.book.Bids {
+		orders = append(orders, &order{
+			OrderID:       o.OrderID,
+			Sequence:      res.book.Sequence,
+			Price:         o.Price,
+			RemainingSize: o.Size,
+			Side:          SIDE_BUY,
+		})
+	}
+	for _, o := range res.book.Asks {
+		orders = append(orders, &order{
+			OrderID:       o.OrderID,
+			Sequence:      res.book.Sequence,
+			Price:         o.Price,
+			RemainingSize: o.Size,
+			Side:          SIDE_SELL,
+		})
+	}
+	s.book.reset(res.book.Sequence, orders)
+	s.logger.Printf("Loaded snapshot for %s at sequence %d (%d orders)\n", s.productID, res.book.Sequence, len(orders))
+
+	buffered := s.buffer
+	s.buffer = nil
+	s.syncing = false
+
+	for _, msg := range buffered {
+		s.handle(msg)
+		if s.syncing {
+			// A gap in the buffered messages has already triggered another resync
+			return
+		}
+	}
+}
+
+// handle applies a sequenced feed message to the book, buffering it if a snapshot is pending.
+func (s *bookSync) handle(msg *feedMsg) {
+	if s.syncing {
+		if len(s.buffer) >= maxBufferedMsgs {
+			s.logger.Println("Snapshot buffer overflow:", s.productID)
+			s.syncing = false
+			s.resync()
+		}
+		s.buffer = append(s.buffer, msg)
+		return
+	}
+
+	last := s.book.lastSequence()
+	if msg.Sequence <= last {
+		// Already reflected in the snapshot
+		return
+	}
+	if msg.Sequence != last+1 {
+		s.logger.Printf("Sequence gap on %s: expected %d, got %d\n", s.productID, last+1, msg.Sequence)
+		s.resync()
+		s.buffer = append(s.buffer, msg)
+		return
+	}
+
+	if err := s.apply(msg); err != nil {
+		s.logger.Println("apply:", msg.Type, err)
+		s.resync()
+		return
+	}
+	s.book.setSequence(msg.Sequence)
+}
+
+func (s *bookSync) apply(msg *feedMsg) error {
+	switch msg.Type {
+	case "open":
+		openMsg, err := parseOpenMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		s.book.openOrder(&order{
+			OrderID:       openMsg.OrderID,
+			Sequence:      openMsg.Sequence,
+			Price:         openMsg.Price,
+			RemainingSize: openMsg.RemainingSize,
+			Side:          openMsg.Side,
+		})
+	case "done":
+		doneMsg, err := parseDoneMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		s.book.closeOrder(doneMsg.OrderID)
+	case "match":
+		matchMsg, err := parseMatchMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		s.book.fillOrder(matchMsg.MakerOrderID, matchMsg.Size)
+	case "change":
+		if msg.NewSize == "" {
+			// Market orders change funds rather than size and are never on the book
+			return nil
+		}
+
+		changeMsg, err := parseChangeMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		s.book.changeOrder(changeMsg.OrderID, changeMsg.NewSize)
+	case "received", "activate":
+		// Not yet on the book
+	default:
+		s.logger.Println("Message not processed:", msg.Type)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package liveorders
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+// testSync returns a bookSync whose snapshots are served from books in the order they are
+// requested, along with the channel they are delivered on.
+func testSync(t *testing.T, books ...*coinbase.FullBook) (*bookSync, chan *snapshotResult) {
+	snapshots := make(chan *snapshotResult, len(books)+1)
+	s := newBookSync(coinbase.ProductEthBtc, &coinbase.Conn{}, snapshots, log.New(ioutil.Discard, "", 0))
+
+	var mx sync.Mutex
+	s.fetch = func(coinbase.ProductID) (*coinbase.FullBook, error) {
+		mx.Lock()
+		defer mx.Unlock()
+
+		if len(books) == 0 {
+			t.Error("unexpected snapshot request")
+			return nil, errors.New("no more snapshots")
+		}
+		book := books[0]
+		books = books[1:]
+		return book, nil
+	}
+
+	return s, snapshots
+}
+
+func nextSnapshot(t *testing.T, snapshots chan *snapshotResult) *snapshotResult {
+	select {
+	case res := <-snapshots:
+		return res
+	case <-time.After(time.Second):
+		t.Fatal("no snapshot requested")
+		return nil
+	}
+}
+
+func openAt(sequence uint64, id uuid.UUID, price string) *feedMsg {
+	return &feedMsg{
+		Type:          "open",
+		Sequence:      sequence,
+		OrderID:       id.String(),
+		Side:          "buy",
+		Price:         price,
+		RemainingSize: "1",
+	}
+}
+
+func snapshotAt(sequence uint64, bid int64) *coinbase.FullBook {
+	return &coinbase.FullBook{
+		Sequence: sequence,
+		Bids:     []*coinbase.BookOrder{{OrderID: uuid.NewV4(), Price: bid, Size: coinbase.AmountCoin}},
+	}
+}
+
+func TestBookSyncReplaysBuffered(t *testing.T) {
+	s, snapshots := testSync(t, snapshotAt(10, 5*coinbase.AmountCoin))
+	s.resync()
+
+	// Buffered until the snapshot arrives; the first is already part of it
+	s.handle(openAt(10, uuid.NewV4(), "7"))
+	s.handle(openAt(11, uuid.NewV4(), "6"))
+	if n := s.book.numOrders(); n != 0 {
+		t.Fatalf("applied %d orders before the snapshot", n)
+	}
+
+	s.loadSnapshot(nextSnapshot(t, snapshots))
+	if s.syncing {
+		t.Fatal("still syncing after the snapshot")
+	}
+	if seq := s.book.lastSequence(); seq != 11 {
+		t.Errorf("sequence: got %d, want 11", seq)
+	}
+	if bid, _ := s.book.bestBid(); bid != 6*coinbase.AmountCoin {
+		t.Errorf("best bid: got %d, want 6", bid)
+	}
+	if n := s.book.numOrders(); n != 2 {
+		t.Errorf("orders: got %d, want 2", n)
+	}
+}
+
+func TestBookSyncGapResyncs(t *testing.T) {
+	s, snapshots := testSync(t, snapshotAt(10, coinbase.AmountCoin), snapshotAt(13, 2*coinbase.AmountCoin))
+	s.resync()
+	s.loadSnapshot(nextSnapshot(t, snapshots))
+
+	s.handle(openAt(11, uuid.NewV4(), "3"))
+	// 12 never arrives
+	s.handle(openAt(13, uuid.NewV4(), "4"))
+	s.handle(openAt(14, uuid.NewV4(), "5"))
+	if !s.syncing {
+		t.Fatal("a sequence gap didn't trigger a resync")
+	}
+
+	s.loadSnapshot(nextSnapshot(t, snapshots))
+	if seq := s.book.lastSequence(); seq != 14 {
+		t.Errorf("sequence: got %d, want 14", seq)
+	}
+	// The snapshot replaces everything before it, and only 14 is newer
+	if n := s.book.numOrders(); n != 2 {
+		t.Errorf("orders: got %d, want 2", n)
+	}
+	if bid, _ := s.book.bestBid(); bid != 5*coinbase.AmountCoin {
+		t.Errorf("best bid: got %d, want 5", bid)
+	}
+}
+
+func TestBookSyncDiscardsStaleSnapshot(t *testing.T) {
+	s, snapshots := testSync(t, snapshotAt(10, coinbase.AmountCoin), snapshotAt(20, 2*coinbase.AmountCoin))
+	s.resync()
+	stale := nextSnapshot(t, snapshots)
+
+	// A reconnect starts over before the first snapshot lands
+	s.restart()
+	s.loadSnapshot(stale)
+	if !s.syncing {
+		t.Fatal("applied a snapshot requested before the restart")
+	}
+
+	s.loadSnapshot(nextSnapshot(t, snapshots))
+	if s.syncing {
+		t.Fatal("still syncing after the current snapshot")
+	}
+	if seq := s.book.lastSequence(); seq != 20 {
+		t.Errorf("sequence: got %d, want 20", seq)
+	}
+}
+
+func TestBookSyncBufferOverflow(t *testing.T) {
+	last := uint64(maxBufferedMsgs + 2)
+	s, snapshots := testSync(t, snapshotAt(last-1, coinbase.AmountCoin), snapshotAt(last-1, coinbase.AmountCoin))
+	s.resync()
+	for seq := uint64(2); seq <= last; seq++ {
+		s.handle(openAt(seq, uuid.NewV4(), "1"))
+	}
+	if len(s.buffer) != 1 {
+		t.Errorf("buffer: got %d messages after overflow, want 1", len(s.buffer))
+	}
+
+	// Both fetches are in flight at once, so take them oldest first
+	first, second := nextSnapshot(t, snapshots), nextSnapshot(t, snapshots)
+	if first.generation > second.generation {
+		first, second = second, first
+	}
+
+	// Only the snapshot requested after the overflow is used
+	s.loadSnapshot(first)
+	if !s.syncing {
+		t.Error("applied a snapshot requested before the overflow")
+	}
+	s.loadSnapshot(second)
+	if s.syncing {
+		t.Error("still syncing after the current snapshot")
+	}
+	if seq := s.book.lastSequence(); seq != last {
+		t.Errorf("sequence: got %d, want %d", seq, last)
+	}
+}
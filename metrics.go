@@ -0,0 +1,89 @@
+package main
+
+import (
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/execution"
+	"github.com/tobyjsullivan/btc-frogger/metrics"
+	"github.com/tobyjsullivan/btc-frogger/rates"
+	"github.com/tobyjsullivan/btc-frogger/spread"
+)
+
+var (
+	balanceGauge = metrics.NewGaugeVec("frogger_balance",
+		"Total balance held, in coins.", "currency")
+	totalAssetsGauge = metrics.NewGaugeVec("frogger_total_assets_btc",
+		"Total assets valued in BTC.")
+	rateGauge = metrics.NewGaugeVec("frogger_rate",
+		"Latest accepted rate of each product.", "product")
+	spreadGauge = metrics.NewGaugeVec("frogger_spread",
+		"Best ask less best bid, in the quote currency.", "product")
+	weightGauge = metrics.NewGaugeVec("frogger_allocation_weight",
+		"Share of total assets in each currency, actual or target.", "currency", "kind")
+	driftGauge = metrics.NewGaugeVec("frogger_allocation_drift",
+		"Actual less target allocation weight.", "currency")
+	executionProgress = metrics.NewGaugeVec("frogger_execution_progress",
+		"Share of the working parent order filled, or zero when none is working.", "currency")
+	executionSlippage = metrics.NewGaugeVec("frogger_execution_slippage_bps",
+		"Fill price against arrival price of the working and last completed parent orders.", "currency", "state")
+	cycleDuration = metrics.NewHistogramVec("frogger_cycle_duration_seconds",
+		"Time taken by each completed rebalance cycle.", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30})
+)
+
+func recordRates(rateSvc *rates.RateSvc) {
+	for _, pid := range []coinbase.ProductID{coinbase.ProductEthBtc, coinbase.ProductLtcBtc, coinbase.ProductBtcUsd} {
+		if rate, ok := rateSvc.CurrentRate(pid.BaseCurrency(), pid.QuoteCurrency()); ok {
+			rateGauge.With(string(pid)).Set(rate)
+		}
+	}
+}
+
+func recordSpreads(spreadSvc *spread.SpreadSvc) {
+	for _, pid := range []coinbase.ProductID{coinbase.ProductEthBtc, coinbase.ProductLtcBtc} {
+		if q, ok := spreadSvc.CurrentQuote(pid); ok {
+			spreadGauge.With(string(pid)).Set(float64(q.Ask-q.Bid) / coinbase.AmountCoin)
+		}
+	}
+}
+
+func recordExecution(execSvc *execution.ExecutionSvc) {
+	working := make(map[coinbase.Currency]bool)
+	for _, p := range execSvc.Progress() {
+		working[p.Currency] = true
+		if p.Total > 0 {
+			executionProgress.With(string(p.Currency)).Set(float64(p.Filled) / float64(p.Total))
+		}
+		executionSlippage.With(string(p.Currency), "working").Set(p.SlippageBps)
+	}
+	for _, c := range []coinbase.Currency{coinbase.CurrencyEth, coinbase.CurrencyLtc} {
+		if !working[c] {
+			executionProgress.With(string(c)).Set(0)
+		}
+	}
+
+	for _, p := range execSvc.Completed() {
+		executionSlippage.With(string(p.Currency), "completed").Set(p.SlippageBps)
+	}
+}
+
+func recordDistribution(distro *distribution) {
+	balanceGauge.With(string(coinbase.CurrencyBtc)).Set(float64(distro.ntvBtcBalance) / coinbase.AmountCoin)
+	balanceGauge.With(string(coinbase.CurrencyEth)).Set(float64(distro.ntvEthBalance) / coinbase.AmountCoin)
+	balanceGauge.With(string(coinbase.CurrencyLtc)).Set(float64(distro.ntvLtcBalance) / coinbase.AmountCoin)
+	totalAssetsGauge.With().Set(float64(distro.totalAssets) / coinbase.AmountCoin)
+
+	if distro.totalAssets <= 0 {
+		return
+	}
+	total := float64(distro.totalAssets)
+	for _, w := range distro.weights() {
+		weightGauge.With(string(w.currency), "actual").Set(float64(w.actual) / total)
+		weightGauge.With(string(w.currency), "target").Set(float64(w.target) / total)
+		driftGauge.With(string(w.currency)).Set(float64(w.actual-w.target) / total)
+	}
+}
+
+func observeCycle(start time.Time) {
+	cycleDuration.With().Observe(time.Since(start).Seconds())
+}
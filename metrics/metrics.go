@@ -0,0 +1,304 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const labelSeparator = "\xff"
+
+// DefaultBuckets suit latencies in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	typeCounter   = metricType("counter")
+	typeGauge     = metricType("gauge")
+	typeHistogram = metricType("histogram")
+)
+
+// Registry holds metric families and writes them in the Prometheus text exposition format.
+type Registry struct {
+	mx       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Default is the registry the package level constructors register with.
+var Default = NewRegistry()
+
+// family is every series of one metric, keyed by label values.
+type family struct {
+	name       string
+	help       string
+	typ        metricType
+	labelNames []string
+	buckets    []float64
+
+	mx     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+
+	mx      sync.Mutex
+	value   float64
+	counts  []uint64
+	sum     float64
+	samples uint64
+}
+
+func (r *Registry) register(f *family) *family {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if _, ok := r.families[f.name]; ok {
+		panic("metrics: duplicate metric " + f.name)
+	}
+	f.series = make(map[string]*series)
+	r.families[f.name] = f
+
+	return f
+}
+
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, labelSeparator)
+
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if f.typ == typeHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// Counter only goes up.
+type Counter struct {
+	s *series
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter. Negative deltas are ignored.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+
+	c.s.mx.Lock()
+	defer c.s.mx.Unlock()
+
+	c.s.value += delta
+}
+
+type CounterVec struct {
+	f *family
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labelNames...)
+}
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{f: r.register(&family{name: name, help: help, typ: typeCounter, labelNames: labelNames})}
+}
+
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{s: v.f.with(labelValues)}
+}
+
+// Gauge is a value which can go up and down.
+type Gauge struct {
+	s *series
+}
+
+func (g *Gauge) Set(value float64) {
+	g.s.mx.Lock()
+	defer g.s.mx.Unlock()
+
+	g.s.value = value
+}
+
+type GaugeVec struct {
+	f *family
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labelNames...)
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(&family{name: name, help: help, typ: typeGauge, labelNames: labelNames})}
+}
+
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{s: v.f.with(labelValues)}
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.s.mx.Lock()
+	defer h.s.mx.Unlock()
+
+	for i, upper := range h.buckets {
+		if value <= upper {
+			h.s.counts[i]++
+		}
+	}
+	h.s.sum += value
+	h.s.samples++
+}
+
+type HistogramVec struct {
+	f *family
+}
+
+// NewHistogramVec registers a histogram. Nil buckets uses DefaultBuckets.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labelNames...)
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	return &HistogramVec{f: r.register(&family{name: name, help: help, typ: typeHistogram, labelNames: labelNames,
+		buckets: buckets})}
+}
+
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return &Histogram{s: v.f.with(labelValues), buckets: v.f.buckets}
+}
+
+// Handler serves the registry for scraping.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		bw := bufio.NewWriter(w)
+		r.write(bw)
+		bw.Flush()
+	})
+}
+
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+func (r *Registry) write(w *bufio.Writer) {
+	r.mx.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mx.Unlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	for _, f := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+
+		f.mx.Lock()
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		all := make([]*series, len(keys))
+		for i, k := range keys {
+			all[i] = f.series[k]
+		}
+		f.mx.Unlock()
+
+		for _, s := range all {
+			s.mx.Lock()
+			if f.typ == typeHistogram {
+				for i, upper := range f.buckets {
+					fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels(f.labelNames, s.labelValues, "le",
+						formatFloat(upper)), s.counts[i])
+				}
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels(f.labelNames, s.labelValues, "le", "+Inf"),
+					s.samples)
+				fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels(f.labelNames, s.labelValues), formatFloat(s.sum))
+				fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels(f.labelNames, s.labelValues), s.samples)
+			} else {
+				fmt.Fprintf(w, "%s%s %s\n", f.name, labels(f.labelNames, s.labelValues), formatFloat(s.value))
+			}
+			s.mx.Unlock()
+		}
+	}
+}
+
+// labels formats names and values as {a="1",b="2"}, with any extra name/value pairs appended.
+func labels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return helpEscaper.Replace(v)
+}
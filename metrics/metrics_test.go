@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("content type %q", ct)
+	}
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCounterGolden(t *testing.T) {
+	r := NewRegistry()
+	orders := r.NewCounterVec("orders_total", "Orders placed.", "product", "side")
+	orders.With("LTC-BTC", "sell").Inc()
+	orders.With("ETH-BTC", "buy").Add(2)
+	orders.With("ETH-BTC", "buy").Inc()
+	orders.With("ETH-BTC", "buy").Add(-5) // counters never go down
+	r.NewCounterVec("restarts_total", "Restarts.").With().Inc()
+
+	want := `# HELP orders_total Orders placed.
+# TYPE orders_total counter
+orders_total{product="ETH-BTC",side="buy"} 3
+orders_total{product="LTC-BTC",side="sell"} 1
+# HELP restarts_total Restarts.
+# TYPE restarts_total counter
+restarts_total 1
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGaugeGolden(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGaugeVec("rate", "Exchange rate.", "product")
+	g.With("ETH-BTC").Set(0.05)
+	g.With("ETH-BTC").Set(0.0725)
+	g.With("BTC-USD").Set(12500)
+	g.With("bad").Set(math.NaN())
+	g.With("up").Set(math.Inf(1))
+	g.With("small").Set(1e-9)
+
+	want := `# HELP rate Exchange rate.
+# TYPE rate gauge
+rate{product="BTC-USD"} 12500
+rate{product="ETH-BTC"} 0.0725
+rate{product="bad"} NaN
+rate{product="small"} 1e-09
+rate{product="up"} +Inf
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramGolden(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("cycle_seconds", "Cycle duration.", []float64{1, 0.1, 10}, "outcome")
+	for _, v := range []float64{0.05, 0.1, 0.5, 20} {
+		h.With("ok").Observe(v)
+	}
+
+	want := `# HELP cycle_seconds Cycle duration.
+# TYPE cycle_seconds histogram
+cycle_seconds_bucket{outcome="ok",le="0.1"} 2
+cycle_seconds_bucket{outcome="ok",le="1"} 3
+cycle_seconds_bucket{outcome="ok",le="10"} 3
+cycle_seconds_bucket{outcome="ok",le="+Inf"} 4
+cycle_seconds_sum{outcome="ok"} 20.65
+cycle_seconds_count{outcome="ok"} 4
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEscapingGolden(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeVec("escaped", "Help with \\ and\nnewline \"quotes\".", "reason").
+		With("path C:\\tmp\n\"quoted\"").Set(1)
+
+	want := `# HELP escaped Help with \\ and\nnewline "quotes".
+# TYPE escaped gauge
+escaped{reason="path C:\\tmp\n\"quoted\""} 1
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDuplicateAndLabelMismatchPanic(t *testing.T) {
+	r := NewRegistry()
+	v := r.NewCounterVec("dup_total", "Dup.", "a")
+
+	for name, fn := range map[string]func(){
+		"duplicate":      func() { r.NewGaugeVec("dup_total", "Dup.") },
+		"label mismatch": func() { v.With("x", "y") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
@@ -0,0 +1,346 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/journal"
+)
+
+const (
+	EscalateAggressive = EscalationMode("aggressive")
+	EscalateIOC        = EscalationMode("ioc")
+)
+
+// EscalationMode determines how an order is placed once a currency has gone unfilled for too long.
+//
+// EscalateAggressive drops post-only and places a limit order at the best price on the far side
+// of the spread, taking what rests there and leaving the remainder on the book at that price.
+// EscalateIOC takes liquidity with an immediate-or-cancel order priced to fill the whole size
+// against the visible book. Either way the taker fees must fit in the daily budget; when they
+// don't, the order is priced by the usual policy instead.
+type EscalationMode string
+
+// ParseEscalationMode validates a mode name. The empty string means EscalateAggressive.
+func ParseEscalationMode(s string) (EscalationMode, error) {
+	switch EscalationMode(s) {
+	case "":
+		return EscalateAggressive, nil
+	case EscalateAggressive, EscalateIOC:
+		return EscalationMode(s), nil
+	default:
+		return "", errors.New(fmt.Sprintf("Unknown escalation mode: %s", s))
+	}
+}
+
+type EscalationConfig struct {
+	// Escalate after this many consecutive cycles without a fill. Zero disables the cycle check.
+	AfterCycles int
+	// Escalate once a currency has gone unfilled for this long. Zero disables the time check.
+	AfterDuration time.Duration
+	Mode          EscalationMode
+	// Taker fee rate applied to the notional of IOC orders, eg 0.003 for 0.3%.
+	TakerFeeRate float64
+	// Maximum taker fees, in native BTC, spent on escalated orders in any 24 hours. Each order
+	// must fit its estimated fee in the remaining budget and is then charged its actual fill
+	// fees. Once reached, orders are no longer escalated.
+	MaxDailyTakerFees int64
+}
+
+// escalation tracks how long each currency has gone without a fill.
+type escalation struct {
+	unfilledCycles int
+	unfilledSince  time.Time
+	pending        bool // an order was placed this cycle and has not been assessed
+	filled         bool // some part of this cycle's orders filled
+}
+
+type takerFee struct {
+	at  time.Time
+	fee int64
+}
+
+// shouldEscalate reports whether the next order for c should be escalated. Must be called with mx held.
+func (svc *OrderSvc) shouldEscalate(c coinbase.Currency) bool {
+	cfg := svc.cfg.Escalation
+	if cfg == nil {
+		return false
+	}
+
+	esc, ok := svc.escalations[c]
+	if !ok || esc.unfilledCycles == 0 {
+		return false
+	}
+
+	if cfg.AfterCycles > 0 && esc.unfilledCycles >= cfg.AfterCycles {
+		return true
+	}
+	if cfg.AfterDuration > 0 && time.Since(esc.unfilledSince) >= cfg.AfterDuration {
+		return true
+	}
+
+	return false
+}
+
+// recordPlaced notes that an order for c is working this cycle. Must be called with mx held.
+func (svc *OrderSvc) recordPlaced(c coinbase.Currency) {
+	esc, ok := svc.escalations[c]
+	if !ok {
+		esc = &escalation{}
+		svc.escalations[c] = esc
+	}
+	esc.pending = true
+}
+
+// recordFill notes that some part of an order for c filled. Must be called with mx held.
+func (svc *OrderSvc) recordFill(c coinbase.Currency) {
+	ordersFilled.With(string(mSpreadIndex[c])).Inc()
+
+	esc, ok := svc.escalations[c]
+	if !ok {
+		return
+	}
+	esc.filled = true
+}
+
+// endCycle closes out the cycle for every currency with a working order, resetting the
+// counters for those that filled. Must be called with mx held.
+func (svc *OrderSvc) endCycle() {
+	for c, esc := range svc.escalations {
+		if !esc.pending {
+			continue
+		}
+
+		if esc.filled {
+			delete(svc.escalations, c)
+			continue
+		}
+
+		if esc.unfilledCycles == 0 {
+			esc.unfilledSince = time.Now()
+		}
+		esc.unfilledCycles++
+		esc.pending = false
+		svc.logger.Printf("%s unfilled for %d cycles\n", c, esc.unfilledCycles)
+	}
+}
+
+// takerFeeAllowed reports whether an escalated order with the estimated fee fits in what remains of
+// the daily budget. Must be called with mx held.
+func (svc *OrderSvc) takerFeeAllowed(fee int64) bool {
+	cutoff := time.Now().Add(-24 * time.Hour)
+	var spent int64
+	recent := svc.takerFees[:0]
+	for _, f := range svc.takerFees {
+		if f.at.After(cutoff) {
+			recent = append(recent, f)
+			spent += f.fee
+		}
+	}
+	svc.takerFees = recent
+
+	return spent+fee <= svc.cfg.Escalation.MaxDailyTakerFees
+}
+
+// chargeTakerFee counts the fees charged on an escalated order against the daily budget and journals
+// them so the budget survives a restart.
+func (svc *OrderSvc) chargeTakerFee(c coinbase.Currency, order *coinbase.Order, fee int64) {
+	if fee <= 0 {
+		return
+	}
+
+	svc.mx.Lock()
+	svc.takerFees = append(svc.takerFees, takerFee{at: time.Now(), fee: fee})
+	svc.mx.Unlock()
+
+	svc.record(&journal.Entry{
+		Type:      journal.TypeTakerFee,
+		ProductID: order.ProductID,
+		Currency:  c,
+		Side:      order.Side,
+		OrderID:   order.ID.String(),
+		Fee:       fee,
+	})
+}
+
+// loadTakerFees restores the fees charged in the last 24 hours from the journal.
+func (svc *OrderSvc) loadTakerFees() error {
+	entries, err := svc.cfg.Journal.Query(&journal.Filter{
+		Types: []journal.EntryType{journal.TypeTakerFee},
+		Since: time.Now().Add(-24 * time.Hour),
+	})
+	if err != nil {
+		return err
+	}
+
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	for _, e := range entries {
+		svc.takerFees = append(svc.takerFees, takerFee{at: e.Time, fee: e.Fee})
+	}
+
+	return nil
+}
+
+// escalationPrice is the limit price of an escalated order: the best price on the far side of
+// the spread, or for EscalateIOC the price which fills the whole size against the visible book.
+func escalationPrice(mode EscalationMode, side coinbase.OrderSide, size int64, book *BookView) int64 {
+	price := book.Ask
+	if side == coinbase.SideSell {
+		price = book.Bid
+	}
+	if mode == EscalateIOC && book.Depth != nil {
+		if p, err := book.Depth.FillPrice(side, size); err == nil {
+			price = p
+		}
+	}
+
+	return price
+}
+
+// escalatedOrder places an escalated order for h according to the configured mode. It returns
+// false, having placed nothing, when the taker fees wouldn't fit in the daily budget.
+func (svc *OrderSvc) escalatedOrder(h *Handle) (bool, error) {
+	ord := h.Intent
+	pid := mSpreadIndex[ord.Currency]
+	quote, ok := svc.spreadSvc.CurrentQuote(pid)
+	if !ok {
+		svc.logger.Println("Spread wasnt ready:", pid)
+		return true, errors.New("Spread wasnt ready")
+	}
+	book := &BookView{
+		Bid:   quote.Bid,
+		Ask:   quote.Ask,
+		Depth: quote.Depth,
+	}
+
+	mode := svc.cfg.Escalation.Mode
+	price := escalationPrice(mode, ord.Side, ord.NtvAmount, book)
+
+	// Assume the whole size takes liquidity
+	notional := float64(ord.NtvAmount) * float64(price) / coinbase.AmountCoin
+	fee := int64(notional * svc.cfg.Escalation.TakerFeeRate)
+
+	svc.mx.Lock()
+	allowed := svc.takerFeeAllowed(fee)
+	svc.mx.Unlock()
+
+	if !allowed {
+		svc.logger.Printf("Daily taker fee budget can't cover %d; not escalating %s %s\n", fee, ord.Side, ord.Currency)
+		return false, nil
+	}
+
+	if mode == EscalateIOC {
+		svc.logger.Printf("Escalating %s %s to IOC at %d (est. fee %d)\n", ord.Side, ord.Currency, price, fee)
+		return true, svc.submitIOC(h, ord.Currency, ord.Side, ord.NtvAmount, price, fee)
+	}
+
+	svc.logger.Printf("Escalating %s %s to cross at %d (est. fee %d)\n", ord.Side, ord.Currency, price, fee)
+	return true, svc.submitCrossing(h, ord.Currency, ord.Side, ord.NtvAmount, price, fee)
+}
+
+// submitCrossing places a limit order, without post-only, at the best price on the far side.
+// It takes what rests there and the remainder stays on the book as the best price on our side,
+// tracked like any other resting order except that it isn't repriced. Fees charged when it is
+// placed count against the daily budget.
+func (svc *OrderSvc) submitCrossing(h *Handle, c coinbase.Currency, side coinbase.OrderSide, size int64, price int64, estFee int64) error {
+	req := &coinbase.OrderRequest{
+		ProductID: mSpreadIndex[c],
+		Side:      side,
+		Type:      coinbase.OrderTypeLimit,
+		Price:     price,
+		Size:      size,
+	}
+	if err := svc.preTrade(req); err != nil {
+		return err
+	}
+
+	if svc.cfg.DryRun {
+		svc.logger.Println("DRY RUN: order skipped.")
+		return nil
+	}
+
+	order, err := svc.send(c, req)
+	if err != nil {
+		svc.logger.Println("place order:", err)
+		return err
+	}
+	if order.Status == "rejected" {
+		return errors.New("order rejected: " + order.RejectReason)
+	}
+	h.addOrder(order.ID)
+
+	// The placement response predates matching, so look up what was taken
+	fee := estFee
+	if state, err := svc.conn.GetOrder(order.ID); err == nil {
+		fee = state.FillFees
+	} else {
+		svc.logger.Println("crossing order state:", err)
+	}
+	svc.chargeTakerFee(c, order, fee)
+
+	svc.track(h, c, req, order.ID, true)
+
+	return nil
+}
+
+// submitIOC places an immediate-or-cancel order and charges its fees to the daily budget. The
+// estimated fee is charged only when the order's final state can't be read.
+func (svc *OrderSvc) submitIOC(h *Handle, c coinbase.Currency, side coinbase.OrderSide, size int64, price int64, estFee int64) error {
+	req := &coinbase.OrderRequest{
+		ProductID:   mSpreadIndex[c],
+		Side:        side,
+		Type:        coinbase.OrderTypeLimit,
+		Price:       price,
+		Size:        size,
+		TimeInForce: coinbase.TimeInForceIOC,
+	}
+	if err := svc.preTrade(req); err != nil {
+		return err
+	}
+
+	if svc.cfg.DryRun {
+		svc.logger.Println("DRY RUN: order skipped.")
+		return nil
+	}
+
+	order, err := svc.send(c, req)
+	if err != nil {
+		svc.logger.Println("place order:", err)
+		return err
+	}
+	h.addOrder(order.ID)
+
+	// The placement response predates matching, so look up what actually filled
+	filled := order.FilledSize > 0
+	fee := estFee
+	state, err := svc.conn.GetOrder(order.ID)
+	switch err {
+	case nil:
+		fee = state.FillFees
+		if state.FilledSize > 0 {
+			filled = true
+		}
+		svc.releaseRisk(req.ClientOID, size-state.FilledSize)
+	case coinbase.ErrNotFound:
+		// Cancelled without any fills
+		fee = 0
+		svc.releaseRisk(req.ClientOID, size)
+	default:
+		svc.logger.Println("IOC order state:", err)
+	}
+	svc.chargeTakerFee(c, order, fee)
+
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	svc.recordPlaced(c)
+	if filled {
+		svc.recordFill(c)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package orders
+
+import (
+	"testing"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+func TestEscalationPriceMovesPastPolicy(t *testing.T) {
+	tick := int64(coinbase.QuoteIncrement)
+	base, err := ParsePricingPolicy("undercut:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, spread := range []int64{1, 10} {
+		bid, ask := 500*tick, (500+spread)*tick
+		book := &BookView{Bid: bid, Ask: ask, Depth: testDepth(tick, bid, ask)}
+
+		for _, mode := range []EscalationMode{EscalateAggressive, EscalateIOC} {
+			buyBase, _ := quotePrice(base, coinbase.SideBuy, coinbase.AmountCoin, book)
+			buy := escalationPrice(mode, coinbase.SideBuy, coinbase.AmountCoin, book)
+			if buy == buyBase || buy < ask {
+				t.Errorf("%s buy on a %d tick spread: escalated to %d from %d, want at least the ask %d", mode, spread, buy, buyBase, ask)
+			}
+
+			sellBase, _ := quotePrice(base, coinbase.SideSell, coinbase.AmountCoin, book)
+			sell := escalationPrice(mode, coinbase.SideSell, coinbase.AmountCoin, book)
+			if sell == sellBase || sell > bid {
+				t.Errorf("%s sell on a %d tick spread: escalated to %d from %d, want at most the bid %d", mode, spread, sell, sellBase, bid)
+			}
+		}
+	}
+}
+
+func TestEscalationPriceIOCWalksBook(t *testing.T) {
+	tick := int64(coinbase.QuoteIncrement)
+	book := &BookView{Bid: 500 * tick, Ask: 510 * tick, Depth: testDepth(tick, 500*tick, 510*tick)}
+
+	// Two coins need the second level on each side
+	if got := escalationPrice(EscalateIOC, coinbase.SideBuy, 2*coinbase.AmountCoin, book); got != 520*tick {
+		t.Errorf("IOC buy: got %d, want %d", got, 520*tick)
+	}
+	if got := escalationPrice(EscalateIOC, coinbase.SideSell, 2*coinbase.AmountCoin, book); got != 490*tick {
+		t.Errorf("IOC sell: got %d, want %d", got, 490*tick)
+	}
+	// Aggressive only takes the top level
+	if got := escalationPrice(EscalateAggressive, coinbase.SideBuy, 2*coinbase.AmountCoin, book); got != 510*tick {
+		t.Errorf("aggressive buy: got %d, want %d", got, 510*tick)
+	}
+}
+
+func TestParseEscalationMode(t *testing.T) {
+	for s, want := range map[string]EscalationMode{
+		"":           EscalateAggressive,
+		"aggressive": EscalateAggressive,
+		"ioc":        EscalateIOC,
+	} {
+		if got, err := ParseEscalationMode(s); err != nil || got != want {
+			t.Errorf("%q: got %s (%v), want %s", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"agressive", "IOC", "market"} {
+		if _, err := ParseEscalationMode(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
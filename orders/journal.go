@@ -0,0 +1,119 @@
+package orders
+
+import (
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/journal"
+)
+
+// record appends to the journal, if one is configured. Failures are logged but never stop trading.
+func (svc *OrderSvc) record(e *journal.Entry) {
+	if svc.cfg.Journal == nil {
+		return
+	}
+
+	if err := svc.cfg.Journal.Append(e); err != nil {
+		svc.logger.Println("journal:", err)
+	}
+}
+
+// send journals and submits an order request, tagging it with a fresh client order ID so the
+// exchange's record can be matched to ours.
+func (svc *OrderSvc) send(c coinbase.Currency, req *coinbase.OrderRequest) (*coinbase.Order, error) {
+	req.ClientOID = uuid.NewV4()
+
+	svc.record(&journal.Entry{
+		Type:        journal.TypeSubmit,
+		ProductID:   req.ProductID,
+		Currency:    c,
+		Side:        req.Side,
+		OrderType:   req.Type,
+		ClientOID:   req.ClientOID.String(),
+		Price:       req.Price,
+		Size:        req.Size,
+		TimeInForce: req.TimeInForce,
+	})
+
+	order, err := svc.conn.SubmitOrder(req)
+
+	resp := &journal.Entry{
+		Type:      journal.TypeResponse,
+		ProductID: req.ProductID,
+		Currency:  c,
+		Side:      req.Side,
+		ClientOID: req.ClientOID.String(),
+	}
+	if err != nil {
+		resp.Status = "error"
+		resp.Message = err.Error()
+	} else {
+		resp.OrderID = order.ID.String()
+		resp.Status = order.Status
+		resp.Message = order.RejectReason
+		resp.Price = order.Price
+		resp.Size = order.Size
+	}
+	svc.record(resp)
+
+	if svc.cfg.KillSwitch != nil {
+		if err != nil {
+			svc.cfg.KillSwitch.RecordError(err)
+		} else {
+			svc.cfg.KillSwitch.RecordSuccess()
+		}
+	}
+	switch {
+	case err != nil:
+		ordersRejected.With(string(req.ProductID), "error").Inc()
+	case order.Status == "rejected":
+		ordersRejected.With(string(req.ProductID), "exchange").Inc()
+	default:
+		ordersPlaced.With(string(req.ProductID), string(req.Side)).Inc()
+		if svc.cfg.Risk != nil {
+			svc.cfg.Risk.Record(req)
+		}
+	}
+
+	return order, err
+}
+
+// recordFills journals any fills on our products not seen before and passes every fill on to
+// the fill handler.
+func (svc *OrderSvc) recordFills() {
+	if svc.cfg.Journal == nil && svc.cfg.Fills == nil {
+		return
+	}
+
+	for c, pid := range mSpreadIndex {
+		fills, err := svc.conn.ListFills(pid)
+		if err != nil {
+			svc.logger.Println("fills:", err)
+			continue
+		}
+
+		// Oldest first so the journal stays in trade order
+		for i := len(fills) - 1; i >= 0; i-- {
+			f := fills[i]
+			if svc.cfg.Fills != nil {
+				svc.cfg.Fills.RecordFill(f)
+			}
+			if svc.cfg.Journal == nil || svc.cfg.Journal.HasFill(f.ProductID, f.TradeID) {
+				continue
+			}
+
+			svc.record(&journal.Entry{
+				Time:      f.CreatedAt,
+				Type:      journal.TypeFill,
+				ProductID: f.ProductID,
+				Currency:  c,
+				Side:      f.Side,
+				OrderID:   f.OrderID.String(),
+				TradeID:   f.TradeID,
+				Price:     f.Price,
+				Size:      f.Size,
+				Fee:       f.Fee,
+				Status:    f.Liquidity,
+			})
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package orders
+
+import (
+	"github.com/tobyjsullivan/btc-frogger/metrics"
+)
+
+var (
+	ordersPlaced = metrics.NewCounterVec("frogger_orders_placed_total",
+		"Orders accepted by the exchange.", "product", "side")
+	ordersRejected = metrics.NewCounterVec("frogger_orders_rejected_total",
+		"Orders not placed, by reason (halted, risk, exchange, error).", "product", "reason")
+	ordersFilled = metrics.NewCounterVec("frogger_orders_filled_total",
+		"Orders found to have filled in whole or part.", "product")
+	ordersCancelled = metrics.NewCounterVec("frogger_orders_cancelled_total",
+		"Resting orders cancelled, by reason (cycle, reprice).", "product", "reason")
+)
@@ -0,0 +1,209 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+// BookView is the market state a pricing policy works from. Depth may be nil when only the
+// top of book is known.
+type BookView struct {
+	Bid   int64
+	Ask   int64
+	Depth *coinbase.Depth
+}
+
+// PricingPolicy chooses the limit price for a post-only order of the given side and size.
+// Implementations return prices on the tick grid; OrderSvc additionally clamps the result so
+// that it never crosses the book.
+type PricingPolicy interface {
+	LimitPrice(side coinbase.OrderSide, size int64, book *BookView, tick int64) (int64, error)
+}
+
+// ParsePricingPolicy builds a policy from its config name: "join", "improve:N", "undercut:N",
+// "mid" or "depth". N defaults to 1 and must not be negative.
+func ParsePricingPolicy(s string) (PricingPolicy, error) {
+	parts := strings.SplitN(s, ":", 2)
+	switch parts[0] {
+	case "join":
+		return JoinBest{}, nil
+	case "improve":
+		ticks, err := parseTicks(parts)
+		if err != nil {
+			return nil, err
+		}
+		return ImproveBest{Ticks: ticks}, nil
+	case "undercut":
+		ticks, err := parseTicks(parts)
+		if err != nil {
+			return nil, err
+		}
+		return UndercutFar{Ticks: ticks}, nil
+	case "mid":
+		return MidPeg{}, nil
+	case "depth":
+		return DepthWeighted{}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown pricing policy: %s", s))
+	}
+}
+
+func parseTicks(parts []string) (int, error) {
+	if len(parts) < 2 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errors.New(fmt.Sprintf("Negative tick count: %d", n))
+	}
+	return n, nil
+}
+
+// JoinBest rests at the best price on our own side of the book.
+type JoinBest struct{}
+
+func (JoinBest) LimitPrice(side coinbase.OrderSide, size int64, book *BookView, tick int64) (int64, error) {
+	if side == coinbase.SideBuy {
+		return book.Bid, nil
+	}
+	return book.Ask, nil
+}
+
+// ImproveBest steps Ticks increments inside the best price on our own side. When the spread
+// is too narrow to improve that far it improves as much as the spread allows.
+type ImproveBest struct {
+	Ticks int
+}
+
+func (p ImproveBest) LimitPrice(side coinbase.OrderSide, size int64, book *BookView, tick int64) (int64, error) {
+	step := int64(p.Ticks) * tick
+	if side == coinbase.SideBuy {
+		return book.Bid + step, nil
+	}
+	return book.Ask - step, nil
+}
+
+// UndercutFar prices Ticks increments inside the far side of the book: buys just below the
+// best ask and sells just above the best bid. With one tick this is the original OrderSvc
+// pricing.
+type UndercutFar struct {
+	Ticks int
+}
+
+func (p UndercutFar) LimitPrice(side coinbase.OrderSide, size int64, book *BookView, tick int64) (int64, error) {
+	step := int64(p.Ticks) * tick
+	if side == coinbase.SideBuy {
+		return book.Ask - step, nil
+	}
+	return book.Bid + step, nil
+}
+
+// MidPeg rests at the midpoint of the spread, rounded away from the far side.
+type MidPeg struct{}
+
+func (MidPeg) LimitPrice(side coinbase.OrderSide, size int64, book *BookView, tick int64) (int64, error) {
+	mid := (book.Bid + book.Ask) / 2
+	if side == coinbase.SideBuy {
+		return roundDown(mid, tick), nil
+	}
+	return roundUp(mid, tick), nil
+}
+
+// DepthWeighted prices larger orders more aggressively. It takes the volume-weighted price a
+// taker would pay to fill the whole size against the far side and rests halfway between our
+// best price and that, so small orders sit near the top of our side while large orders move
+// toward the far side.
+type DepthWeighted struct{}
+
+func (DepthWeighted) LimitPrice(side coinbase.OrderSide, size int64, book *BookView, tick int64) (int64, error) {
+	if book.Depth == nil {
+		return ImproveBest{Ticks: 1}.LimitPrice(side, size, book, tick)
+	}
+
+	vwap, err := takerVwap(book.Depth, side, size)
+	if err != nil {
+		return 0, err
+	}
+
+	if side == coinbase.SideBuy {
+		return roundDown((book.Bid+vwap)/2, tick), nil
+	}
+	return roundUp((book.Ask+vwap)/2, tick), nil
+}
+
+// takerVwap returns the average price paid to fill size immediately against the book. If the
+// book is too thin the remainder is assumed to fill at the worst visible price.
+func takerVwap(depth *coinbase.Depth, side coinbase.OrderSide, size int64) (int64, error) {
+	levels := depth.Asks
+	if side == coinbase.SideSell {
+		levels = depth.Bids
+	}
+	if len(levels) == 0 {
+		return 0, coinbase.ErrEmptyBookSide
+	}
+
+	var filled int64
+	var notional float64
+	var last int64
+	for _, lvl := range levels {
+		take := lvl.Size
+		if filled+take > size {
+			take = size - filled
+		}
+		notional += float64(take) * float64(lvl.Price)
+		filled += take
+		last = lvl.Price
+		if filled >= size {
+			break
+		}
+	}
+	if filled < size {
+		notional += float64(size-filled) * float64(last)
+	}
+
+	return int64(notional / float64(size)), nil
+}
+
+// postOnlyPrice clamps price so that a post-only order rests on the book rather than taking
+// liquidity. The result is on the tick grid and strictly inside the far side of the spread.
+func postOnlyPrice(side coinbase.OrderSide, price int64, book *BookView, tick int64) (int64, error) {
+	if book.Bid <= 0 || book.Ask <= 0 || book.Bid >= book.Ask {
+		return 0, errors.New(fmt.Sprintf("Invalid spread: %d:%d", book.Bid, book.Ask))
+	}
+
+	switch side {
+	case coinbase.SideBuy:
+		price = roundDown(price, tick)
+		if limit := roundDown(book.Ask-1, tick); price > limit {
+			price = limit
+		}
+	case coinbase.SideSell:
+		price = roundUp(price, tick)
+		if limit := roundUp(book.Bid+1, tick); price < limit {
+			price = limit
+		}
+	default:
+		return 0, errors.New(fmt.Sprintf("Unexpected side: %s", side))
+	}
+
+	if price <= 0 {
+		return 0, errors.New(fmt.Sprintf("Invalid limit price: %d", price))
+	}
+
+	return price, nil
+}
+
+func roundDown(price, tick int64) int64 {
+	return (price / tick) * tick
+}
+
+func roundUp(price, tick int64) int64 {
+	return ((price + tick - 1) / tick) * tick
+}
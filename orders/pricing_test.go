@@ -0,0 +1,204 @@
+package orders
+
+import (
+	"testing"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+func TestUndercutMatchesOriginalPricing(t *testing.T) {
+	tick := int64(coinbase.QuoteIncrement)
+	book := &BookView{Bid: 500 * tick, Ask: 510 * tick}
+
+	pol, err := ParsePricingPolicy("undercut:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		side coinbase.OrderSide
+		want int64
+	}{
+		{coinbase.SideBuy, book.Ask - tick},
+		{coinbase.SideSell, book.Bid + tick},
+	} {
+		price, err := pol.LimitPrice(tc.side, coinbase.AmountCoin, book, tick)
+		if err != nil {
+			t.Fatal(err)
+		}
+		price, err = postOnlyPrice(tc.side, price, book, tick)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if price != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.side, price, tc.want)
+		}
+	}
+}
+
+func TestParsePricingPolicyRejectsNegativeTicks(t *testing.T) {
+	for _, s := range []string{"improve:-1", "undercut:-2", "improve:x", "bogus"} {
+		if _, err := ParsePricingPolicy(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+	for _, s := range []string{"join", "improve", "improve:0", "undercut:3", "mid", "depth"} {
+		if _, err := ParsePricingPolicy(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+}
+
+func allPolicies(t *testing.T) map[string]PricingPolicy {
+	policies := make(map[string]PricingPolicy)
+	for _, s := range []string{"join", "improve:1", "improve:3", "undercut:1", "undercut:0", "mid", "depth"} {
+		pol, err := ParsePricingPolicy(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policies[s] = pol
+	}
+	return policies
+}
+
+// quotePrice prices an order the way OrderSvc does: the policy's price, clamped post-only.
+func quotePrice(pol PricingPolicy, side coinbase.OrderSide, size int64, book *BookView) (int64, error) {
+	tick := int64(coinbase.QuoteIncrement)
+	price, err := pol.LimitPrice(side, size, book, tick)
+	if err != nil {
+		return 0, err
+	}
+	return postOnlyPrice(side, price, book, tick)
+}
+
+func testDepth(tick int64, bid, ask int64) *coinbase.Depth {
+	return &coinbase.Depth{
+		Bids: []*coinbase.BookLevel{{Price: bid, Size: coinbase.AmountCoin}, {Price: bid - 10*tick, Size: coinbase.AmountCoin}},
+		Asks: []*coinbase.BookLevel{{Price: ask, Size: coinbase.AmountCoin}, {Price: ask + 10*tick, Size: coinbase.AmountCoin}},
+	}
+}
+
+func TestPostOnlyNeverCrosses(t *testing.T) {
+	tick := int64(coinbase.QuoteIncrement)
+	for _, spread := range []int64{1, 2, 10} {
+		bid, ask := 500*tick, (500+spread)*tick
+		book := &BookView{Bid: bid, Ask: ask, Depth: testDepth(tick, bid, ask)}
+
+		for name, pol := range allPolicies(t) {
+			for _, size := range []int64{coinbase.AmountCoin / 10, 5 * coinbase.AmountCoin} {
+				buy, err := quotePrice(pol, coinbase.SideBuy, size, book)
+				if err != nil {
+					t.Errorf("%s buy on a %d tick spread: %s", name, spread, err)
+				} else if buy >= ask || buy%tick != 0 {
+					t.Errorf("%s buy on a %d tick spread: %d crosses the ask %d", name, spread, buy, ask)
+				}
+
+				sell, err := quotePrice(pol, coinbase.SideSell, size, book)
+				if err != nil {
+					t.Errorf("%s sell on a %d tick spread: %s", name, spread, err)
+				} else if sell <= bid || sell%tick != 0 {
+					t.Errorf("%s sell on a %d tick spread: %d crosses the bid %d", name, spread, sell, bid)
+				}
+			}
+		}
+	}
+}
+
+func TestPostOnlyOneTickSpread(t *testing.T) {
+	tick := int64(coinbase.QuoteIncrement)
+	book := &BookView{Bid: 500 * tick, Ask: 501 * tick, Depth: testDepth(tick, 500*tick, 501*tick)}
+
+	// With no room inside the spread every policy joins its own side
+	for name, pol := range allPolicies(t) {
+		if buy, _ := quotePrice(pol, coinbase.SideBuy, coinbase.AmountCoin, book); buy != book.Bid {
+			t.Errorf("%s buy: got %d, want the bid %d", name, buy, book.Bid)
+		}
+		if sell, _ := quotePrice(pol, coinbase.SideSell, coinbase.AmountCoin, book); sell != book.Ask {
+			t.Errorf("%s sell: got %d, want the ask %d", name, sell, book.Ask)
+		}
+	}
+}
+
+func TestPostOnlyRejectsLockedBook(t *testing.T) {
+	tick := int64(coinbase.QuoteIncrement)
+	for _, book := range []*BookView{
+		{Bid: 500 * tick, Ask: 500 * tick},
+		{Bid: 501 * tick, Ask: 500 * tick},
+		{Bid: 0, Ask: 500 * tick},
+	} {
+		for name, pol := range allPolicies(t) {
+			for _, side := range []coinbase.OrderSide{coinbase.SideBuy, coinbase.SideSell} {
+				if price, err := quotePrice(pol, side, coinbase.AmountCoin, book); err == nil {
+					t.Errorf("%s %s on %d:%d: got %d, want an error", name, side, book.Bid, book.Ask, price)
+				}
+			}
+		}
+	}
+}
+
+func TestMidPeg(t *testing.T) {
+	tick := int64(coinbase.QuoteIncrement)
+	for _, tc := range []struct {
+		ask      int64
+		buy, sel int64
+	}{
+		{ask: 510 * tick, buy: 505 * tick, sel: 505 * tick},
+		// A mid between ticks rounds away from the far side
+		{ask: 511 * tick, buy: 505 * tick, sel: 506 * tick},
+	} {
+		book := &BookView{Bid: 500 * tick, Ask: tc.ask}
+		if got, _ := (MidPeg{}).LimitPrice(coinbase.SideBuy, coinbase.AmountCoin, book, tick); got != tc.buy {
+			t.Errorf("buy under %d: got %d, want %d", tc.ask, got, tc.buy)
+		}
+		if got, _ := (MidPeg{}).LimitPrice(coinbase.SideSell, coinbase.AmountCoin, book, tick); got != tc.sel {
+			t.Errorf("sell under %d: got %d, want %d", tc.ask, got, tc.sel)
+		}
+	}
+}
+
+func TestDepthWeighted(t *testing.T) {
+	tick := int64(coinbase.QuoteIncrement)
+	coin := int64(coinbase.AmountCoin)
+	book := &BookView{
+		Bid: 500 * tick,
+		Ask: 510 * tick,
+		Depth: &coinbase.Depth{
+			Bids: []*coinbase.BookLevel{{Price: 500 * tick, Size: coin}, {Price: 490 * tick, Size: coin}},
+			Asks: []*coinbase.BookLevel{{Price: 510 * tick, Size: coin}, {Price: 520 * tick, Size: coin}},
+		},
+	}
+
+	for _, tc := range []struct {
+		side coinbase.OrderSide
+		size int64
+		want int64
+	}{
+		// Halfway between our best and the top of the far side
+		{side: coinbase.SideBuy, size: coin / 2, want: 505 * tick},
+		{side: coinbase.SideSell, size: coin / 2, want: 505 * tick},
+		// Larger orders walk the far side: a VWAP of 515 and 495
+		{side: coinbase.SideBuy, size: 2 * coin, want: 507 * tick},
+		{side: coinbase.SideSell, size: 2 * coin, want: 503 * tick},
+		// Beyond the visible book the rest fills at the worst level: a VWAP of 517.5
+		{side: coinbase.SideBuy, size: 4 * coin, want: 508 * tick},
+	} {
+		got, err := (DepthWeighted{}).LimitPrice(tc.side, tc.size, book, tick)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%s %d: got %d, want %d", tc.side, tc.size, got, tc.want)
+		}
+	}
+
+	// Without depth it improves on our best by a tick
+	noDepth := &BookView{Bid: book.Bid, Ask: book.Ask}
+	if got, _ := (DepthWeighted{}).LimitPrice(coinbase.SideBuy, coin, noDepth, tick); got != 501*tick {
+		t.Errorf("buy without depth: got %d, want %d", got, 501*tick)
+	}
+
+	empty := &BookView{Bid: book.Bid, Ask: book.Ask, Depth: &coinbase.Depth{Bids: book.Depth.Bids}}
+	if _, err := (DepthWeighted{}).LimitPrice(coinbase.SideBuy, coin, empty, tick); err == nil {
+		t.Error("priced a buy against an empty ask side")
+	}
+}
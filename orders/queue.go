@@ -0,0 +1,215 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+var (
+	ErrSuperseded = errors.New("superseded by a newer intent for the same product")
+	ErrCancelled  = errors.New("cancelled before processing")
+	ErrTooSmall   = errors.New("trade too small")
+)
+
+// Intent is a request to trade ntvAmount of a currency against BTC. OrderSvc decides the price.
+type Intent struct {
+	Currency  coinbase.Currency
+	Side      coinbase.OrderSide
+	NtvAmount int64
+}
+
+// Handle tracks a submitted intent until OrderSvc has processed it.
+type Handle struct {
+	Intent *Intent
+
+	q    *intentQueue
+	pid  coinbase.ProductID
+	done chan struct{}
+	err  error
+
+	mx       sync.Mutex
+	orderIDs []uuid.UUID
+}
+
+// PlacedHandle returns a handle for an intent already placed as the given orders, outside of
+// any queue. It lets other order placers, such as fakes in tests, stand in for OrderSvc.
+func PlacedHandle(intent *Intent, orderIDs ...uuid.UUID) *Handle {
+	h := &Handle{
+		Intent:   intent,
+		done:     make(chan struct{}),
+		orderIDs: orderIDs,
+	}
+	h.resolve(nil)
+
+	return h
+}
+
+// Done is closed once the intent has been processed, superseded or cancelled.
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Err is the outcome of the intent: nil if an order was placed, otherwise the reason it wasn't.
+// Only meaningful after Done is closed.
+func (h *Handle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the intent has been processed or ctx is done.
+func (h *Handle) Wait(ctx context.Context) error {
+	select {
+	case <-h.done:
+		return h.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cancel withdraws the intent if it has not yet been picked up. Returns false if too late.
+func (h *Handle) Cancel() bool {
+	if h.q == nil {
+		return false
+	}
+
+	return h.q.cancel(h)
+}
+
+// OrderIDs returns the exchange IDs of every order placed for the intent so far, including the
+// replacements placed when it is repriced.
+func (h *Handle) OrderIDs() []uuid.UUID {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	return append([]uuid.UUID(nil), h.orderIDs...)
+}
+
+func (h *Handle) addOrder(id uuid.UUID) {
+	if h == nil {
+		return
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.orderIDs = append(h.orderIDs, id)
+}
+
+func (h *Handle) resolve(err error) {
+	h.err = err
+	close(h.done)
+}
+
+// QueueStats describes the intent queue.
+type QueueStats struct {
+	Depth     int
+	Enqueued  uint64
+	Coalesced uint64
+	Cancelled uint64
+	Processed uint64
+}
+
+// intentQueue holds at most one intent per product; a newer intent replaces the pending one.
+// Adding never blocks.
+type intentQueue struct {
+	mx      sync.Mutex
+	pending map[coinbase.ProductID]*Handle
+	order   []coinbase.ProductID
+	notify  chan struct{}
+	stats   QueueStats
+}
+
+func newIntentQueue() *intentQueue {
+	return &intentQueue{
+		pending: make(map[coinbase.ProductID]*Handle),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+func (q *intentQueue) push(pid coinbase.ProductID, intent *Intent) *Handle {
+	h := &Handle{
+		Intent: intent,
+		q:      q,
+		pid:    pid,
+		done:   make(chan struct{}),
+	}
+
+	q.mx.Lock()
+	if old, ok := q.pending[pid]; ok {
+		q.remove(pid)
+		old.resolve(ErrSuperseded)
+		q.stats.Coalesced++
+	}
+	q.pending[pid] = h
+	q.order = append(q.order, pid)
+	q.stats.Enqueued++
+	q.mx.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return h
+}
+
+// pop returns the oldest pending intent, or nil if there are none.
+func (q *intentQueue) pop() *Handle {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if len(q.order) == 0 {
+		return nil
+	}
+
+	pid := q.order[0]
+	h := q.pending[pid]
+	q.remove(pid)
+	q.stats.Processed++
+
+	return h
+}
+
+func (q *intentQueue) cancel(h *Handle) bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if cur, ok := q.pending[h.pid]; !ok || cur != h {
+		return false
+	}
+
+	q.remove(h.pid)
+	q.stats.Cancelled++
+	h.resolve(ErrCancelled)
+
+	return true
+}
+
+// remove drops the product's pending entry. Must be called with mx held.
+func (q *intentQueue) remove(pid coinbase.ProductID) {
+	delete(q.pending, pid)
+	for i, p := range q.order {
+		if p == pid {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (q *intentQueue) snapshot() QueueStats {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	stats := q.stats
+	stats.Depth = len(q.order)
+
+	return stats
+}
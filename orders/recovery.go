@@ -0,0 +1,174 @@
+package orders
+
+import (
+	"errors"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/journal"
+)
+
+// Reconcile brings the service's view of its orders in line with the exchange after a restart.
+// It must complete before any new intents are submitted.
+//
+// Requests journaled as submitted but without a definite response are looked up by client order
+// ID and their outcome journaled. Open orders on the exchange which match our records are
+// adopted as resting orders so they are repriced and assessed for fills like any other, and
+// survive the first EndCycle. Only one order is tracked per currency, so any others of ours are
+// cancelled. Orders we didn't place are logged and left alone. Fills made while we were down are
+// journaled.
+func (svc *OrderSvc) Reconcile() error {
+	open, err := svc.conn.ListOpenOrders()
+	if err != nil {
+		return err
+	}
+
+	ours := make(map[uuid.UUID]bool)
+	if svc.cfg.Journal != nil {
+		if ours, err = svc.resolveInFlight(); err != nil {
+			return err
+		}
+	}
+
+	adopted := make(map[coinbase.Currency]*restingOrder)
+	var extra []*restingOrder
+	for _, o := range open {
+		if !ours[o.ID] && (o.ClientOID == uuid.Nil || !ours[o.ClientOID]) {
+			svc.logger.Printf("Open order %s on %s was not placed by this bot, leaving it untracked\n", o.ID, o.ProductID)
+			continue
+		}
+
+		c, ok := productCurrency(o.ProductID)
+		if !ok {
+			svc.logger.Printf("Open order %s is on %s which is no longer traded, leaving it untracked\n", o.ID, o.ProductID)
+			continue
+		}
+
+		r := &restingOrder{
+			id:        o.ID,
+			currency:  c,
+			side:      o.Side,
+			size:      o.Size,
+			price:     o.Price,
+			clientOID: o.ClientOID,
+			adopted:   true,
+		}
+		if adopted[c] != nil {
+			extra = append(extra, r)
+			continue
+		}
+		svc.logger.Printf("Adopting open order %s: %s %d @ %d %s\n", o.ID, o.Side, o.Size-o.FilledSize, o.Price, o.ProductID)
+		adopted[c] = r
+	}
+
+	for _, r := range extra {
+		svc.logger.Printf("Cancelling open order %s, already tracking %s on %s\n", r.id, adopted[r.currency].id, mSpreadIndex[r.currency])
+		if err := svc.cancel(r, "reconcile"); err != nil {
+			return err
+		}
+	}
+
+	svc.mx.Lock()
+	for c, r := range adopted {
+		svc.resting[c] = r
+		svc.keepAdopted = true
+	}
+	svc.mx.Unlock()
+
+	// The risk engine's record of them didn't survive the restart, so charge them again in full.
+	// Whatever is cancelled unfilled is credited back as usual.
+	if svc.cfg.Risk != nil {
+		for c, r := range adopted {
+			svc.cfg.Risk.Record(&coinbase.OrderRequest{
+				ProductID: mSpreadIndex[c],
+				Side:      r.side,
+				Price:     r.price,
+				Size:      r.size,
+				ClientOID: r.clientOID,
+			})
+		}
+	}
+
+	svc.recordFills()
+
+	return nil
+}
+
+// resolveInFlight finds journaled requests with no definite outcome and asks the exchange what
+// became of them. It returns the exchange and client order IDs of every order we know we placed.
+func (svc *OrderSvc) resolveInFlight() (map[uuid.UUID]bool, error) {
+	entries, err := svc.cfg.Journal.Query(&journal.Filter{
+		Types: []journal.EntryType{journal.TypeSubmit, journal.TypeResponse},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ours := make(map[uuid.UUID]bool)
+	submits := make(map[string]*journal.Entry)
+	resolved := make(map[string]bool)
+	for _, e := range entries {
+		switch e.Type {
+		case journal.TypeSubmit:
+			submits[e.ClientOID] = e
+		case journal.TypeResponse:
+			if e.Status != "error" {
+				resolved[e.ClientOID] = true
+			}
+			if id, err := uuid.FromString(e.OrderID); err == nil {
+				ours[id] = true
+			}
+		}
+	}
+
+	for clientOid, submit := range submits {
+		oid, err := uuid.FromString(clientOid)
+		if err != nil {
+			continue
+		}
+		ours[oid] = true
+
+		if resolved[clientOid] {
+			continue
+		}
+
+		svc.logger.Println("Resolving in-flight order request:", clientOid)
+		resp := &journal.Entry{
+			Type:      journal.TypeResponse,
+			ProductID: submit.ProductID,
+			Currency:  submit.Currency,
+			Side:      submit.Side,
+			ClientOID: clientOid,
+			Message:   "recovered",
+		}
+
+		order, err := svc.conn.GetOrderByClientOID(oid)
+		switch err {
+		case nil:
+			ours[order.ID] = true
+			resp.OrderID = order.ID.String()
+			resp.Status = order.Status
+			resp.Price = order.Price
+			resp.Size = order.Size
+		case coinbase.ErrNotFound:
+			// Either never reached the exchange or was cancelled without any fill
+			resp.Status = "lost"
+		default:
+			return nil, errors.New("resolve " + clientOid + ": " + err.Error())
+		}
+
+		svc.record(resp)
+	}
+
+	return ours, nil
+}
+
+func productCurrency(pid coinbase.ProductID) (coinbase.Currency, bool) {
+	for c, p := range mSpreadIndex {
+		if p == pid {
+			return c, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,327 @@
+package orders
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/journal"
+	"github.com/tobyjsullivan/btc-frogger/risk"
+)
+
+// fakeExchange serves the orders a test puts on it. Cancelled orders are taken off the book and
+// remembered.
+type fakeExchange struct {
+	mx        sync.Mutex
+	orders    []*coinbase.Order
+	cancelled []uuid.UUID
+	fills     []*coinbase.Fill
+}
+
+func (x *fakeExchange) open(pid coinbase.ProductID, clientOID uuid.UUID) *coinbase.Order {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	o := &coinbase.Order{
+		ID:        uuid.NewV4(),
+		ClientOID: clientOID,
+		ProductID: pid,
+		Side:      coinbase.SideBuy,
+		Price:     5 * coinbase.QuoteIncrement,
+		Size:      coinbase.AmountCoin,
+		Status:    "open",
+	}
+	x.orders = append(x.orders, o)
+	return o
+}
+
+func (x *fakeExchange) SubmitOrder(req *coinbase.OrderRequest) (*coinbase.Order, error) {
+	return nil, errors.New("not supported")
+}
+
+func (x *fakeExchange) GetOrder(id uuid.UUID) (*coinbase.Order, error) {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	for _, o := range x.orders {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	return nil, coinbase.ErrNotFound
+}
+
+func (x *fakeExchange) GetOrderByClientOID(clientOid uuid.UUID) (*coinbase.Order, error) {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	for _, o := range x.orders {
+		if o.ClientOID == clientOid {
+			return o, nil
+		}
+	}
+	return nil, coinbase.ErrNotFound
+}
+
+func (x *fakeExchange) ListOpenOrders() ([]*coinbase.Order, error) {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	var open []*coinbase.Order
+	for _, o := range x.orders {
+		if o.Status == "open" {
+			open = append(open, o)
+		}
+	}
+	return open, nil
+}
+
+func (x *fakeExchange) CancelOrder(id uuid.UUID) error {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	for i, o := range x.orders {
+		if o.ID == id {
+			x.orders = append(x.orders[:i], x.orders[i+1:]...)
+			x.cancelled = append(x.cancelled, id)
+			return nil
+		}
+	}
+	return coinbase.ErrNotFound
+}
+
+func (x *fakeExchange) CancelAllOrders() error {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	for _, o := range x.orders {
+		x.cancelled = append(x.cancelled, o.ID)
+	}
+	x.orders = nil
+	return nil
+}
+
+func (x *fakeExchange) ListFills(pid coinbase.ProductID) ([]*coinbase.Fill, error) {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	var out []*coinbase.Fill
+	for _, f := range x.fills {
+		if f.ProductID == pid {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// testOrderSvc builds a service on the fake exchange without starting its loop.
+func testOrderSvc(x *fakeExchange, cfg *Config) *OrderSvc {
+	return &OrderSvc{
+		conn:        x,
+		queue:       newIntentQueue(),
+		cfg:         cfg,
+		logger:      log.New(ioutil.Discard, "", 0),
+		resting:     make(map[coinbase.Currency]*restingOrder),
+		escalations: make(map[coinbase.Currency]*escalation),
+	}
+}
+
+func tempJournal(t *testing.T, entries ...*journal.Entry) *journal.Journal {
+	dir, err := ioutil.TempDir("", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	j, err := journal.Open(filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+
+	for _, e := range entries {
+		if err := j.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return j
+}
+
+func submitted(clientOID uuid.UUID) *journal.Entry {
+	return &journal.Entry{Type: journal.TypeSubmit, ProductID: coinbase.ProductEthBtc, ClientOID: clientOID.String()}
+}
+
+func responded(clientOID uuid.UUID, o *coinbase.Order) *journal.Entry {
+	return &journal.Entry{Type: journal.TypeResponse, ClientOID: clientOID.String(), OrderID: o.ID.String(), Status: "open"}
+}
+
+func TestReconcileAdoptsOurOrders(t *testing.T) {
+	x := &fakeExchange{}
+	ethOID, ltcOID := uuid.NewV4(), uuid.NewV4()
+	eth := x.open(coinbase.ProductEthBtc, ethOID)
+	// Placed before orders carried a client order ID, known only by the response
+	ltc := x.open(coinbase.ProductLtcBtc, uuid.Nil)
+	j := tempJournal(t, submitted(ethOID), responded(ethOID, eth), submitted(ltcOID), responded(ltcOID, ltc))
+
+	svc := testOrderSvc(x, &Config{Journal: j})
+	if err := svc.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	for c, want := range map[coinbase.Currency]*coinbase.Order{coinbase.CurrencyEth: eth, coinbase.CurrencyLtc: ltc} {
+		got := svc.resting[c]
+		if got == nil || got.id != want.ID || !got.adopted {
+			t.Errorf("%s: got %+v, want adopted %s", c, got, want.ID)
+			continue
+		}
+		if got.side != want.Side || got.size != want.Size || got.price != want.Price {
+			t.Errorf("%s: adopted %+v from %+v", c, got, want)
+		}
+	}
+	if !svc.keepAdopted {
+		t.Error("adopted orders won't survive the first cycle")
+	}
+	if len(x.cancelled) != 0 {
+		t.Errorf("cancelled %v", x.cancelled)
+	}
+}
+
+func TestReconcileLeavesOrphans(t *testing.T) {
+	x := &fakeExchange{}
+	// Neither was ever journaled: one placed by hand, one by another client
+	x.open(coinbase.ProductEthBtc, uuid.Nil)
+	x.open(coinbase.ProductLtcBtc, uuid.NewV4())
+	ours := uuid.NewV4()
+	j := tempJournal(t, submitted(ours), responded(ours, &coinbase.Order{ID: uuid.NewV4()}))
+
+	svc := testOrderSvc(x, &Config{Journal: j})
+	if err := svc.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(svc.resting) != 0 {
+		t.Errorf("adopted orders we didn't place: %+v", svc.resting)
+	}
+	if len(x.cancelled) != 0 {
+		t.Errorf("cancelled orders we didn't place: %v", x.cancelled)
+	}
+
+	// Without a journal nothing can be ours
+	svc = testOrderSvc(x, &Config{})
+	if err := svc.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.resting) != 0 || len(x.cancelled) != 0 {
+		t.Errorf("without a journal: adopted %+v, cancelled %v", svc.resting, x.cancelled)
+	}
+}
+
+func TestReconcileResolvesInFlight(t *testing.T) {
+	x := &fakeExchange{}
+	landed, lost := uuid.NewV4(), uuid.NewV4()
+	o := x.open(coinbase.ProductEthBtc, landed)
+	j := tempJournal(t, submitted(landed), submitted(lost))
+
+	svc := testOrderSvc(x, &Config{Journal: j})
+	if err := svc.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := svc.resting[coinbase.CurrencyEth]; got == nil || got.id != o.ID {
+		t.Errorf("the order that landed wasn't adopted: %+v", got)
+	}
+
+	entries, err := j.Query(&journal.Filter{Types: []journal.EntryType{journal.TypeResponse}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := make(map[string]*journal.Entry)
+	for _, e := range entries {
+		status[e.ClientOID] = e
+	}
+	if e := status[landed.String()]; e == nil || e.Status != "open" || e.OrderID != o.ID.String() || e.Message != "recovered" {
+		t.Errorf("landed: got %+v", e)
+	}
+	if e := status[lost.String()]; e == nil || e.Status != "lost" || e.OrderID != "" {
+		t.Errorf("lost: got %+v", e)
+	}
+
+	// Resolved once, the requests aren't looked up again
+	if err := svc.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := j.Query(&journal.Filter{Types: []journal.EntryType{journal.TypeResponse}}); len(again) != len(entries) {
+		t.Errorf("responses: got %d after a second reconcile, want %d", len(again), len(entries))
+	}
+}
+
+func TestReconcileCancelsExtraOrders(t *testing.T) {
+	x := &fakeExchange{}
+	var entries []*journal.Entry
+	var eth []*coinbase.Order
+	for i := 0; i < 3; i++ {
+		oid := uuid.NewV4()
+		o := x.open(coinbase.ProductEthBtc, oid)
+		eth = append(eth, o)
+		entries = append(entries, submitted(oid), responded(oid, o))
+	}
+	j := tempJournal(t, entries...)
+
+	svc := testOrderSvc(x, &Config{Journal: j})
+	if err := svc.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := svc.resting[coinbase.CurrencyEth]; got == nil || got.id != eth[0].ID {
+		t.Fatalf("adopted %+v, want %s", got, eth[0].ID)
+	}
+	if len(x.cancelled) != 2 || x.cancelled[0] != eth[1].ID || x.cancelled[1] != eth[2].ID {
+		t.Errorf("cancelled %v, want %s and %s", x.cancelled, eth[1].ID, eth[2].ID)
+	}
+
+	cancels, err := j.Query(&journal.Filter{Types: []journal.EntryType{journal.TypeCancel}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cancels) != 2 || cancels[0].Message != "reconcile" {
+		t.Errorf("journaled cancels: %+v", cancels)
+	}
+}
+
+type fixedRate float64
+
+func (r fixedRate) CurrentRate(from, to coinbase.Currency) (float64, bool) {
+	return float64(r), true
+}
+
+func TestReconcileChargesAdoptedToRisk(t *testing.T) {
+	x := &fakeExchange{}
+	oid := uuid.NewV4()
+	o := x.open(coinbase.ProductEthBtc, oid)
+	j := tempJournal(t, submitted(oid), responded(oid, o))
+
+	// The adopted order is worth all of the hourly turnover at the order's price
+	limits := &risk.Limits{MaxHourlyTurnover: o.Size * o.Price / coinbase.AmountCoin}
+	engine := risk.NewEngine(limits, fixedRate(float64(o.Price)/coinbase.AmountCoin))
+	svc := testOrderSvc(x, &Config{Journal: j, Risk: engine})
+	if err := svc.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	next := &coinbase.OrderRequest{ProductID: coinbase.ProductEthBtc, Side: coinbase.SideBuy, Price: o.Price, Size: coinbase.AmountCoin / 10}
+	if err := engine.Check(next); err == nil {
+		t.Fatal("adopted order wasn't charged")
+	}
+
+	// Cancelled unfilled, it no longer counts
+	svc.cancelOrders(false)
+	if err := engine.Check(next); err != nil {
+		t.Errorf("cancelled adopted order still charged: %v", err)
+	}
+}
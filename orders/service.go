@@ -2,32 +2,117 @@ package orders
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/satori/go.uuid"
 	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/journal"
+	"github.com/tobyjsullivan/btc-frogger/killswitch"
+	"github.com/tobyjsullivan/btc-frogger/risk"
 	"github.com/tobyjsullivan/btc-frogger/spread"
 )
 
 const (
 	coinbaseMinTrade = int64(0.01 * float64(coinbase.AmountCoin))
+
+	repriceInterval = 1 * time.Second
+	fillsInterval   = 30 * time.Second
 )
 
+type Config struct {
+	Pricing PricingPolicy
+	// Resting orders are replaced once the desired price moves at least this many ticks away.
+	// Zero disables repricing.
+	RepriceThresholdTicks int
+	// Upper bound on cancel/replace cycles across all orders in any one minute.
+	MaxRepricesPerMinute int
+	// Nil disables escalation.
+	Escalation *EscalationConfig
+	// Nil disables journaling.
+	Journal *journal.Journal
+	// Nil disables pre-trade risk checks.
+	Risk *risk.Engine
+	// Nil disables the kill switch.
+	KillSwitch *killswitch.Switch
+	// Receives every fill seen while polling, including ones already delivered. Optional.
+	Fills  FillHandler
+	DryRun bool
+}
+
+// Exchange is the part of the exchange API the service trades through. Satisfied by
+// *coinbase.Conn.
+type Exchange interface {
+	SubmitOrder(req *coinbase.OrderRequest) (*coinbase.Order, error)
+	GetOrder(id uuid.UUID) (*coinbase.Order, error)
+	GetOrderByClientOID(clientOid uuid.UUID) (*coinbase.Order, error)
+	ListOpenOrders() ([]*coinbase.Order, error)
+	CancelOrder(id uuid.UUID) error
+	CancelAllOrders() error
+	ListFills(p coinbase.ProductID) ([]*coinbase.Fill, error)
+}
+
+// FillHandler is told about fills on our products. Satisfied by pnl.Tracker.
+type FillHandler interface {
+	RecordFill(f *coinbase.Fill)
+}
+
 type OrderSvc struct {
-	conn       *coinbase.Conn
-	orderQueue chan *orderReq
-	spreadSvc  *spread.SpreadSvc
-	dryRun     bool
-	logger     *log.Logger
+	conn      Exchange
+	queue     *intentQueue
+	spreadSvc *spread.SpreadSvc
+	cfg       *Config
+	logger    *log.Logger
+
+	// Serialises placing and repricing orders in the loop with cancelling them, so nothing is
+	// placed in the middle of a cancel and left behind by it. Held across exchange calls, so
+	// never while holding mx.
+	ops sync.Mutex
+
+	mx          sync.Mutex
+	resting     map[coinbase.Currency]*restingOrder
+	reprices    []time.Time
+	escalations map[coinbase.Currency]*escalation
+	takerFees   []takerFee
+	keepAdopted bool // the next EndCycle leaves adopted orders working
+}
+
+// restingOrder is an order we have placed which may still be on the book.
+type restingOrder struct {
+	id       uuid.UUID
+	currency coinbase.Currency
+	side     coinbase.OrderSide
+	size     int64
+	price    int64
+	// Client order ID the order was sent with, which identifies it to the risk engine
+	clientOID uuid.UUID
+	// The intent the order was placed for. Nil for orders adopted after a restart until one
+	// takes them over.
+	handle *Handle
+	// Placed before a restart and adopted by Reconcile
+	adopted bool
+	// Placed across the spread by an escalation, and left at its price rather than repriced
+	crossing bool
 }
 
-func NewService(ctx context.Context, conn *coinbase.Conn, spreadSvc *spread.SpreadSvc, dryRun bool) *OrderSvc {
+func NewService(ctx context.Context, conn Exchange, spreadSvc *spread.SpreadSvc, cfg *Config) *OrderSvc {
 	svc := &OrderSvc{
-		conn:       conn,
-		orderQueue: make(chan *orderReq, 2),
-		spreadSvc:  spreadSvc,
-		dryRun:     dryRun,
-		logger:     log.New(os.Stdout, "[orders] ", 0),
+		conn:        conn,
+		queue:       newIntentQueue(),
+		spreadSvc:   spreadSvc,
+		cfg:         cfg,
+		logger:      log.New(os.Stdout, "[orders] ", 0),
+		resting:     make(map[coinbase.Currency]*restingOrder),
+		escalations: make(map[coinbase.Currency]*escalation),
+	}
+
+	if cfg.Journal != nil && cfg.Escalation != nil {
+		if err := svc.loadTakerFees(); err != nil {
+			svc.logger.Println("taker fees:", err)
+		}
 	}
 
 	go svc.loop(ctx)
@@ -35,69 +120,491 @@ func NewService(ctx context.Context, conn *coinbase.Conn, spreadSvc *spread.Spre
 	return svc
 }
 
-func (svc *OrderSvc) PlaceOrder(c coinbase.Currency, side coinbase.OrderSide, ntvAmount int64) {
-	svc.orderQueue <- &orderReq{
-		currency:  c,
-		side:      side,
-		ntvAmount: ntvAmount,
+// PlaceOrder queues a single intent. It never blocks.
+func (svc *OrderSvc) PlaceOrder(c coinbase.Currency, side coinbase.OrderSide, ntvAmount int64) *Handle {
+	return svc.Submit(&Intent{
+		Currency:  c,
+		Side:      side,
+		NtvAmount: ntvAmount,
+	})[0]
+}
+
+// Submit queues a batch of intents, processed in the order given. An intent for a product which
+// already has one pending replaces it, and the older handle resolves with ErrSuperseded.
+func (svc *OrderSvc) Submit(intents ...*Intent) []*Handle {
+	handles := make([]*Handle, len(intents))
+	for i, intent := range intents {
+		pid, ok := coinbase.BtcProduct(intent.Currency)
+		if !ok {
+			h := &Handle{Intent: intent, q: svc.queue, done: make(chan struct{})}
+			h.resolve(errors.New("Unexpected currency: " + string(intent.Currency)))
+			handles[i] = h
+			continue
+		}
+
+		handles[i] = svc.queue.push(pid, intent)
 	}
+
+	return handles
 }
 
-type orderReq struct {
-	currency  coinbase.Currency
-	side      coinbase.OrderSide
-	ntvAmount int64
+// QueueStats reports the depth and throughput of the intent queue.
+func (svc *OrderSvc) QueueStats() QueueStats {
+	return svc.queue.snapshot()
 }
 
-func (svc *OrderSvc) loop(ctx context.Context) {
-	mSpreadIndex := map[coinbase.Currency]coinbase.ProductID{
-		coinbase.CurrencyEth: coinbase.ProductEthBtc,
-		coinbase.CurrencyLtc: coinbase.ProductLtcBtc,
+// CancelAll cancels every open order and forgets all resting orders. This marks the end of a
+// cycle, so resting orders are first checked for fills to drive escalation.
+func (svc *OrderSvc) CancelAll() error {
+	return svc.cancelOrders(false)
+}
+
+// EndCycle cancels the orders left from the previous cycle, as CancelAll does, except on the
+// first cycle after Reconcile. Orders it adopted are then left working through that cycle,
+// standing in for new intents on the same side, and are cancelled at the end of it.
+func (svc *OrderSvc) EndCycle() error {
+	svc.mx.Lock()
+	keep := svc.keepAdopted
+	svc.keepAdopted = false
+	svc.mx.Unlock()
+
+	return svc.cancelOrders(keep)
+}
+
+func (svc *OrderSvc) cancelOrders(keepAdopted bool) error {
+	// Let an order being placed or repriced land first so it is cancelled with the rest
+	svc.ops.Lock()
+	defer svc.ops.Unlock()
+
+	svc.mx.Lock()
+	var orders, kept []*restingOrder
+	for _, o := range svc.resting {
+		if keepAdopted && o.adopted {
+			kept = append(kept, o)
+			continue
+		}
+		orders = append(orders, o)
 	}
+	svc.mx.Unlock()
 
-	for {
-		select {
-		case ord := <-svc.orderQueue:
-			svc.logger.Println("Processing order:", ord.side, ord.ntvAmount, ord.currency)
+	var filled []coinbase.Currency
+	for _, o := range orders {
+		if state, err := svc.conn.GetOrder(o.id); err == nil && state.FilledSize > 0 {
+			filled = append(filled, o.currency)
+		}
+	}
 
-			if ord.ntvAmount < coinbaseMinTrade {
-				svc.logger.Println("Skipping: Trade too small.")
-				continue
-			}
+	svc.mx.Lock()
+	for _, c := range filled {
+		svc.recordFill(c)
+	}
+	svc.endCycle()
+	svc.resting = make(map[coinbase.Currency]*restingOrder)
+	for _, o := range kept {
+		svc.resting[o.currency] = o
+	}
+	svc.mx.Unlock()
 
-			// Determine limit price
-			var price int64
-			pid, _ := mSpreadIndex[ord.currency]
-			var spreadReady bool
-			switch ord.side {
-			case coinbase.SideBuy:
-				price, spreadReady = svc.spreadSvc.CurrentAsk(pid)
-				price -= coinbase.QuoteIncrement // Exactly one point below
-			case coinbase.SideSell:
-				price, spreadReady = svc.spreadSvc.CurrentBid(pid)
-				price += coinbase.QuoteIncrement // Exactly one point above current bid
-			default:
-				svc.logger.Println("Unexpected side:", ord.side)
-				continue
-			}
-			if !spreadReady {
-				svc.logger.Println("Spread wasnt ready:", pid)
+	if svc.cfg.DryRun {
+		svc.logger.Println("DRY RUN: Skipping order cancel")
+		return nil
+	}
+
+	if len(kept) > 0 {
+		// Cancelling everything on the exchange would take the adopted orders with it
+		var firstErr error
+		for _, o := range orders {
+			if err := svc.cancel(o, "cycle"); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
 				continue
 			}
+			svc.settle(o)
+		}
+		return firstErr
+	}
 
-			svc.logger.Println("Order limit price:", price)
+	err := svc.conn.CancelAllOrders()
+	cancel := &journal.Entry{
+		Type:    journal.TypeCancel,
+		Message: "all",
+	}
+	if err != nil {
+		cancel.Status = "error"
+		cancel.Message = "all: " + err.Error()
+	} else {
+		for _, o := range orders {
+			ordersCancelled.With(string(mSpreadIndex[o.currency]), "cycle").Inc()
+		}
+	}
+	svc.record(cancel)
 
-			if svc.dryRun {
-				svc.logger.Println("DRY RUN: order skipped.")
-				continue
-			}
+	if err == nil {
+		for _, o := range orders {
+			svc.settle(o)
+		}
+	}
 
-			if err := svc.conn.PlaceOrder(ord.currency, ord.side, ord.ntvAmount, price); err != nil {
-				svc.logger.Println("place order:", err)
-				continue
+	return err
+}
+
+// cancel cancels a single resting order and stops tracking it. An order already gone from the
+// book is not an error.
+func (svc *OrderSvc) cancel(o *restingOrder, reason string) error {
+	err := svc.conn.CancelOrder(o.id)
+	if err != nil && err != coinbase.ErrNotFound {
+		svc.logger.Println("cancel:", err)
+		return err
+	}
+	ordersCancelled.With(string(mSpreadIndex[o.currency]), reason).Inc()
+	svc.record(&journal.Entry{
+		Type:      journal.TypeCancel,
+		ProductID: mSpreadIndex[o.currency],
+		Currency:  o.currency,
+		Side:      o.side,
+		OrderID:   o.id.String(),
+		Price:     o.price,
+		Message:   reason,
+	})
+	svc.forget(o)
+
+	return nil
+}
+
+// settle looks up how much of a cancelled order filled and credits the unfilled remainder back
+// to the risk engine. An order the exchange no longer knows was cancelled without any fills.
+func (svc *OrderSvc) settle(o *restingOrder) (int64, error) {
+	var filled int64
+	state, err := svc.conn.GetOrder(o.id)
+	switch err {
+	case nil:
+		filled = state.FilledSize
+	case coinbase.ErrNotFound:
+	default:
+		svc.logger.Println("get order:", err)
+		return 0, err
+	}
+
+	svc.releaseRisk(o.clientOID, o.size-filled)
+
+	return filled, nil
+}
+
+// releaseRisk credits the unfilled part of a sent order back to the risk engine, if configured.
+func (svc *OrderSvc) releaseRisk(clientOID uuid.UUID, unfilled int64) {
+	if svc.cfg.Risk != nil {
+		svc.cfg.Risk.Release(clientOID, unfilled)
+	}
+}
+
+var mSpreadIndex = map[coinbase.Currency]coinbase.ProductID{
+	coinbase.CurrencyEth: coinbase.ProductEthBtc,
+	coinbase.CurrencyLtc: coinbase.ProductLtcBtc,
+}
+
+func (svc *OrderSvc) loop(ctx context.Context) {
+	ticker := time.NewTicker(repriceInterval)
+	fillsTicker := time.NewTicker(fillsInterval)
+
+	for {
+		select {
+		case <-svc.queue.notify:
+			for h := svc.queue.pop(); h != nil; h = svc.queue.pop() {
+				svc.ops.Lock()
+				err := svc.process(h)
+				svc.ops.Unlock()
+				h.resolve(err)
+			}
+		case <-fillsTicker.C:
+			svc.recordFills()
+		case <-ticker.C:
+			if svc.cfg.RepriceThresholdTicks > 0 {
+				svc.ops.Lock()
+				svc.repriceAll()
+				svc.ops.Unlock()
 			}
 		case <-ctx.Done():
 			return
 		}
 	}
 }
+
+func (svc *OrderSvc) process(h *Handle) error {
+	ord := h.Intent
+	svc.logger.Println("Processing order:", ord.Side, ord.NtvAmount, ord.Currency)
+	svc.record(&journal.Entry{
+		Type:     journal.TypeIntent,
+		Currency: ord.Currency,
+		Side:     ord.Side,
+		Size:     ord.NtvAmount,
+	})
+
+	if ord.NtvAmount < coinbaseMinTrade {
+		svc.logger.Println("Skipping: Trade too small.")
+		return ErrTooSmall
+	}
+
+	if svc.adoptedStandIn(h) {
+		return nil
+	}
+
+	svc.mx.Lock()
+	escalate := svc.shouldEscalate(ord.Currency)
+	svc.mx.Unlock()
+	if escalate {
+		if placed, err := svc.escalatedOrder(h); placed {
+			return err
+		}
+	}
+
+	// Determine limit price
+	pid, _ := mSpreadIndex[ord.Currency]
+	price, err := svc.limitPrice(pid, ord.Side, ord.NtvAmount)
+	if err != nil {
+		svc.logger.Println("limit price:", pid, err)
+		return err
+	}
+
+	svc.logger.Println("Order limit price:", price)
+
+	return svc.submit(h, ord.Currency, ord.Side, ord.NtvAmount, price)
+}
+
+// adoptedStandIn lets an order adopted by Reconcile stand in for an intent on the same side
+// rather than placing a duplicate next to it. An adopted order on the other side is cancelled.
+func (svc *OrderSvc) adoptedStandIn(h *Handle) bool {
+	ord := h.Intent
+
+	svc.mx.Lock()
+	o, ok := svc.resting[ord.Currency]
+	if !ok || !o.adopted {
+		svc.mx.Unlock()
+		return false
+	}
+	if o.side == ord.Side {
+		o.handle = h
+		svc.mx.Unlock()
+
+		svc.logger.Printf("Adopted order %s stands in for %s %s\n", o.id, ord.Side, ord.Currency)
+		h.addOrder(o.id)
+		return true
+	}
+	svc.mx.Unlock()
+
+	if svc.cancel(o, "adopted") == nil {
+		svc.settle(o)
+	}
+	return false
+}
+
+// preTrade runs the order past the kill switch and risk engine, if configured. Dry runs are
+// checked too so their logs show what would have been rejected.
+func (svc *OrderSvc) preTrade(req *coinbase.OrderRequest) error {
+	if svc.cfg.KillSwitch != nil {
+		if err := svc.cfg.KillSwitch.Check(); err != nil {
+			svc.logger.Printf("Skipping %s %s: %s\n", req.Side, req.ProductID, err)
+			ordersRejected.With(string(req.ProductID), "halted").Inc()
+			return err
+		}
+	}
+
+	if svc.cfg.Risk == nil {
+		return nil
+	}
+
+	if err := svc.cfg.Risk.Check(req); err != nil {
+		ordersRejected.With(string(req.ProductID), "risk").Inc()
+		return err
+	}
+
+	return nil
+}
+
+// submit places the order for h and, if it rests on the book, starts tracking it for repricing.
+func (svc *OrderSvc) submit(h *Handle, c coinbase.Currency, side coinbase.OrderSide, size int64, price int64) error {
+	req := &coinbase.OrderRequest{
+		ProductID: mSpreadIndex[c],
+		Side:      side,
+		Type:      coinbase.OrderTypeLimit,
+		Price:     price,
+		Size:      size,
+		PostOnly:  true,
+	}
+	if err := svc.preTrade(req); err != nil {
+		return err
+	}
+
+	if svc.cfg.DryRun {
+		svc.logger.Println("DRY RUN: order skipped.")
+		return nil
+	}
+
+	order, err := svc.send(c, req)
+	if err != nil {
+		svc.logger.Println("place order:", err)
+		return err
+	}
+	if order.Status == "rejected" {
+		return errors.New("order rejected: " + order.RejectReason)
+	}
+	h.addOrder(order.ID)
+
+	svc.track(h, c, req, order.ID, false)
+
+	return nil
+}
+
+// track starts tracking an order placed for h which may rest on the book.
+func (svc *OrderSvc) track(h *Handle, c coinbase.Currency, req *coinbase.OrderRequest, id uuid.UUID, crossing bool) {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	svc.recordPlaced(c)
+	svc.resting[c] = &restingOrder{
+		id:        id,
+		currency:  c,
+		side:      req.Side,
+		size:      req.Size,
+		price:     req.Price,
+		clientOID: req.ClientOID,
+		handle:    h,
+		crossing:  crossing,
+	}
+}
+
+// repriceAll cancels and replaces resting orders whose price has drifted from where the
+// pricing policy would now place them.
+func (svc *OrderSvc) repriceAll() {
+	svc.mx.Lock()
+	orders := make([]*restingOrder, 0, len(svc.resting))
+	for _, o := range svc.resting {
+		if !o.crossing {
+			orders = append(orders, o)
+		}
+	}
+	svc.mx.Unlock()
+
+	threshold := int64(svc.cfg.RepriceThresholdTicks) * coinbase.QuoteIncrement
+	for _, o := range orders {
+		pid := mSpreadIndex[o.currency]
+		desired, err := svc.limitPrice(pid, o.side, o.size)
+		if err != nil {
+			continue
+		}
+
+		diff := desired - o.price
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < threshold {
+			continue
+		}
+
+		if !svc.allowReprice() {
+			svc.logger.Println("Reprice rate limit reached")
+			return
+		}
+
+		svc.reprice(o, desired)
+	}
+}
+
+func (svc *OrderSvc) reprice(o *restingOrder, price int64) {
+	// Orders which have since filled or been cancelled elsewhere need no replacement
+	state, err := svc.conn.GetOrder(o.id)
+	if err == coinbase.ErrNotFound || (err == nil && state.Status == "done") {
+		var filled int64
+		if err == nil {
+			filled = state.FilledSize
+		}
+		if filled > 0 {
+			svc.mx.Lock()
+			svc.recordFill(o.currency)
+			svc.mx.Unlock()
+		}
+		svc.releaseRisk(o.clientOID, o.size-filled)
+		svc.forget(o)
+		return
+	}
+	if err != nil {
+		svc.logger.Println("get order:", err)
+		return
+	}
+
+	svc.logger.Printf("Repricing %s %s order %s: %d -> %d\n", o.side, o.currency, o.id, o.price, price)
+
+	if err := svc.cancel(o, "reprice"); err != nil {
+		return
+	}
+
+	// Only replace what is left after any fills
+	filled, err := svc.settle(o)
+	if err != nil {
+		return
+	}
+	if filled > 0 {
+		svc.mx.Lock()
+		svc.recordFill(o.currency)
+		svc.mx.Unlock()
+	}
+	remaining := o.size - filled
+
+	if remaining < coinbaseMinTrade {
+		svc.logger.Println("Remaining size too small to replace:", remaining)
+		return
+	}
+
+	svc.submit(o.handle, o.currency, o.side, remaining, price)
+}
+
+func (svc *OrderSvc) forget(o *restingOrder) {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	if cur, ok := svc.resting[o.currency]; ok && cur.id == o.id {
+		delete(svc.resting, o.currency)
+	}
+}
+
+// allowReprice records a reprice if it fits within MaxRepricesPerMinute.
+func (svc *OrderSvc) allowReprice() bool {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	cutoff := time.Now().Add(-1 * time.Minute)
+	recent := svc.reprices[:0]
+	for _, t := range svc.reprices {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	svc.reprices = recent
+
+	if svc.cfg.MaxRepricesPerMinute > 0 && len(svc.reprices) >= svc.cfg.MaxRepricesPerMinute {
+		return false
+	}
+
+	svc.reprices = append(svc.reprices, time.Now())
+	return true
+}
+
+func (svc *OrderSvc) limitPrice(pid coinbase.ProductID, side coinbase.OrderSide, size int64) (int64, error) {
+	quote, ok := svc.spreadSvc.CurrentQuote(pid)
+	if !ok {
+		return 0, errors.New("Spread wasnt ready")
+	}
+
+	book := &BookView{
+		Bid:   quote.Bid,
+		Ask:   quote.Ask,
+		Depth: quote.Depth,
+	}
+
+	price, err := svc.cfg.Pricing.LimitPrice(side, size, book, coinbase.QuoteIncrement)
+	if err != nil {
+		return 0, err
+	}
+
+	return postOnlyPrice(side, price, book, coinbase.QuoteIncrement)
+}
@@ -0,0 +1,52 @@
+package orders
+
+import (
+	"testing"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/risk"
+)
+
+func TestRepriceReleasesFinishedOrders(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status string
+		filled int64
+		gone   bool
+	}{
+		{name: "cancelled elsewhere", status: "done"},
+		{name: "partly filled", status: "done", filled: coinbase.AmountCoin / 4},
+		{name: "not found", gone: true},
+	} {
+		x := &fakeExchange{}
+		o := x.open(coinbase.ProductEthBtc, uuid.NewV4())
+		o.Status, o.FilledSize = tc.status, tc.filled
+		if tc.gone {
+			x.CancelOrder(o.ID)
+		}
+
+		limits := &risk.Limits{MaxHourlyTurnover: o.Size * o.Price / coinbase.AmountCoin}
+		engine := risk.NewEngine(limits, fixedRate(float64(o.Price)/coinbase.AmountCoin))
+		svc := testOrderSvc(x, &Config{Risk: engine})
+
+		req := &coinbase.OrderRequest{ProductID: o.ProductID, Side: o.Side, Price: o.Price, Size: o.Size, ClientOID: o.ClientOID}
+		engine.Record(req)
+		svc.track(nil, coinbase.CurrencyEth, req, o.ID, false)
+
+		svc.reprice(svc.resting[coinbase.CurrencyEth], o.Price+coinbase.QuoteIncrement)
+		if len(svc.resting) != 0 {
+			t.Errorf("%s: still tracking %+v", tc.name, svc.resting)
+		}
+
+		// Only what filled stays charged
+		room := &coinbase.OrderRequest{ProductID: o.ProductID, Side: o.Side, Price: o.Price, Size: o.Size - tc.filled}
+		if err := engine.Check(room); err != nil {
+			t.Errorf("%s: unfilled size still charged: %v", tc.name, err)
+		}
+		over := &coinbase.OrderRequest{ProductID: o.ProductID, Side: o.Side, Price: o.Price, Size: o.Size - tc.filled + coinbase.AmountCoin/100}
+		if err := engine.Check(over); err == nil {
+			t.Errorf("%s: filled size was released", tc.name)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package pnl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+// Method is how disposals are matched against acquired lots.
+type Method string
+
+const (
+	MethodFIFO    = Method("fifo")
+	MethodLIFO    = Method("lifo")
+	MethodAverage = Method("average")
+)
+
+func ParseMethod(s string) (Method, error) {
+	switch m := Method(strings.ToLower(s)); m {
+	case MethodFIFO, MethodLIFO, MethodAverage:
+		return m, nil
+	}
+
+	return "", errors.New(fmt.Sprintf("Unknown lot method: %s", s))
+}
+
+// Lot is a quantity of a currency acquired in one go. Costs cover the remaining size and
+// include the fees paid to acquire it. BTC costs are native; USD at the BTC/USD rate of the time.
+type Lot struct {
+	Acquired time.Time
+	Size     int64
+	CostBtc  int64
+	CostUsd  float64
+}
+
+// Disposal is part of a holding given up in a trade, matched against a lot. Under average
+// cost, Acquired is the earliest acquisition in the pool. A zero Acquired means the ledger
+// held no lot to match, so the cost basis is unknown and recorded as zero. Proceeds are net
+// of the fee, which is also given separately.
+type Disposal struct {
+	Currency    coinbase.Currency
+	Acquired    time.Time
+	Disposed    time.Time
+	Size        int64
+	ProceedsBtc int64
+	ProceedsUsd float64
+	CostBtc     int64
+	CostUsd     float64
+	FeeBtc      int64
+	FeeUsd      float64
+	TradeID     int64
+}
+
+func (d *Disposal) GainBtc() int64 {
+	return d.ProceedsBtc - d.CostBtc
+}
+
+func (d *Disposal) GainUsd() float64 {
+	return d.ProceedsUsd - d.CostUsd
+}
+
+// Ledger keeps cost basis lots for every currency, including BTC so USD gains on it are
+// realized when it is spent. It is not safe for concurrent use.
+type Ledger struct {
+	method Method
+
+	lots      map[coinbase.Currency][]*Lot
+	initial   map[coinbase.Currency]int64
+	disposals []*Disposal
+	feesBtc   int64
+	feesUsd   float64
+}
+
+func NewLedger(method Method) *Ledger {
+	return &Ledger{
+		method:  method,
+		lots:    make(map[coinbase.Currency][]*Lot),
+		initial: make(map[coinbase.Currency]int64),
+	}
+}
+
+// Open records a holding that predates the ledger, valued at priceBtc per coin. These opening
+// holdings are the portfolio alpha is measured against.
+func (l *Ledger) Open(c coinbase.Currency, size int64, priceBtc float64, btcUsd float64, at time.Time) {
+	if size <= 0 {
+		return
+	}
+
+	costBtc := int64(float64(size) * priceBtc)
+	l.initial[c] += size
+	l.acquire(c, &Lot{
+		Acquired: at,
+		Size:     size,
+		CostBtc:  costBtc,
+		CostUsd:  float64(costBtc) / coinbase.AmountCoin * btcUsd,
+	})
+}
+
+// AddFill applies a fill. btcUsd is the BTC/USD rate at the time of the fill. Fees, charged
+// in the quote currency, are added to the cost of what is bought or taken from the proceeds
+// of what is sold.
+func (l *Ledger) AddFill(f *coinbase.Fill, btcUsd float64) error {
+	base := f.ProductID.BaseCurrency()
+	quote := f.ProductID.QuoteCurrency()
+	if quote != coinbase.CurrencyBtc && quote != coinbase.CurrencyUsd {
+		return errors.New(fmt.Sprintf("Unsupported product: %s", f.ProductID))
+	}
+	if btcUsd <= 0 {
+		return errors.New("BTC/USD rate required")
+	}
+
+	notional := int64(float64(f.Price) * float64(f.Size) / coinbase.AmountCoin)
+	toBtc := func(amt int64) int64 {
+		if quote == coinbase.CurrencyUsd {
+			return int64(float64(amt) / btcUsd)
+		}
+		return amt
+	}
+	toUsd := func(amt int64) float64 {
+		return float64(toBtc(amt)) / coinbase.AmountCoin * btcUsd
+	}
+
+	feeBtc, feeUsd := toBtc(f.Fee), toUsd(f.Fee)
+	l.feesBtc += feeBtc
+	l.feesUsd += feeUsd
+
+	switch f.Side {
+	case coinbase.SideBuy:
+		spent := notional + f.Fee
+		if quote == coinbase.CurrencyBtc {
+			l.dispose(quote, spent, toBtc(spent), toUsd(spent), feeBtc, feeUsd, f)
+		}
+		l.acquire(base, &Lot{
+			Acquired: f.CreatedAt,
+			Size:     f.Size,
+			CostBtc:  toBtc(spent),
+			CostUsd:  toUsd(spent),
+		})
+	case coinbase.SideSell:
+		received := notional - f.Fee
+		l.dispose(base, f.Size, toBtc(received), toUsd(received), feeBtc, feeUsd, f)
+		if quote == coinbase.CurrencyBtc {
+			l.acquire(quote, &Lot{
+				Acquired: f.CreatedAt,
+				Size:     received,
+				CostBtc:  received,
+				CostUsd:  toUsd(received),
+			})
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unexpected side: %s", f.Side))
+	}
+
+	return nil
+}
+
+// Disposals returns every disposal so far, in the order they happened.
+func (l *Ledger) Disposals() []*Disposal {
+	return l.disposals
+}
+
+// Lots returns the open lots for the currency, oldest first.
+func (l *Ledger) Lots(c coinbase.Currency) []*Lot {
+	return l.lots[c]
+}
+
+func (l *Ledger) acquire(c coinbase.Currency, lot *Lot) {
+	lots := l.lots[c]
+	if l.method == MethodAverage && len(lots) > 0 {
+		pool := lots[0]
+		pool.Size += lot.Size
+		pool.CostBtc += lot.CostBtc
+		pool.CostUsd += lot.CostUsd
+		return
+	}
+
+	l.lots[c] = append(lots, lot)
+}
+
+// dispose consumes size of the currency for the given proceeds, recording one disposal per
+// lot matched. Selling more than the ledger holds, eg from a deposit it never saw, records
+// the excess with an unknown acquisition and zero cost basis.
+func (l *Ledger) dispose(c coinbase.Currency, size int64, proceedsBtc int64, proceedsUsd float64, feeBtc int64,
+	feeUsd float64, f *coinbase.Fill) {
+	tr := &trade{fill: f, size: size, proceedsBtc: proceedsBtc, proceedsUsd: proceedsUsd, feeBtc: feeBtc, feeUsd: feeUsd}
+
+	remaining := size
+	for remaining > 0 && len(l.lots[c]) > 0 {
+		lots := l.lots[c]
+		idx := 0
+		if l.method == MethodLIFO {
+			idx = len(lots) - 1
+		}
+		lot := lots[idx]
+
+		take := remaining
+		if take > lot.Size {
+			take = lot.Size
+		}
+		share := float64(take) / float64(lot.Size)
+		costBtc := int64(float64(lot.CostBtc) * share)
+		costUsd := lot.CostUsd * share
+
+		l.record(c, tr, lot.Acquired, take, costBtc, costUsd)
+
+		lot.Size -= take
+		lot.CostBtc -= costBtc
+		lot.CostUsd -= costUsd
+		if lot.Size == 0 {
+			l.lots[c] = append(lots[:idx], lots[idx+1:]...)
+		}
+		remaining -= take
+	}
+
+	if remaining > 0 {
+		l.record(c, tr, time.Time{}, remaining, 0, 0)
+	}
+}
+
+// trade is the whole of one disposal before it is matched against lots.
+type trade struct {
+	fill        *coinbase.Fill
+	size        int64
+	proceedsBtc int64
+	proceedsUsd float64
+	feeBtc      int64
+	feeUsd      float64
+}
+
+// record adds a disposal of part of a trade, apportioning the trade's proceeds and fee.
+func (l *Ledger) record(c coinbase.Currency, tr *trade, acquired time.Time, part int64, costBtc int64, costUsd float64) {
+	share := float64(part) / float64(tr.size)
+	l.disposals = append(l.disposals, &Disposal{
+		Currency:    c,
+		Acquired:    acquired,
+		Disposed:    tr.fill.CreatedAt,
+		Size:        part,
+		ProceedsBtc: int64(float64(tr.proceedsBtc) * share),
+		ProceedsUsd: tr.proceedsUsd * share,
+		CostBtc:     costBtc,
+		CostUsd:     costUsd,
+		FeeBtc:      int64(float64(tr.feeBtc) * share),
+		FeeUsd:      tr.feeUsd * share,
+		TradeID:     tr.fill.TradeID,
+	})
+}
@@ -0,0 +1,276 @@
+package pnl
+
+import (
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+const (
+	testBtcUsd = 10000.0
+	milliBtc   = coinbase.AmountCoin / 1000
+)
+
+var t0 = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// near allows for the ledger truncating float products to whole native units.
+func near(got, want int64) bool {
+	return got-want <= 2 && want-got <= 2
+}
+
+func ethFill(id int64, side coinbase.OrderSide, priceMilli int64, fee int64) *coinbase.Fill {
+	return &coinbase.Fill{
+		TradeID:   id,
+		ProductID: coinbase.ProductEthBtc,
+		Side:      side,
+		Price:     priceMilli * milliBtc,
+		Size:      coinbase.AmountCoin,
+		Fee:       fee,
+		CreatedAt: t0.Add(time.Duration(id) * time.Minute),
+	}
+}
+
+func TestLedgerAddFill(t *testing.T) {
+	buys := []*coinbase.Fill{
+		ethFill(1, coinbase.SideBuy, 50, 0),
+		ethFill(2, coinbase.SideBuy, 70, 0),
+	}
+	sell := ethFill(3, coinbase.SideSell, 80, 0)
+
+	cases := []struct {
+		name   string
+		method Method
+		fills  []*coinbase.Fill
+		// ETH marked at this many milli-BTC
+		markMilli int64
+
+		realizedBtc   int64
+		unrealizedBtc int64
+		feesBtc       int64
+		ethLots       int
+		acquired      []time.Time // of each ETH disposal, in order
+	}{
+		{
+			name:   "fifo sells the oldest lot",
+			method: MethodFIFO, fills: append(buys, sell), markMilli: 60,
+			realizedBtc: 30 * milliBtc, unrealizedBtc: -10 * milliBtc, ethLots: 1,
+			acquired: []time.Time{buys[0].CreatedAt},
+		},
+		{
+			name:   "lifo sells the newest lot",
+			method: MethodLIFO, fills: append(buys, sell), markMilli: 60,
+			realizedBtc: 10 * milliBtc, unrealizedBtc: 10 * milliBtc, ethLots: 1,
+			acquired: []time.Time{buys[1].CreatedAt},
+		},
+		{
+			name:   "average pools the cost",
+			method: MethodAverage, fills: append(buys, sell), markMilli: 60,
+			realizedBtc: 20 * milliBtc, unrealizedBtc: 0, ethLots: 1,
+			acquired: []time.Time{buys[0].CreatedAt},
+		},
+		{
+			name:   "fees add to cost and come off proceeds",
+			method: MethodFIFO,
+			fills: []*coinbase.Fill{
+				ethFill(1, coinbase.SideBuy, 50, milliBtc/10),
+				ethFill(2, coinbase.SideSell, 60, milliBtc/10),
+			},
+			markMilli:   60,
+			realizedBtc: 98 * milliBtc / 10, feesBtc: 2 * milliBtc / 10, ethLots: 0,
+			acquired: []time.Time{t0.Add(time.Minute)},
+		},
+		{
+			name:        "selling more than held has no cost basis",
+			method:      MethodFIFO,
+			fills:       []*coinbase.Fill{ethFill(1, coinbase.SideSell, 60, 0)},
+			markMilli:   60,
+			realizedBtc: 60 * milliBtc, ethLots: 0,
+			acquired: []time.Time{{}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := NewLedger(tc.method)
+			l.Open(coinbase.CurrencyBtc, coinbase.AmountCoin, 1, testBtcUsd, t0)
+			for _, f := range tc.fills {
+				if err := l.AddFill(f, testBtcUsd); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s := l.Summarize(map[coinbase.Currency]float64{
+				coinbase.CurrencyEth: float64(tc.markMilli) / 1000,
+			}, testBtcUsd)
+
+			if !near(s.RealizedBtc, tc.realizedBtc) {
+				t.Errorf("realized %d, want %d", s.RealizedBtc, tc.realizedBtc)
+			}
+			if !near(s.UnrealizedBtc, tc.unrealizedBtc) {
+				t.Errorf("unrealized %d, want %d", s.UnrealizedBtc, tc.unrealizedBtc)
+			}
+			if s.FeesBtc != tc.feesBtc {
+				t.Errorf("fees %d, want %d", s.FeesBtc, tc.feesBtc)
+			}
+
+			// BTC/USD never moves, so USD gains are the BTC gains at that rate
+			if want := float64(tc.realizedBtc) / coinbase.AmountCoin * testBtcUsd; math.Abs(s.RealizedUsd-want) > 0.01 {
+				t.Errorf("realized USD %f, want %f", s.RealizedUsd, want)
+			}
+			if want := float64(tc.unrealizedBtc) / coinbase.AmountCoin * testBtcUsd; math.Abs(s.UnrealizedUsd-want) > 0.01 {
+				t.Errorf("unrealized USD %f, want %f", s.UnrealizedUsd, want)
+			}
+
+			if got := len(l.Lots(coinbase.CurrencyEth)); got != tc.ethLots {
+				t.Errorf("%d ETH lots left, want %d", got, tc.ethLots)
+			}
+
+			var acquired []time.Time
+			for _, d := range l.Disposals() {
+				if d.Currency == coinbase.CurrencyEth {
+					acquired = append(acquired, d.Acquired)
+				}
+			}
+			if len(acquired) != len(tc.acquired) {
+				t.Fatalf("%d ETH disposals, want %d", len(acquired), len(tc.acquired))
+			}
+			for i := range acquired {
+				if !acquired[i].Equal(tc.acquired[i]) {
+					t.Errorf("disposal %d acquired %s, want %s", i, acquired[i], tc.acquired[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLedgerSplitsDisposalAcrossLots(t *testing.T) {
+	l := NewLedger(MethodFIFO)
+	l.AddFill(ethFill(1, coinbase.SideBuy, 50, 0), testBtcUsd)
+	l.AddFill(ethFill(2, coinbase.SideBuy, 70, 0), testBtcUsd)
+
+	sell := ethFill(3, coinbase.SideSell, 80, milliBtc)
+	sell.Size = 3 * coinbase.AmountCoin / 2
+	if err := l.AddFill(sell, testBtcUsd); err != nil {
+		t.Fatal(err)
+	}
+
+	var eth []*Disposal
+	for _, d := range l.Disposals() {
+		if d.Currency == coinbase.CurrencyEth {
+			eth = append(eth, d)
+		}
+	}
+	if len(eth) != 2 {
+		t.Fatalf("%d ETH disposals, want 2", len(eth))
+	}
+
+	// Two thirds of the proceeds and fee go to the whole first lot, a third to half the second
+	if eth[0].Size != coinbase.AmountCoin || !near(eth[0].CostBtc, 50*milliBtc) || !near(eth[0].FeeBtc, 2*milliBtc/3) {
+		t.Errorf("first disposal: %+v", eth[0])
+	}
+	if eth[1].Size != coinbase.AmountCoin/2 || !near(eth[1].CostBtc, 35*milliBtc) || !near(eth[1].FeeBtc, milliBtc/3) {
+		t.Errorf("second disposal: %+v", eth[1])
+	}
+	if got := eth[0].ProceedsBtc + eth[1].ProceedsBtc; !near(got, 119*milliBtc) {
+		t.Errorf("proceeds %d, want %d", got, 119*milliBtc)
+	}
+}
+
+type fakeRates map[time.Time]float64
+
+func (r fakeRates) CurrentRate(from, to coinbase.Currency) (float64, bool) {
+	return 0.05, true
+}
+
+func (r fakeRates) RateAt(from, to coinbase.Currency, at time.Time) (float64, bool) {
+	rate, ok := r[at]
+	return rate, ok
+}
+
+func TestTrackerValuesFillsAtTradeTime(t *testing.T) {
+	tr := NewTracker(MethodFIFO, fakeRates{
+		t0.Add(time.Minute):     10000,
+		t0.Add(2 * time.Minute): 20000,
+	}, "")
+	tr.Open(nil)
+	tr.opened = t0
+
+	buy := ethFill(1, coinbase.SideBuy, 50, 0)
+	sell := ethFill(2, coinbase.SideSell, 60, 0)
+	tr.RecordFill(buy)
+	tr.RecordFill(sell)
+
+	// The same trade ID on another product is a different fill
+	ltc := ethFill(2, coinbase.SideBuy, 10, 0)
+	ltc.ProductID = coinbase.ProductLtcBtc
+	tr.RecordFill(ltc)
+	tr.RecordFill(sell)
+
+	if got := len(tr.TakeFills()); got != 3 {
+		t.Fatalf("%d fills applied, want 3", got)
+	}
+
+	var d *Disposal
+	for _, dis := range tr.ledger.Disposals() {
+		if dis.Currency == coinbase.CurrencyEth {
+			d = dis
+		}
+	}
+	if d == nil {
+		t.Fatal("no ETH disposal")
+	}
+	if math.Abs(d.CostUsd-500) > 0.01 || math.Abs(d.ProceedsUsd-1200) > 0.01 {
+		t.Errorf("cost $%f, proceeds $%f; want $500 and $1200", d.CostUsd, d.ProceedsUsd)
+	}
+}
+
+func TestTrackerStateSurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pnl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "pnl.json")
+	rates := fakeRates{t0.Add(time.Minute): 10000, t0.Add(2 * time.Minute): 10000, t0.Add(3 * time.Minute): 10000}
+
+	tr := NewTracker(MethodFIFO, rates, path)
+	tr.logger = log.New(ioutil.Discard, "", 0)
+	tr.Open(map[coinbase.Currency]int64{coinbase.CurrencyEth: coinbase.AmountCoin})
+	tr.mx.Lock()
+	tr.opened = t0
+	tr.save()
+	tr.mx.Unlock()
+	tr.RecordFill(ethFill(1, coinbase.SideBuy, 50, 0))
+	tr.RecordFill(ethFill(2, coinbase.SideSell, 60, 0))
+	want := tr.Summary()
+
+	restored := NewTracker(MethodFIFO, rates, path)
+	if !restored.Opened() || !restored.opened.Equal(t0) {
+		t.Fatalf("opened at %s after restart, want %s", restored.opened, t0)
+	}
+	if got := restored.Summary(); got.RealizedBtc != want.RealizedBtc || got.HoldValueBtc != want.HoldValueBtc ||
+		got.ValueBtc != want.ValueBtc {
+		t.Errorf("summary after restart: %+v, want %+v", got, want)
+	}
+	if got, want := len(restored.ledger.Lots(coinbase.CurrencyEth)), len(tr.ledger.Lots(coinbase.CurrencyEth)); got != want {
+		t.Errorf("%d ETH lots after restart, want %d", got, want)
+	}
+
+	// Fills already applied are not applied again, later ones are
+	restored.RecordFill(ethFill(2, coinbase.SideSell, 60, 0))
+	restored.RecordFill(ethFill(3, coinbase.SideSell, 70, 0))
+	if got := restored.TakeFills(); len(got) != 1 || got[0].TradeID != 3 {
+		t.Errorf("applied %d fills after restart, want only trade 3", len(got))
+	}
+
+	// Switching lot method starts over
+	if NewTracker(MethodLIFO, rates, path).Opened() {
+		t.Error("restored state saved under another lot method")
+	}
+}
@@ -0,0 +1,116 @@
+package pnl
+
+import (
+	"sort"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+// Position is the ledger's holding of one currency marked at current prices.
+type Position struct {
+	Currency      coinbase.Currency
+	Size          int64
+	CostBtc       int64
+	CostUsd       float64
+	ValueBtc      int64
+	ValueUsd      float64
+	UnrealizedBtc int64
+	UnrealizedUsd float64
+	RealizedBtc   int64
+	RealizedUsd   float64
+}
+
+// Summary is profit and loss across the ledger. Alpha is the current value less what the
+// opening holdings would be worth had they simply been held.
+type Summary struct {
+	Positions     []*Position
+	RealizedBtc   int64
+	RealizedUsd   float64
+	UnrealizedBtc int64
+	UnrealizedUsd float64
+	FeesBtc       int64
+	FeesUsd       float64
+	ValueBtc      int64
+	ValueUsd      float64
+	HoldValueBtc  int64
+	HoldValueUsd  float64
+	AlphaBtc      int64
+	AlphaUsd      float64
+}
+
+// Summarize marks the ledger to market. prices are in BTC per coin; BTC itself is always 1.
+// Currencies without a price are valued at cost.
+func (l *Ledger) Summarize(prices map[coinbase.Currency]float64, btcUsd float64) *Summary {
+	price := func(c coinbase.Currency) (float64, bool) {
+		if c == coinbase.CurrencyBtc {
+			return 1.0, true
+		}
+		p, ok := prices[c]
+		return p, ok
+	}
+	usd := func(ntvBtc int64) float64 {
+		return float64(ntvBtc) / coinbase.AmountCoin * btcUsd
+	}
+
+	positions := make(map[coinbase.Currency]*Position)
+	position := func(c coinbase.Currency) *Position {
+		if p, ok := positions[c]; ok {
+			return p
+		}
+		p := &Position{Currency: c}
+		positions[c] = p
+		return p
+	}
+
+	for c, lots := range l.lots {
+		pos := position(c)
+		for _, lot := range lots {
+			pos.Size += lot.Size
+			pos.CostBtc += lot.CostBtc
+			pos.CostUsd += lot.CostUsd
+		}
+
+		if p, ok := price(c); ok {
+			pos.ValueBtc = int64(float64(pos.Size) * p)
+		} else {
+			pos.ValueBtc = pos.CostBtc
+		}
+		pos.ValueUsd = usd(pos.ValueBtc)
+		pos.UnrealizedBtc = pos.ValueBtc - pos.CostBtc
+		pos.UnrealizedUsd = pos.ValueUsd - pos.CostUsd
+	}
+
+	for _, d := range l.disposals {
+		pos := position(d.Currency)
+		pos.RealizedBtc += d.GainBtc()
+		pos.RealizedUsd += d.GainUsd()
+	}
+
+	s := &Summary{
+		FeesBtc: l.feesBtc,
+		FeesUsd: l.feesUsd,
+	}
+	for _, pos := range positions {
+		s.Positions = append(s.Positions, pos)
+		s.RealizedBtc += pos.RealizedBtc
+		s.RealizedUsd += pos.RealizedUsd
+		s.UnrealizedBtc += pos.UnrealizedBtc
+		s.UnrealizedUsd += pos.UnrealizedUsd
+		s.ValueBtc += pos.ValueBtc
+		s.ValueUsd += pos.ValueUsd
+	}
+	sort.Slice(s.Positions, func(i, j int) bool {
+		return s.Positions[i].Currency < s.Positions[j].Currency
+	})
+
+	for c, size := range l.initial {
+		if p, ok := price(c); ok {
+			s.HoldValueBtc += int64(float64(size) * p)
+		}
+	}
+	s.HoldValueUsd = usd(s.HoldValueBtc)
+	s.AlphaBtc = s.ValueBtc - s.HoldValueBtc
+	s.AlphaUsd = s.ValueUsd - s.HoldValueUsd
+
+	return s
+}
@@ -0,0 +1,239 @@
+package pnl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+// RateSource provides current and recent prices. Satisfied by rates.RateSvc.
+type RateSource interface {
+	CurrentRate(from, to coinbase.Currency) (float64, bool)
+	RateAt(from, to coinbase.Currency, at time.Time) (float64, bool)
+}
+
+// Tracker maintains a Ledger from live fills. Fills are valued at the BTC/USD rate current
+// when they traded.
+type Tracker struct {
+	rates     RateSource
+	statePath string
+	logger    *log.Logger
+
+	mx     sync.Mutex
+	ledger *Ledger
+	opened time.Time
+	seen   map[fillKey]bool
+	recent []*coinbase.Fill
+}
+
+// Trade IDs are only unique within a product.
+type fillKey struct {
+	productID coinbase.ProductID
+	tradeID   int64
+}
+
+// NewTracker returns a tracker which saves its opening holdings, lots and the fills applied to
+// them at statePath so profit and loss carries across restarts. Delete the file to start over
+// from the balances at the next Open. Empty keeps state in memory only.
+func NewTracker(method Method, rates RateSource, statePath string) *Tracker {
+	t := &Tracker{
+		rates:     rates,
+		statePath: statePath,
+		logger:    log.New(os.Stdout, "[pnl] ", 0),
+		ledger:    NewLedger(method),
+		seen:      make(map[fillKey]bool),
+	}
+
+	if statePath != "" {
+		t.load()
+	}
+
+	return t
+}
+
+// Opened reports whether the opening balances have been recorded.
+func (t *Tracker) Opened() bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return !t.opened.IsZero()
+}
+
+// Open records the opening balances at current rates. Fills from before this are ignored as
+// they are already reflected in the balances.
+func (t *Tracker) Open(balances map[coinbase.Currency]int64) {
+	btcUsd, _ := t.rates.CurrentRate(coinbase.CurrencyBtc, coinbase.CurrencyUsd)
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.opened = time.Now()
+	for c, size := range balances {
+		price := 1.0
+		if c != coinbase.CurrencyBtc {
+			var ok bool
+			price, ok = t.rates.CurrentRate(c, coinbase.CurrencyBtc)
+			if !ok {
+				t.logger.Println("No rate to open position:", c)
+				continue
+			}
+		}
+		t.ledger.Open(c, size, price, btcUsd, t.opened)
+	}
+	t.save()
+}
+
+// RecordFill applies a fill not seen before.
+func (t *Tracker) RecordFill(f *coinbase.Fill) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	key := fillKey{f.ProductID, f.TradeID}
+	if t.opened.IsZero() || f.CreatedAt.Before(t.opened) || t.seen[key] {
+		return
+	}
+
+	btcUsd, ok := t.rates.RateAt(coinbase.CurrencyBtc, coinbase.CurrencyUsd, f.CreatedAt)
+	if !ok {
+		// Try again on the next poll
+		t.logger.Println("No BTC/USD rate for fill:", f.TradeID)
+		return
+	}
+
+	if err := t.ledger.AddFill(f, btcUsd); err != nil {
+		t.logger.Println("fill:", err)
+		return
+	}
+	t.seen[key] = true
+	t.recent = append(t.recent, f)
+	t.save()
+}
+
+// TakeFills returns the fills applied since the last call.
+func (t *Tracker) TakeFills() []*coinbase.Fill {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	fills := t.recent
+	t.recent = nil
+
+	return fills
+}
+
+// Summary marks the ledger to current rates.
+func (t *Tracker) Summary() *Summary {
+	prices := make(map[coinbase.Currency]float64)
+	for _, c := range []coinbase.Currency{coinbase.CurrencyEth, coinbase.CurrencyLtc} {
+		if rate, ok := t.rates.CurrentRate(c, coinbase.CurrencyBtc); ok {
+			prices[c] = rate
+		}
+	}
+	btcUsd, _ := t.rates.CurrentRate(coinbase.CurrencyBtc, coinbase.CurrencyUsd)
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.ledger.Summarize(prices, btcUsd)
+}
+
+// trackerState is everything needed to pick the ledger up again after a restart.
+type trackerState struct {
+	Method    Method                       `json:"method"`
+	Opened    time.Time                    `json:"opened"`
+	Initial   map[coinbase.Currency]int64  `json:"initial"`
+	Lots      map[coinbase.Currency][]*Lot `json:"lots"`
+	Disposals []*Disposal                  `json:"disposals"`
+	FeesBtc   int64                        `json:"feesBtc"`
+	FeesUsd   float64                      `json:"feesUsd"`
+	Seen      []seenFill                   `json:"seen"`
+}
+
+type seenFill struct {
+	ProductID coinbase.ProductID `json:"productId"`
+	TradeID   int64              `json:"tradeId"`
+}
+
+// load restores saved state. Nothing is restored from a file that can't be read or was saved
+// under a different lot method, so the next Open starts afresh.
+func (t *Tracker) load() {
+	content, err := ioutil.ReadFile(t.statePath)
+	if os.IsNotExist(err) {
+		return
+	}
+	var st trackerState
+	if err == nil {
+		err = json.Unmarshal(content, &st)
+	}
+	if err != nil {
+		t.logger.Println("load state:", err)
+		return
+	}
+	if st.Method != t.ledger.method {
+		t.logger.Printf("Saved state uses the %s lot method, not %s. Starting over.\n", st.Method, t.ledger.method)
+		return
+	}
+
+	t.opened = st.Opened
+	for c, size := range st.Initial {
+		t.ledger.initial[c] = size
+	}
+	for c, lots := range st.Lots {
+		t.ledger.lots[c] = lots
+	}
+	t.ledger.disposals = st.Disposals
+	t.ledger.feesBtc = st.FeesBtc
+	t.ledger.feesUsd = st.FeesUsd
+	for _, f := range st.Seen {
+		t.seen[fillKey{f.ProductID, f.TradeID}] = true
+	}
+	t.logger.Printf("Restored %d lots and %d fills opened at %s\n", t.numLots(), len(t.seen), t.opened)
+}
+
+func (t *Tracker) numLots() int {
+	n := 0
+	for _, lots := range t.ledger.lots {
+		n += len(lots)
+	}
+	return n
+}
+
+// save must be called with mx held.
+func (t *Tracker) save() {
+	if t.statePath == "" {
+		return
+	}
+
+	st := &trackerState{
+		Method:    t.ledger.method,
+		Opened:    t.opened,
+		Initial:   t.ledger.initial,
+		Lots:      t.ledger.lots,
+		Disposals: t.ledger.disposals,
+		FeesBtc:   t.ledger.feesBtc,
+		FeesUsd:   t.ledger.feesUsd,
+	}
+	for key := range t.seen {
+		st.Seen = append(st.Seen, seenFill{key.productID, key.tradeID})
+	}
+
+	content, err := json.Marshal(st)
+	if err != nil {
+		t.logger.Println("marshal state:", err)
+		return
+	}
+
+	// Write then rename so a crash never leaves a truncated state file
+	tmp := t.statePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		t.logger.Println("save state:", err)
+		return
+	}
+	if err := os.Rename(tmp, t.statePath); err != nil {
+		t.logger.Println("save state:", err)
+	}
+}
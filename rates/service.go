@@ -3,8 +3,13 @@ package rates
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"math"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/tobyjsullivan/btc-frogger/coinbase"
@@ -12,19 +17,60 @@ import (
 
 const (
 	loopDuration = 3 * time.Second
+	// VWAP is refreshed less often than the ticker to stay inside public rate limits.
+	vwapInterval = 30 * time.Second
+	vwapWindow   = 10 * time.Minute
+	// A rate not refreshed in this long is unhealthy.
+	staleAfter = 30 * time.Second
+	// A jump is accepted once this many consecutive polls agree on it.
+	jumpConfirmations = 3
+	// How long accepted rates are kept for RateAt.
+	historyWindow = 1 * time.Hour
 )
 
+type Config struct {
+	// Largest tolerated distance of the ticker price from book mid or recent VWAP, eg 0.02 for 2%.
+	// Zero disables.
+	MaxDeviation float64
+	// Largest tolerated move between polls before it must be confirmed. Zero disables.
+	MaxJump float64
+	// Largest distance between the readings confirming a jump, eg 0.01 for 1%. Zero uses half
+	// of MaxJump.
+	JumpTolerance float64
+}
+
 type RateSvc struct {
 	conn   *coinbase.Conn
-	rates  map[coinbase.ProductID]float64
+	cfg    *Config
 	logger *log.Logger
+
+	mx       sync.Mutex
+	rates    map[coinbase.ProductID]float64
+	updated  map[coinbase.ProductID]time.Time
+	problems map[coinbase.ProductID]string
+	jumps    map[coinbase.ProductID][]float64
+	vwaps    map[coinbase.ProductID]float64
+	vwapAt   time.Time
+	history  map[coinbase.ProductID][]rateSample
 }
 
-func NewService(ctx context.Context, conn *coinbase.Conn) *RateSvc {
+// rateSample is a rate as accepted at a point in time.
+type rateSample struct {
+	at   time.Time
+	rate float64
+}
+
+func NewService(ctx context.Context, conn *coinbase.Conn, cfg *Config) *RateSvc {
 	svc := &RateSvc{
-		conn:   conn,
-		rates:  make(map[coinbase.ProductID]float64),
-		logger: log.New(os.Stdout, "[rates] ", 0),
+		conn:     conn,
+		cfg:      cfg,
+		logger:   log.New(os.Stdout, "[rates] ", 0),
+		rates:    make(map[coinbase.ProductID]float64),
+		updated:  make(map[coinbase.ProductID]time.Time),
+		problems: make(map[coinbase.ProductID]string),
+		jumps:    make(map[coinbase.ProductID][]float64),
+		vwaps:    make(map[coinbase.ProductID]float64),
+		history:  make(map[coinbase.ProductID][]rateSample),
 	}
 
 	go svc.loop(ctx)
@@ -33,32 +79,14 @@ func NewService(ctx context.Context, conn *coinbase.Conn) *RateSvc {
 }
 
 func (svc *RateSvc) CurrentRate(from, to coinbase.Currency) (float64, bool) {
-	var prodId coinbase.ProductID
-	var invert bool
-
-	if from == coinbase.CurrencyBtc && to == coinbase.CurrencyEth {
-		prodId = coinbase.ProductEthBtc
-		invert = true
-	} else if from == coinbase.CurrencyEth && to == coinbase.CurrencyBtc {
-		prodId = coinbase.ProductEthBtc
-		invert = false
-	} else if from == coinbase.CurrencyBtc && to == coinbase.CurrencyLtc {
-		prodId = coinbase.ProductLtcBtc
-		invert = true
-	} else if from == coinbase.CurrencyLtc && to == coinbase.CurrencyBtc {
-		prodId = coinbase.ProductLtcBtc
-		invert = false
-	} else if from == coinbase.CurrencyBtc && to == coinbase.CurrencyUsd {
-		prodId = coinbase.ProductBtcUsd
-		invert = false
-	} else if from == coinbase.CurrencyUsd && to == coinbase.CurrencyBtc {
-		prodId = coinbase.ProductBtcUsd
-		invert = true
-	} else {
+	prodId, invert, ok := productFor(from, to)
+	if !ok {
 		return 0, false
 	}
 
+	svc.mx.Lock()
 	rate, ok := svc.rates[prodId]
+	svc.mx.Unlock()
 	if !ok {
 		return 0, false
 	}
@@ -70,6 +98,52 @@ func (svc *RateSvc) CurrentRate(from, to coinbase.Currency) (float64, bool) {
 	return rate, true
 }
 
+// RateAt returns the rate that was current at the given time, looking back at most an hour.
+func (svc *RateSvc) RateAt(from, to coinbase.Currency, at time.Time) (float64, bool) {
+	prodId, invert, ok := productFor(from, to)
+	if !ok {
+		return 0, false
+	}
+
+	svc.mx.Lock()
+	samples := svc.history[prodId]
+	i := sort.Search(len(samples), func(i int) bool {
+		return samples[i].at.After(at)
+	})
+	var rate float64
+	if i > 0 {
+		rate = samples[i-1].rate
+	}
+	svc.mx.Unlock()
+	if i == 0 {
+		return 0, false
+	}
+
+	if invert {
+		rate = 1.0 / rate
+	}
+
+	return rate, true
+}
+
+func productFor(from, to coinbase.Currency) (prodId coinbase.ProductID, invert bool, ok bool) {
+	if from == coinbase.CurrencyBtc && to == coinbase.CurrencyEth {
+		return coinbase.ProductEthBtc, true, true
+	} else if from == coinbase.CurrencyEth && to == coinbase.CurrencyBtc {
+		return coinbase.ProductEthBtc, false, true
+	} else if from == coinbase.CurrencyBtc && to == coinbase.CurrencyLtc {
+		return coinbase.ProductLtcBtc, true, true
+	} else if from == coinbase.CurrencyLtc && to == coinbase.CurrencyBtc {
+		return coinbase.ProductLtcBtc, false, true
+	} else if from == coinbase.CurrencyBtc && to == coinbase.CurrencyUsd {
+		return coinbase.ProductBtcUsd, false, true
+	} else if from == coinbase.CurrencyUsd && to == coinbase.CurrencyBtc {
+		return coinbase.ProductBtcUsd, true, true
+	}
+
+	return "", false, false
+}
+
 func (svc *RateSvc) Convert(from, to coinbase.Currency, amount int64) (int64, error) {
 	rate, ok := svc.CurrentRate(from, to)
 
@@ -93,11 +167,54 @@ func (svc *RateSvc) loop(ctx context.Context) {
 	}
 }
 
+// RateAge is how long ago the product's rate was last accepted.
+func (svc *RateSvc) RateAge(prodId coinbase.ProductID) (time.Duration, bool) {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	updated, ok := svc.updated[prodId]
+	if !ok {
+		return 0, false
+	}
+
+	return time.Since(updated), true
+}
+
+// Healthy reports whether every rate we trade on passed its latest sanity check and is fresh.
+// When not, the reason lists each failing product. BTC-USD only feeds reporting and the USD
+// drawdown, so it doesn't count.
+func (svc *RateSvc) Healthy() (bool, string) {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	var problems []string
+	for _, prodId := range tradedRates {
+		if problem, ok := svc.problems[prodId]; ok {
+			problems = append(problems, fmt.Sprintf("%s: %s", prodId, problem))
+		} else if age := time.Since(svc.updated[prodId]); age > staleAfter {
+			problems = append(problems, fmt.Sprintf("%s: stale for %s", prodId, age.Truncate(time.Second)))
+		}
+	}
+	sort.Strings(problems)
+
+	return len(problems) == 0, strings.Join(problems, "; ")
+}
+
+var ratesToGet = []coinbase.ProductID{
+	coinbase.ProductEthBtc,
+	coinbase.ProductLtcBtc,
+	coinbase.ProductBtcUsd,
+}
+
+// tradedRates are the rates rebalancing depends on.
+var tradedRates = []coinbase.ProductID{
+	coinbase.ProductEthBtc,
+	coinbase.ProductLtcBtc,
+}
+
 func (svc *RateSvc) updateRates() {
-	ratesToGet := []coinbase.ProductID{
-		coinbase.ProductEthBtc,
-		coinbase.ProductLtcBtc,
-		coinbase.ProductBtcUsd,
+	if time.Since(svc.vwapAt) > vwapInterval {
+		svc.updateVwaps()
 	}
 
 	for _, prodId := range ratesToGet {
@@ -107,6 +224,125 @@ func (svc *RateSvc) updateRates() {
 			continue
 		}
 
-		svc.rates[prodId] = ticker.Price
+		svc.accept(prodId, ticker)
+	}
+}
+
+// accept cross-checks the ticker's last trade price against the book mid, recent VWAP and the
+// previous rate before taking it. Rejected readings leave the previous rate in place.
+func (svc *RateSvc) accept(prodId coinbase.ProductID, ticker *coinbase.Ticker) {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	price := ticker.Price
+	if price <= 0 {
+		svc.reject(prodId, fmt.Sprintf("invalid price %f", price))
+		return
+	}
+
+	if svc.cfg.MaxDeviation > 0 {
+		if ticker.Bid > 0 && ticker.Ask > 0 {
+			mid := (ticker.Bid + ticker.Ask) / 2
+			if d := deviation(price, mid); d > svc.cfg.MaxDeviation {
+				svc.reject(prodId, fmt.Sprintf("price %f is %.2f%% from book mid %f", price, d*100, mid))
+				return
+			}
+		}
+
+		if vwap, ok := svc.vwaps[prodId]; ok && vwap > 0 {
+			if d := deviation(price, vwap); d > svc.cfg.MaxDeviation {
+				svc.reject(prodId, fmt.Sprintf("price %f is %.2f%% from VWAP %f", price, d*100, vwap))
+				return
+			}
+		}
+	}
+
+	if prev, ok := svc.rates[prodId]; ok && svc.cfg.MaxJump > 0 {
+		if d := deviation(price, prev); d > svc.cfg.MaxJump {
+			// Readings which disagree with the ones before start the confirmation over
+			pending := svc.jumps[prodId]
+			if !svc.jumpAgrees(pending, price) {
+				pending = nil
+			}
+			pending = append(pending, price)
+			svc.jumps[prodId] = pending
+
+			if len(pending) < jumpConfirmations {
+				svc.reject(prodId, fmt.Sprintf("price jumped %.2f%% from %f to %f", d*100, prev, price))
+				return
+			}
+			svc.logger.Printf("%s: accepting jump to %f after %d polls\n", prodId, price, len(pending))
+		}
 	}
+
+	now := time.Now()
+	svc.rates[prodId] = price
+	svc.updated[prodId] = now
+	svc.record(prodId, now, price)
+	delete(svc.problems, prodId)
+	delete(svc.jumps, prodId)
+}
+
+// jumpAgrees reports whether price is within the jump tolerance of every pending reading.
+func (svc *RateSvc) jumpAgrees(pending []float64, price float64) bool {
+	tolerance := svc.cfg.JumpTolerance
+	if tolerance <= 0 {
+		tolerance = svc.cfg.MaxJump / 2
+	}
+
+	for _, p := range pending {
+		if deviation(price, p) > tolerance {
+			return false
+		}
+	}
+
+	return true
+}
+
+// record adds an accepted rate to the history, dropping samples older than historyWindow.
+// Must be called with mx held.
+func (svc *RateSvc) record(prodId coinbase.ProductID, at time.Time, rate float64) {
+	samples := svc.history[prodId]
+	cutoff := at.Add(-historyWindow)
+	drop := 0
+	for drop < len(samples) && samples[drop].at.Before(cutoff) {
+		drop++
+	}
+	svc.history[prodId] = append(samples[drop:], rateSample{at: at, rate: rate})
+}
+
+// reject must be called with mx held.
+func (svc *RateSvc) reject(prodId coinbase.ProductID, reason string) {
+	svc.logger.Printf("%s: rejected reading: %s\n", prodId, reason)
+	svc.problems[prodId] = reason
+}
+
+func (svc *RateSvc) updateVwaps() {
+	cutoff := time.Now().Add(-vwapWindow)
+	vwaps := make(map[coinbase.ProductID]float64)
+	for _, prodId := range ratesToGet {
+		trades, err := svc.conn.RecentTrades(prodId)
+		if err != nil {
+			svc.logger.Println("trades:", err)
+			continue
+		}
+
+		recent := trades[:0]
+		for _, t := range trades {
+			if t.Time.After(cutoff) {
+				recent = append(recent, t)
+			}
+		}
+		vwaps[prodId] = coinbase.VWAP(recent)
+	}
+
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	svc.vwaps = vwaps
+	svc.vwapAt = time.Now()
+}
+
+func deviation(price, reference float64) float64 {
+	return math.Abs(price-reference) / reference
 }
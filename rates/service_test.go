@@ -0,0 +1,62 @@
+package rates
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+func newTestService(cfg *Config) *RateSvc {
+	return &RateSvc{
+		cfg:      cfg,
+		logger:   log.New(ioutil.Discard, "", 0),
+		rates:    make(map[coinbase.ProductID]float64),
+		updated:  make(map[coinbase.ProductID]time.Time),
+		problems: make(map[coinbase.ProductID]string),
+		jumps:    make(map[coinbase.ProductID][]float64),
+		vwaps:    make(map[coinbase.ProductID]float64),
+		history:  make(map[coinbase.ProductID][]rateSample),
+	}
+}
+
+func TestJumpNeedsAgreeingReadings(t *testing.T) {
+	pid := coinbase.ProductEthBtc
+	svc := newTestService(&Config{MaxJump: 0.05, JumpTolerance: 0.01})
+	svc.accept(pid, &coinbase.Ticker{Price: 0.05})
+
+	steps := []struct {
+		price float64
+		want  float64
+	}{
+		{0.060, 0.05},
+		{0.070, 0.05}, // disagrees with 0.060, so confirmation starts over
+		{0.060, 0.05},
+		{0.0602, 0.05},
+		{0.0601, 0.0601}, // third agreeing reading
+	}
+	for i, step := range steps {
+		svc.accept(pid, &coinbase.Ticker{Price: step.price})
+		if got, _ := svc.CurrentRate(coinbase.CurrencyEth, coinbase.CurrencyBtc); got != step.want {
+			t.Fatalf("step %d: rate %f, want %f", i, got, step.want)
+		}
+	}
+}
+
+func TestHealthyIgnoresBtcUsd(t *testing.T) {
+	svc := newTestService(&Config{})
+	svc.accept(coinbase.ProductEthBtc, &coinbase.Ticker{Price: 0.05})
+	svc.accept(coinbase.ProductLtcBtc, &coinbase.Ticker{Price: 0.01})
+	svc.accept(coinbase.ProductBtcUsd, &coinbase.Ticker{Price: -1})
+
+	if healthy, reason := svc.Healthy(); !healthy {
+		t.Fatalf("unhealthy: %s", reason)
+	}
+
+	svc.updated[coinbase.ProductLtcBtc] = time.Now().Add(-time.Minute)
+	if healthy, _ := svc.Healthy(); healthy {
+		t.Fatal("stale LTC-BTC should be unhealthy")
+	}
+}
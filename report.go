@@ -0,0 +1,207 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/drawdown"
+	"github.com/tobyjsullivan/btc-frogger/killswitch"
+	"github.com/tobyjsullivan/btc-frogger/pnl"
+	"github.com/tobyjsullivan/btc-frogger/reporting"
+	"github.com/tobyjsullivan/btc-frogger/spread"
+)
+
+// rateSource is the part of rates.RateSvc reports and distributions are built from.
+type rateSource interface {
+	CurrentRate(from, to coinbase.Currency) (float64, bool)
+	Convert(from, to coinbase.Currency, amount int64) (int64, error)
+	RateAge(prodId coinbase.ProductID) (time.Duration, bool)
+}
+
+// balanceSource is the part of balances.BalanceSvc reports and distributions are built from.
+type balanceSource interface {
+	GetNativeBalance(c coinbase.Currency) (int64, bool)
+	LastUpdated() time.Time
+}
+
+type openOrderSource interface {
+	ListOpenOrders() ([]*coinbase.Order, error)
+}
+
+type quoteSource interface {
+	CurrentQuote(pid coinbase.ProductID) (*spread.Quote, bool)
+}
+
+// reportSources is everything a report is built from.
+type reportSources struct {
+	conn       openOrderSource
+	rateSvc    rateSource
+	balanceSvc balanceSource
+	spreadSvc  quoteSource
+	killSwitch *killswitch.Switch
+	breaker    *drawdown.Breaker
+	pnlTracker *pnl.Tracker
+	dryRun     bool
+
+	// When open orders were last listed successfully
+	openOrdersAt time.Time
+}
+
+// weight is a currency's share of total assets, as held and as targeted.
+type weight struct {
+	currency       coinbase.Currency
+	actual, target int64
+}
+
+// weights splits the distribution into actual and target holdings per currency, valued in BTC.
+func (distro *distribution) weights() []weight {
+	targetEth := distro.curEthAssets + distro.diffEth
+	targetLtc := distro.curLtcAssets + distro.diffLtc
+
+	return []weight{
+		{coinbase.CurrencyBtc, distro.curBtcAssets, distro.totalAssets - targetEth - targetLtc},
+		{coinbase.CurrencyEth, distro.curEthAssets, targetEth},
+		{coinbase.CurrencyLtc, distro.curLtcAssets, targetLtc},
+	}
+}
+
+// applySafeAllocation retargets the distribution to the drawdown breaker's safe allocation.
+func applySafeAllocation(distro *distribution, safe drawdown.Allocation) {
+	distro.diffEth = int64(float64(distro.totalAssets)*safe.Eth) - distro.curEthAssets
+	distro.diffLtc = int64(float64(distro.totalAssets)*safe.Ltc) - distro.curLtcAssets
+}
+
+func buildReport(src *reportSources) (*reporting.Report, error) {
+	distro, err := computeDistribution(src.rateSvc, src.balanceSvc)
+	if err != nil {
+		return nil, err
+	}
+	if dd := src.breaker.Status(); dd.Tripped && src.breaker.Action() == drawdown.ActionSafe {
+		applySafeAllocation(distro, src.breaker.SafeAllocation())
+	}
+
+	ethRate, _ := src.rateSvc.CurrentRate(coinbase.CurrencyEth, coinbase.CurrencyBtc)
+	ltcRate, _ := src.rateSvc.CurrentRate(coinbase.CurrencyLtc, coinbase.CurrencyBtc)
+	usdRate, _ := src.rateSvc.CurrentRate(coinbase.CurrencyBtc, coinbase.CurrencyUsd)
+
+	totalAssets := coins(distro.totalAssets)
+	report := &reporting.Report{
+		TotalAssets:   totalAssets,
+		AssetValueUsd: math.Floor((totalAssets*usdRate)*100) / 100,
+		BtcBalance:    coins(distro.ntvBtcBalance),
+		EthBalance:    coins(distro.ntvEthBalance),
+		LtcBalance:    coins(distro.ntvLtcBalance),
+		EthRate:       ethRate,
+		LtcRate:       ltcRate,
+		UsdRate:       usdRate,
+
+		SchemaVersion: reporting.ReportSchemaVersion,
+		Time:          time.Now().UTC(),
+		State:         reporting.StateRunning,
+		DryRun:        src.dryRun,
+		Assets:        make(map[string]*reporting.AssetReport),
+		OpenOrders:    []*reporting.OrderReport{},
+		Fills:         []*reporting.FillReport{},
+		Staleness:     make(map[string]float64),
+	}
+
+	if halted, reason := src.killSwitch.Halted(); halted {
+		report.State = reporting.StatePaused
+		report.StateReason = reason
+		report.Paused = true
+	}
+
+	balances := map[coinbase.Currency]int64{
+		coinbase.CurrencyBtc: distro.ntvBtcBalance,
+		coinbase.CurrencyEth: distro.ntvEthBalance,
+		coinbase.CurrencyLtc: distro.ntvLtcBalance,
+	}
+	for _, w := range distro.weights() {
+		asset := &reporting.AssetReport{
+			Balance:  coins(balances[w.currency]),
+			ValueBtc: coins(w.actual),
+		}
+		if distro.totalAssets > 0 {
+			total := float64(distro.totalAssets)
+			asset.ActualWeight = float64(w.actual) / total
+			asset.TargetWeight = float64(w.target) / total
+			asset.Drift = asset.ActualWeight - asset.TargetWeight
+		}
+		report.Assets[string(w.currency)] = asset
+	}
+
+	// A report without open orders is still worth sending, flagged as such
+	if orders, err := src.conn.ListOpenOrders(); err != nil {
+		report.OpenOrdersError = err.Error()
+	} else {
+		src.openOrdersAt = time.Now()
+		for _, o := range orders {
+			report.OpenOrders = append(report.OpenOrders, &reporting.OrderReport{
+				ID:         o.ID.String(),
+				ProductID:  string(o.ProductID),
+				Side:       string(o.Side),
+				Price:      coins(o.Price),
+				Size:       coins(o.Size),
+				FilledSize: coins(o.FilledSize),
+				Status:     o.Status,
+			})
+		}
+	}
+	report.OpenOrderCount = len(report.OpenOrders)
+
+	var fees int64
+	for _, f := range src.pnlTracker.TakeFills() {
+		report.Fills = append(report.Fills, &reporting.FillReport{
+			TradeID:   f.TradeID,
+			OrderID:   f.OrderID.String(),
+			ProductID: string(f.ProductID),
+			Side:      string(f.Side),
+			Price:     coins(f.Price),
+			Size:      coins(f.Size),
+			Fee:       coins(f.Fee),
+			Liquidity: f.Liquidity,
+			Time:      f.CreatedAt,
+		})
+		fees += f.Fee
+	}
+	report.FillCount = len(report.Fills)
+	report.Fees = coins(fees)
+
+	if src.pnlTracker.Opened() {
+		pl := src.pnlTracker.Summary()
+		report.Pnl = &reporting.PnlReport{
+			RealizedBtc:   coins(pl.RealizedBtc),
+			RealizedUsd:   pl.RealizedUsd,
+			UnrealizedBtc: coins(pl.UnrealizedBtc),
+			UnrealizedUsd: pl.UnrealizedUsd,
+			FeesBtc:       coins(pl.FeesBtc),
+			FeesUsd:       pl.FeesUsd,
+			AlphaBtc:      coins(pl.AlphaBtc),
+			AlphaUsd:      pl.AlphaUsd,
+		}
+	}
+
+	if updated := src.balanceSvc.LastUpdated(); !updated.IsZero() {
+		report.Staleness["balances"] = time.Since(updated).Seconds()
+	}
+	if !src.openOrdersAt.IsZero() {
+		report.Staleness["openOrders"] = time.Since(src.openOrdersAt).Seconds()
+	}
+	for _, pid := range []coinbase.ProductID{coinbase.ProductEthBtc, coinbase.ProductLtcBtc, coinbase.ProductBtcUsd} {
+		if age, ok := src.rateSvc.RateAge(pid); ok {
+			report.Staleness["rate."+string(pid)] = age.Seconds()
+		}
+	}
+	for _, pid := range []coinbase.ProductID{coinbase.ProductEthBtc, coinbase.ProductLtcBtc} {
+		if q, ok := src.spreadSvc.CurrentQuote(pid); ok {
+			report.Staleness["quote."+string(pid)] = q.Age().Seconds()
+		}
+	}
+
+	return report, nil
+}
+
+func coins(amount int64) float64 {
+	return float64(amount) / coinbase.AmountCoin
+}
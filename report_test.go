@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/drawdown"
+	"github.com/tobyjsullivan/btc-frogger/killswitch"
+	"github.com/tobyjsullivan/btc-frogger/pnl"
+	"github.com/tobyjsullivan/btc-frogger/spread"
+)
+
+const coin = int64(coinbase.AmountCoin)
+
+// fakeMarket serves fixed rates, balances, quotes and open orders.
+type fakeMarket struct {
+	rates     map[coinbase.ProductID]float64
+	balances  map[coinbase.Currency]int64
+	orders    []*coinbase.Order
+	ordersErr error
+	now       time.Time
+}
+
+func (m *fakeMarket) CurrentRate(from, to coinbase.Currency) (float64, bool) {
+	for pid, rate := range m.rates {
+		if pid.BaseCurrency() == from && pid.QuoteCurrency() == to {
+			return rate, true
+		}
+		if pid.BaseCurrency() == to && pid.QuoteCurrency() == from {
+			return 1 / rate, true
+		}
+	}
+	return 0, false
+}
+
+func (m *fakeMarket) RateAt(from, to coinbase.Currency, at time.Time) (float64, bool) {
+	return m.CurrentRate(from, to)
+}
+
+func (m *fakeMarket) Convert(from, to coinbase.Currency, amount int64) (int64, error) {
+	rate, ok := m.CurrentRate(from, to)
+	if !ok {
+		return 0, errors.New("no rate")
+	}
+	return int64(float64(amount) * rate), nil
+}
+
+func (m *fakeMarket) RateAge(pid coinbase.ProductID) (time.Duration, bool) {
+	if _, ok := m.rates[pid]; !ok {
+		return 0, false
+	}
+	return 3 * time.Second, true
+}
+
+func (m *fakeMarket) GetNativeBalance(c coinbase.Currency) (int64, bool) {
+	bal, ok := m.balances[c]
+	return bal, ok
+}
+
+func (m *fakeMarket) LastUpdated() time.Time {
+	return m.now.Add(-5 * time.Second)
+}
+
+func (m *fakeMarket) ListOpenOrders() ([]*coinbase.Order, error) {
+	return m.orders, m.ordersErr
+}
+
+func (m *fakeMarket) CurrentQuote(pid coinbase.ProductID) (*spread.Quote, bool) {
+	if pid != coinbase.ProductEthBtc {
+		return nil, false
+	}
+	return &spread.Quote{Bid: 4999000, Ask: 5001000, Time: m.now.Add(-2 * time.Second)}, true
+}
+
+func testSources() (*reportSources, *fakeMarket) {
+	m := &fakeMarket{
+		rates: map[coinbase.ProductID]float64{
+			coinbase.ProductEthBtc: 0.05,
+			coinbase.ProductLtcBtc: 0.01,
+			coinbase.ProductBtcUsd: 10000,
+		},
+		balances: map[coinbase.Currency]int64{
+			coinbase.CurrencyBtc: coin,
+			coinbase.CurrencyEth: 10 * coin,
+			coinbase.CurrencyLtc: 50 * coin,
+		},
+		orders: []*coinbase.Order{{
+			ID:         uuid.FromStringOrNil("5a1b0e3c-8f2d-4e6a-9c7b-1d2e3f4a5b6c"),
+			ProductID:  coinbase.ProductEthBtc,
+			Side:       coinbase.SideBuy,
+			Price:      4999000,
+			Size:       2 * coin,
+			FilledSize: coin / 2,
+			Status:     "open",
+		}},
+		now: time.Now(),
+	}
+
+	tracker := pnl.NewTracker(pnl.MethodFIFO, m, "")
+	tracker.Open(m.balances)
+	// Fills from before the tracker opened are ignored, so date it ahead
+	tracker.RecordFill(&coinbase.Fill{
+		TradeID:   42,
+		OrderID:   m.orders[0].ID,
+		ProductID: coinbase.ProductEthBtc,
+		Side:      coinbase.SideBuy,
+		Price:     4999000,
+		Size:      coin / 2,
+		Fee:       12500,
+		Liquidity: "M",
+		CreatedAt: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+
+	return &reportSources{
+		conn:       m,
+		rateSvc:    m,
+		balanceSvc: m,
+		spreadSvc:  m,
+		killSwitch: killswitch.New(&killswitch.Config{}),
+		breaker:    drawdown.NewBreaker(&drawdown.Config{}),
+		pnlTracker: tracker,
+	}, m
+}
+
+// stableReport builds a report and fixes the parts that depend on the clock.
+func stableReport(t *testing.T, src *reportSources) string {
+	report, err := buildReport(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report.Time = time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	for k, v := range report.Staleness {
+		report.Staleness[k] = math.Round(v)
+	}
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestBuildReport(t *testing.T) {
+	src, _ := testSources()
+
+	// 1 BTC, 10 ETH at 0.05 and 50 LTC at 0.01 make 2 BTC, targeted by market cap
+	want := `{
+  "totalAssets": 2,
+  "assetValueUsd": 20000,
+  "btcBalance": 1,
+  "ethBalance": 10,
+  "ltcBalance": 50,
+  "ethRate": 0.05,
+  "ltcRate": 0.01,
+  "usdRate": 10000,
+  "schemaVersion": 2,
+  "time": "2018-01-02T03:04:05Z",
+  "state": "running",
+  "paused": false,
+  "dryRun": false,
+  "assets": {
+    "BTC": {
+      "balance": 1,
+      "valueBtc": 1,
+      "actualWeight": 0.5,
+      "targetWeight": 0.78241431,
+      "drift": -0.28241430999999995
+    },
+    "ETH": {
+      "balance": 10,
+      "valueBtc": 0.5,
+      "actualWeight": 0.25,
+      "targetWeight": 0.18628912,
+      "drift": 0.06371088
+    },
+    "LTC": {
+      "balance": 50,
+      "valueBtc": 0.5,
+      "actualWeight": 0.25,
+      "targetWeight": 0.03129657,
+      "drift": 0.21870343
+    }
+  },
+  "openOrderCount": 1,
+  "openOrders": [
+    {
+      "id": "5a1b0e3c-8f2d-4e6a-9c7b-1d2e3f4a5b6c",
+      "productId": "ETH-BTC",
+      "side": "buy",
+      "price": 0.04999,
+      "size": 2,
+      "filledSize": 0.5,
+      "status": "open"
+    }
+  ],
+  "fillCount": 1,
+  "fills": [
+    {
+      "tradeId": 42,
+      "orderId": "5a1b0e3c-8f2d-4e6a-9c7b-1d2e3f4a5b6c",
+      "productId": "ETH-BTC",
+      "side": "buy",
+      "price": 0.04999,
+      "size": 0.5,
+      "fee": 0.000125,
+      "liquidity": "M",
+      "time": "2100-01-01T00:00:00Z"
+    }
+  ],
+  "fees": 0.000125,
+  "pnl": {
+    "realizedBtc": 0,
+    "realizedUsd": 0,
+    "unrealizedBtc": -0.00012,
+    "unrealizedUsd": -1.199999999999818,
+    "feesBtc": 0.000125,
+    "feesUsd": 1.25,
+    "alphaBtc": -0.00012,
+    "alphaUsd": -1.2000000000007276
+  },
+  "staleness": {
+    "balances": 5,
+    "openOrders": 0,
+    "quote.ETH-BTC": 2,
+    "rate.BTC-USD": 3,
+    "rate.ETH-BTC": 3,
+    "rate.LTC-BTC": 3
+  }
+}`
+	if got := stableReport(t, src); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBuildReportPausedAndDegraded(t *testing.T) {
+	src, m := testSources()
+	m.ordersErr = errors.New("timeout")
+	src.killSwitch.Halt("maintenance")
+	src.breaker = drawdown.NewBreaker(&drawdown.Config{
+		MaxDrawdownBtc: 0.1,
+		Action:         drawdown.ActionSafe,
+		Safe:           drawdown.Allocation{Eth: 0.2, Ltc: 0.1},
+	})
+	src.breaker.Update(10*coin, 0)
+	src.breaker.Update(2*coin, 0)
+	src.pnlTracker.TakeFills()
+
+	report, err := buildReport(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.State != "paused" || report.StateReason != "maintenance" || !report.Paused {
+		t.Errorf("state %s (%s), paused %v", report.State, report.StateReason, report.Paused)
+	}
+	// The tripped breaker's safe allocation replaces the market cap targets
+	for c, want := range map[string]float64{"BTC": 0.7, "ETH": 0.2, "LTC": 0.1} {
+		if got := report.Assets[c].TargetWeight; math.Abs(got-want) > 1e-6 {
+			t.Errorf("%s target: got %f, want %f", c, got, want)
+		}
+	}
+	if report.OpenOrdersError != "timeout" || report.OpenOrderCount != 0 || report.OpenOrders == nil {
+		t.Errorf("open orders: %q, %d, %v", report.OpenOrdersError, report.OpenOrderCount, report.OpenOrders)
+	}
+	if _, ok := report.Staleness["openOrders"]; ok {
+		t.Error("open orders staleness without ever listing them")
+	}
+	if report.FillCount != 0 || report.Fills == nil || report.Fees != 0 {
+		t.Errorf("fills already taken: %d, %v, %f", report.FillCount, report.Fills, report.Fees)
+	}
+}
+
+func TestBuildReportWithoutBalances(t *testing.T) {
+	src, m := testSources()
+	delete(m.balances, coinbase.CurrencyLtc)
+
+	if _, err := buildReport(src); err == nil {
+		t.Error("expected an error without every balance")
+	}
+}
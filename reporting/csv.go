@@ -0,0 +1,181 @@
+package reporting
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CsvSink appends each report as a row to a CSV file under a header of its columns. When a
+// report has a column the header lacks, or a different schema version, the file is rotated
+// aside with a timestamp in its name and a new one started, so every row is written in full
+// under the header it belongs to. Columns missing from a report are left empty.
+type CsvSink struct {
+	path string
+
+	mx      sync.Mutex
+	columns []string
+	schema  int // of the rows under columns; -1 if there are none yet
+}
+
+func NewCsvSink(path string) (*CsvSink, error) {
+	if path == "" {
+		return nil, errors.New("csv sink needs a file path")
+	}
+
+	return &CsvSink{path: path}, nil
+}
+
+func (s *CsvSink) Name() string {
+	return "csv:" + s.path
+}
+
+func (s *CsvSink) Send(report *Report) error {
+	fields, err := report.Fields()
+	if err != nil {
+		return err
+	}
+	at := report.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.columns == nil {
+		// Carry on with a file written by an earlier run if it still fits
+		header, schema, err := readHeader(s.path)
+		switch {
+		case err == nil:
+			s.columns = header
+			s.schema = schema
+		case !os.IsNotExist(err):
+			// Not ours to append to
+			if err := s.rotate(at); err != nil {
+				return err
+			}
+		}
+	}
+	if s.columns != nil && !s.fits(report, fields) {
+		if err := s.rotate(at); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if s.columns == nil {
+		s.columns = []string{"time"}
+		for _, field := range fields {
+			s.columns = append(s.columns, field.Name)
+		}
+		s.schema = report.SchemaVersion
+		w.Write(s.columns)
+	}
+
+	values := make(map[string]float64, len(fields))
+	for _, field := range fields {
+		values[field.Name] = field.Value
+	}
+	row := []string{at.UTC().Format(time.RFC3339)}
+	for _, col := range s.columns[1:] {
+		value, ok := values[col]
+		if !ok {
+			row = append(row, "")
+			continue
+		}
+		row = append(row, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	w.Write(row)
+	w.Flush()
+
+	return w.Error()
+}
+
+// fits reports whether the report can be written under the current header. Must be called
+// with mx held.
+func (s *CsvSink) fits(report *Report, fields []Field) bool {
+	if s.schema >= 0 && s.schema != report.SchemaVersion {
+		return false
+	}
+
+	known := make(map[string]bool, len(s.columns))
+	for _, col := range s.columns {
+		known[col] = true
+	}
+	for _, field := range fields {
+		if !known[field.Name] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rotate moves the current file aside so the next report starts a new one. Must be called
+// with mx held.
+func (s *CsvSink) rotate(at time.Time) error {
+	ext := filepath.Ext(s.path)
+	base := strings.TrimSuffix(s.path, ext) + "." + at.UTC().Format("20060102T150405")
+	rotated := base + ext
+	// Don't clobber a file rotated earlier in the same second
+	for n := 1; ; n++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = base + "-" + strconv.Itoa(n) + ext
+	}
+	if err := os.Rename(s.path, rotated); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.columns = nil
+	s.schema = -1
+
+	return nil
+}
+
+// readHeader returns the file's header and the schema version of its first row, or -1 if it
+// has no rows. Files from before the schema was versioned have no schemaVersion column and
+// report zero.
+func readHeader(path string) ([]string, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(header) == 0 || header[0] != "time" {
+		return nil, 0, errors.New("unexpected csv header")
+	}
+
+	row, err := r.Read()
+	if err != nil {
+		return header, -1, nil
+	}
+	schema := 0
+	for i, col := range header {
+		if col == "schemaVersion" && i < len(row) {
+			schema, _ = strconv.Atoi(row[i])
+		}
+	}
+
+	return header, schema, nil
+}
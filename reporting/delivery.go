@@ -0,0 +1,187 @@
+package reporting
+
+import (
+	"log"
+	"time"
+)
+
+// DeliveryConfig controls how reports reach each sink. Each sink has its own queue and worker
+// so a slow or failing sink never holds up the others.
+type DeliveryConfig struct {
+	// Reports waiting per sink. Beyond this, reports are spooled or dropped.
+	QueueSize int
+	// Attempts per report, including the first.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Directory for reports which could not be delivered, replayed on start and once the sink
+	// recovers. Empty disables spooling.
+	SpoolDir string
+	// Upper bound on each sink's spool file. Zero means no limit.
+	MaxSpoolBytes int64
+}
+
+// DefaultDeliveryConfig queues a few minutes of reports and retries for about a minute.
+func DefaultDeliveryConfig() *DeliveryConfig {
+	return &DeliveryConfig{
+		QueueSize:      32,
+		MaxAttempts:    5,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		MaxSpoolBytes:  10 * 1024 * 1024,
+	}
+}
+
+type delivery struct {
+	sink   Sink
+	cfg    *DeliveryConfig
+	spool  *spool
+	logger *log.Logger
+
+	queue    chan *Report
+	stopping chan struct{}
+	done     chan struct{}
+}
+
+func newDelivery(sink Sink, cfg *DeliveryConfig, logger *log.Logger) *delivery {
+	d := &delivery{
+		sink:     sink,
+		cfg:      cfg,
+		logger:   logger,
+		queue:    make(chan *Report, cfg.QueueSize),
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if cfg.SpoolDir != "" {
+		d.spool = newSpool(cfg.SpoolDir, sink.Name(), cfg.MaxSpoolBytes)
+	}
+
+	go d.run()
+
+	return d
+}
+
+// enqueue never blocks. Must not be called after the queue is closed.
+func (d *delivery) enqueue(report *Report) {
+	select {
+	case d.queue <- report:
+		queueDepth.With(d.sink.Name()).Set(float64(len(d.queue)))
+	default:
+		d.overflow(report, "queue_full")
+	}
+}
+
+// overflow spools a report which can't be delivered now, or drops it.
+func (d *delivery) overflow(report *Report, reason string) {
+	if d.spool != nil {
+		err := d.spool.append(report)
+		if err == nil {
+			reportsSpooled.With(d.sink.Name()).Inc()
+			return
+		}
+		d.logger.Println(d.sink.Name()+": spool:", err)
+		if err == errSpoolFull {
+			reason = "spool_full"
+		}
+	}
+
+	reportsDropped.With(d.sink.Name(), reason).Inc()
+	d.logger.Printf("%s: dropped report (%s)\n", d.sink.Name(), reason)
+}
+
+func (d *delivery) run() {
+	defer close(d.done)
+
+	// Anything spooled before a restart goes first, if the sink is up
+	if d.spool != nil {
+		d.replay()
+	}
+
+	for report := range d.queue {
+		queueDepth.With(d.sink.Name()).Set(float64(len(d.queue)))
+
+		if d.isStopping() {
+			d.overflow(report, "shutdown")
+			continue
+		}
+
+		if !d.deliver(report) {
+			d.overflow(report, "send_failed")
+			continue
+		}
+
+		// The sink is up, so catch up on anything it missed
+		if d.spool != nil && len(d.queue) == 0 {
+			d.replay()
+		}
+	}
+}
+
+// deliver sends with exponential backoff, giving up early when stopping.
+func (d *delivery) deliver(report *Report) bool {
+	backoff := d.cfg.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := d.sink.Send(report)
+		if err == nil {
+			reportsSent.With(d.sink.Name()).Inc()
+			return true
+		}
+
+		d.logger.Printf("%s: attempt %d: %s\n", d.sink.Name(), attempt, err)
+		if attempt >= d.cfg.MaxAttempts {
+			return false
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-d.stopping:
+			return false
+		}
+
+		backoff *= 2
+		if backoff > d.cfg.MaxBackoff {
+			backoff = d.cfg.MaxBackoff
+		}
+	}
+}
+
+// replay sends spooled reports oldest first, stopping at the first failure.
+func (d *delivery) replay() {
+	reports, err := d.spool.read()
+	if err != nil {
+		d.logger.Println(d.sink.Name()+": read spool:", err)
+		return
+	}
+	if len(reports) == 0 {
+		return
+	}
+
+	sent := 0
+	for _, report := range reports {
+		if report == nil {
+			// Corrupt line, nothing to deliver
+			sent++
+			continue
+		}
+		if d.isStopping() || d.sink.Send(report) != nil {
+			break
+		}
+		reportsSent.With(d.sink.Name()).Inc()
+		sent++
+	}
+
+	if err := d.spool.discard(sent); err != nil {
+		d.logger.Println(d.sink.Name()+": trim spool:", err)
+	}
+	d.logger.Printf("%s: replayed %d of %d spooled reports\n", d.sink.Name(), sent, len(reports))
+}
+
+func (d *delivery) isStopping() bool {
+	select {
+	case <-d.stopping:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,402 @@
+package reporting
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testWait = 2 * time.Second
+
+// fakeSink fails the next failures sends, or every send while down. While hold is set, each
+// send waits for it to be closed.
+type fakeSink struct {
+	mx       sync.Mutex
+	down     bool
+	failures int
+	hold     chan struct{}
+	started  chan struct{}
+	sent     []*Report
+	attempts []time.Time
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{started: make(chan struct{}, 100)}
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) Send(report *Report) error {
+	s.mx.Lock()
+	s.attempts = append(s.attempts, time.Now())
+	hold := s.hold
+	s.mx.Unlock()
+
+	s.started <- struct{}{}
+	if hold != nil {
+		<-hold
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.down || s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	s.sent = append(s.sent, report)
+	return nil
+}
+
+func (s *fakeSink) setDown(down bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.down = down
+}
+
+// sentAt returns the times of the reports delivered so far, in order.
+func (s *fakeSink) sentAt() []time.Time {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	var out []time.Time
+	for _, r := range s.sent {
+		out = append(out, r.Time)
+	}
+	return out
+}
+
+// reportAt returns a report identified by its time, i minutes after a fixed start.
+func reportAt(i int) *Report {
+	return &Report{SchemaVersion: ReportSchemaVersion, Time: time.Date(2018, 1, 1, 0, i, 0, 0, time.UTC)}
+}
+
+func testDeliveryConfig(t *testing.T) *DeliveryConfig {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return &DeliveryConfig{
+		QueueSize:      8,
+		MaxAttempts:    1,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		SpoolDir:       dir,
+	}
+}
+
+func testDelivery(t *testing.T, sink Sink, cfg *DeliveryConfig) *delivery {
+	d := newDelivery(sink, cfg, log.New(ioutil.Discard, "", 0))
+	t.Cleanup(func() {
+		close(d.queue)
+		<-d.done
+	})
+	return d
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(testWait)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func expectSent(t *testing.T, sink *fakeSink, want ...int) {
+	eventually(t, "reports to be sent", func() bool { return len(sink.sentAt()) >= len(want) })
+
+	got := sink.sentAt()
+	if len(got) != len(want) {
+		t.Fatalf("sent %d reports, want %d", len(got), len(want))
+	}
+	for i, at := range got {
+		if !at.Equal(reportAt(want[i]).Time) {
+			t.Errorf("report %d: got %s, want %s", i, at, reportAt(want[i]).Time)
+		}
+	}
+}
+
+// reportLine is the report as spooled.
+func reportLine(report *Report) ([]byte, error) {
+	line, err := json.Marshal(report)
+	return append(line, '\n'), err
+}
+
+func spooled(t *testing.T, d *delivery) []*Report {
+	reports, err := d.spool.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reports
+}
+
+func TestDeliveryRetriesWithBackoff(t *testing.T) {
+	cfg := testDeliveryConfig(t)
+	cfg.MaxAttempts = 4
+	cfg.InitialBackoff = 20 * time.Millisecond
+	cfg.MaxBackoff = 30 * time.Millisecond
+	sink := newFakeSink()
+	sink.failures = 3
+	d := testDelivery(t, sink, cfg)
+
+	d.enqueue(reportAt(1))
+	expectSent(t, sink, 1)
+
+	sink.mx.Lock()
+	defer sink.mx.Unlock()
+	// Doubling from 20ms, capped at 30ms
+	for i, min := range []time.Duration{20, 30, 30} {
+		min *= time.Millisecond
+		if gap := sink.attempts[i+1].Sub(sink.attempts[i]); gap < min {
+			t.Errorf("attempt %d came %s after the last, want at least %s", i+2, gap, min)
+		}
+	}
+	if len(spooled(t, d)) != 0 {
+		t.Error("spooled a report that was delivered")
+	}
+}
+
+func TestDeliverySpoolsAndReplays(t *testing.T) {
+	cfg := testDeliveryConfig(t)
+	cfg.MaxAttempts = 2
+	sink := newFakeSink()
+	sink.setDown(true)
+	d := testDelivery(t, sink, cfg)
+
+	d.enqueue(reportAt(1))
+	d.enqueue(reportAt(2))
+	eventually(t, "reports to be spooled", func() bool { return len(spooled(t, d)) == 2 })
+
+	// The next report to get through brings the spooled ones after it
+	sink.setDown(false)
+	d.enqueue(reportAt(3))
+	expectSent(t, sink, 3, 1, 2)
+	eventually(t, "the spool to empty", func() bool { return len(spooled(t, d)) == 0 })
+	if _, err := os.Stat(d.spool.path); !os.IsNotExist(err) {
+		t.Errorf("empty spool file left behind: %v", err)
+	}
+}
+
+func TestDeliveryReplaysOnStart(t *testing.T) {
+	cfg := testDeliveryConfig(t)
+	sink := newFakeSink()
+
+	// Left over from before a restart
+	sp := newSpool(cfg.SpoolDir, sink.Name(), 0)
+	for _, i := range []int{1, 2} {
+		if err := sp.append(reportAt(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testDelivery(t, sink, cfg)
+	expectSent(t, sink, 1, 2)
+}
+
+func TestDeliveryReplayStopsAtFailure(t *testing.T) {
+	cfg := testDeliveryConfig(t)
+	sink := newFakeSink()
+	sink.setDown(true)
+	sp := newSpool(cfg.SpoolDir, sink.Name(), 0)
+	for _, i := range []int{1, 2} {
+		if err := sp.append(reportAt(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := testDelivery(t, sink, cfg)
+	<-sink.started
+	d.enqueue(reportAt(3))
+	eventually(t, "the new report to be spooled", func() bool { return len(spooled(t, d)) == 3 })
+
+	// Nothing is lost or reordered by the failed replay
+	got := spooled(t, d)
+	for i, want := range []int{1, 2, 3} {
+		if !got[i].Time.Equal(reportAt(want).Time) {
+			t.Errorf("spooled %d: got %s, want %s", i, got[i].Time, reportAt(want).Time)
+		}
+	}
+}
+
+func TestDeliveryQueueBounded(t *testing.T) {
+	cfg := testDeliveryConfig(t)
+	cfg.QueueSize = 1
+	sink := newFakeSink()
+	sink.hold = make(chan struct{})
+	d := testDelivery(t, sink, cfg)
+
+	// The first is taken by the worker, the second waits and the third has nowhere to go
+	d.enqueue(reportAt(1))
+	<-sink.started
+	d.enqueue(reportAt(2))
+	d.enqueue(reportAt(3))
+	if got := spooled(t, d); len(got) != 1 || !got[0].Time.Equal(reportAt(3).Time) {
+		t.Fatalf("spooled %d reports, want only the third", len(got))
+	}
+
+	close(sink.hold)
+	expectSent(t, sink, 1, 2, 3)
+}
+
+func TestDeliveryDropsWithoutSpool(t *testing.T) {
+	cfg := testDeliveryConfig(t)
+	cfg.QueueSize = 1
+	cfg.SpoolDir = ""
+	sink := newFakeSink()
+	sink.hold = make(chan struct{})
+	d := testDelivery(t, sink, cfg)
+
+	d.enqueue(reportAt(1))
+	<-sink.started
+	d.enqueue(reportAt(2))
+	d.enqueue(reportAt(3))
+
+	// The third was dropped, so there is nothing to replay
+	close(sink.hold)
+	expectSent(t, sink, 1, 2)
+	time.Sleep(20 * time.Millisecond)
+	expectSent(t, sink, 1, 2)
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	dir := testDeliveryConfig(t).SpoolDir
+	line, err := reportLine(reportAt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Room for two reports but not three
+	sp := newSpool(dir, "fake", int64(2*len(line)+len(line)/2))
+	for i := 1; i <= 2; i++ {
+		if err := sp.append(reportAt(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sp.append(reportAt(3)); err != errSpoolFull {
+		t.Fatalf("got %v, want errSpoolFull", err)
+	}
+	if reports, _ := sp.read(); len(reports) != 2 {
+		t.Errorf("spooled %d reports, want 2", len(reports))
+	}
+
+	// Space freed by delivery is used again
+	if err := sp.discard(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.append(reportAt(3)); err != nil {
+		t.Errorf("after discard: %v", err)
+	}
+}
+
+func TestSpoolSkipsCorruptLines(t *testing.T) {
+	dir := testDeliveryConfig(t).SpoolDir
+	sp := newSpool(dir, "fake", 0)
+	first, _ := reportLine(reportAt(1))
+	second, _ := reportLine(reportAt(2))
+	content := string(first) + "{\"time\":\n" + string(second)
+	if err := ioutil.WriteFile(sp.path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reports, err := sp.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 || reports[0] == nil || reports[1] != nil || reports[2] == nil {
+		t.Fatalf("read %v, want a nil in the middle", reports)
+	}
+
+	// Discarding through the corrupt line leaves only what follows it
+	if err := sp.discard(2); err != nil {
+		t.Fatal(err)
+	}
+	if reports, _ := sp.read(); len(reports) != 1 || !reports[0].Time.Equal(reportAt(2).Time) {
+		t.Errorf("after discard: %v", reports)
+	}
+
+	// Replay delivers around it
+	if err := ioutil.WriteFile(sp.path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sink := newFakeSink()
+	cfg := testDeliveryConfig(t)
+	cfg.SpoolDir = dir
+	d := testDelivery(t, sink, cfg)
+	expectSent(t, sink, 1, 2)
+	eventually(t, "the spool to empty", func() bool { return len(spooled(t, d)) == 0 })
+}
+
+func TestCloseFlushes(t *testing.T) {
+	cfg := testDeliveryConfig(t)
+	sink := newFakeSink()
+	sink.hold = make(chan struct{})
+	svc := NewService([]Sink{sink}, cfg)
+	svc.logger = log.New(ioutil.Discard, "", 0)
+
+	for i := 1; i <= 3; i++ {
+		svc.ReportMetrics(reportAt(i))
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(sink.hold)
+	}()
+	svc.Close(testWait)
+
+	expectSent(t, sink, 1, 2, 3)
+	if reports, _ := newSpool(cfg.SpoolDir, sink.Name(), 0).read(); len(reports) != 0 {
+		t.Errorf("spooled %d reports after a clean flush", len(reports))
+	}
+
+	// Reports after close go straight to the spool
+	svc.ReportMetrics(reportAt(4))
+	if reports, _ := newSpool(cfg.SpoolDir, sink.Name(), 0).read(); len(reports) != 1 {
+		t.Errorf("spooled %d reports after close, want 1", len(reports))
+	}
+}
+
+func TestCloseSpoolsOnTimeout(t *testing.T) {
+	cfg := testDeliveryConfig(t)
+	cfg.MaxAttempts = 10
+	cfg.InitialBackoff = time.Minute
+	cfg.MaxBackoff = time.Minute
+	sink := newFakeSink()
+	sink.setDown(true)
+	svc := NewService([]Sink{sink}, cfg)
+	svc.logger = log.New(ioutil.Discard, "", 0)
+
+	for i := 1; i <= 3; i++ {
+		svc.ReportMetrics(reportAt(i))
+	}
+	<-sink.started
+
+	start := time.Now()
+	svc.Close(50 * time.Millisecond)
+	if took := time.Since(start); took > testWait {
+		t.Errorf("close took %s", took)
+	}
+
+	reports, err := newSpool(cfg.SpoolDir, sink.Name(), 0).read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("spooled %d reports, want 3", len(reports))
+	}
+	for i, r := range reports {
+		if !r.Time.Equal(reportAt(i + 1).Time) {
+			t.Errorf("spooled %d: got %s", i, r.Time)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package reporting
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	dweetApiBaseUrl = "https://dweet.io:443/"
+)
+
+// DweetSink posts each report to a dweet.io thing.
+type DweetSink struct {
+	thingName string
+	baseUrl   string
+	client    *http.Client
+}
+
+func NewDweetSink(thingName string) (*DweetSink, error) {
+	if thingName == "" {
+		return nil, errors.New("dweet sink needs a thing name")
+	}
+
+	return &DweetSink{
+		thingName: thingName,
+		baseUrl:   dweetApiBaseUrl,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *DweetSink) Name() string {
+	return "dweet:" + s.thingName
+}
+
+func (s *DweetSink) Send(report *Report) error {
+	endpointUrl, err := dweetEndpointUrl(s.baseUrl, fmt.Sprintf("/dweet/quietly/for/%s", s.thingName))
+	if err != nil {
+		return err
+	}
+
+	return postJson(s.client, endpointUrl, report)
+}
+
+func dweetEndpointUrl(baseUrl, endpointPath string) (string, error) {
+	apiUrl, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", err
+	}
+
+	endpointUrl := apiUrl.ResolveReference(&url.URL{Path: endpointPath})
+
+	return endpointUrl.String(), nil
+}
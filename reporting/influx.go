@@ -0,0 +1,116 @@
+package reporting
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const influxMeasurement = "frogger"
+
+// InfluxSink writes reports as a single InfluxDB line protocol point, over HTTP or UDP.
+type InfluxSink struct {
+	target string
+	udp    bool
+	client *http.Client
+}
+
+// NewInfluxHttpSink posts to a full write URL, eg http://localhost:8086/write?db=frogger
+func NewInfluxHttpSink(writeUrl string) (*InfluxSink, error) {
+	if !strings.HasPrefix(writeUrl, "http://") && !strings.HasPrefix(writeUrl, "https://") {
+		return nil, errors.New("influx sink needs a write URL")
+	}
+
+	return &InfluxSink{
+		target: writeUrl,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// NewInfluxUdpSink sends to an InfluxDB UDP listener, eg localhost:8089
+func NewInfluxUdpSink(addr string) (*InfluxSink, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+
+	return &InfluxSink{target: addr, udp: true}, nil
+}
+
+func (s *InfluxSink) Name() string {
+	if s.udp {
+		return "influx-udp:" + s.target
+	}
+	return "influx:" + s.target
+}
+
+func (s *InfluxSink) Send(report *Report) error {
+	at := report.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	line, err := lineProtocol(report, at)
+	if err != nil {
+		return err
+	}
+
+	if s.udp {
+		conn, err := net.Dial("udp", s.target)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		_, err = conn.Write(line)
+		return err
+	}
+
+	resp, err := s.client.Post(s.target, "text/plain", bytes.NewReader(line))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("Unexpected status code: " + resp.Status)
+	}
+
+	return nil
+}
+
+// lineProtocol formats the report as one point with a field per value.
+func lineProtocol(report *Report, at time.Time) ([]byte, error) {
+	fields, err := report.Fields()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("report has no fields")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(influxMeasurement)
+	for i, f := range fields {
+		if i == 0 {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(escapeKey(f.Name))
+		buf.WriteByte('=')
+		buf.WriteString(strconv.FormatFloat(f.Value, 'f', -1, 64))
+	}
+	buf.WriteString(fmt.Sprintf(" %d\n", at.UnixNano()))
+
+	return buf.Bytes(), nil
+}
+
+var keyEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func escapeKey(key string) string {
+	return keyEscaper.Replace(key)
+}
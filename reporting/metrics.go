@@ -0,0 +1,16 @@
+package reporting
+
+import (
+	"github.com/tobyjsullivan/btc-frogger/metrics"
+)
+
+var (
+	reportsSent = metrics.NewCounterVec("frogger_reports_sent_total",
+		"Reports delivered to each sink.", "sink")
+	reportsSpooled = metrics.NewCounterVec("frogger_reports_spooled_total",
+		"Reports written to disk for later delivery.", "sink")
+	reportsDropped = metrics.NewCounterVec("frogger_reports_dropped_total",
+		"Reports lost, by reason (queue_full, send_failed, spool_full, shutdown, closed).", "sink", "reason")
+	queueDepth = metrics.NewGaugeVec("frogger_report_queue_depth",
+		"Reports waiting for each sink.", "sink")
+)
@@ -0,0 +1,104 @@
+package reporting
+
+import (
+	"time"
+)
+
+// ReportSchemaVersion is bumped whenever a field is renamed, removed or changes meaning.
+// Fields may be added without a bump, so consumers should ignore fields they don't know.
+const ReportSchemaVersion = 2
+
+type BotState string
+
+const (
+	StateRunning = BotState("running")
+	// Trading halted by the kill switch
+	StatePaused = BotState("paused")
+)
+
+// Report is a snapshot of the bot and its portfolio. The fields up to UsdRate are from the
+// first version of the schema and keep their meaning. Amounts are in coins and rates are the
+// quote per unit of base, eg BTC per ETH.
+type Report struct {
+	TotalAssets   float64 `json:"totalAssets"`
+	AssetValueUsd float64 `json:"assetValueUsd"`
+	BtcBalance    float64 `json:"btcBalance"`
+	EthBalance    float64 `json:"ethBalance"`
+	LtcBalance    float64 `json:"ltcBalance"`
+	EthRate       float64 `json:"ethRate"`
+	LtcRate       float64 `json:"ltcRate"`
+	UsdRate       float64 `json:"usdRate"`
+
+	SchemaVersion int       `json:"schemaVersion"`
+	Time          time.Time `json:"time"`
+	State         BotState  `json:"state"`
+	StateReason   string    `json:"stateReason,omitempty"`
+	// State as a number for sinks which only take numbers
+	Paused bool `json:"paused"`
+	DryRun bool `json:"dryRun"`
+
+	// Keyed by currency
+	Assets map[string]*AssetReport `json:"assets"`
+
+	OpenOrderCount int            `json:"openOrderCount"`
+	OpenOrders     []*OrderReport `json:"openOrders"`
+	// Set when open orders couldn't be listed, in which case OpenOrders is empty. See also
+	// the "openOrders" staleness.
+	OpenOrdersError string `json:"openOrdersError,omitempty"`
+
+	// Fills since the previous report
+	FillCount int           `json:"fillCount"`
+	Fills     []*FillReport `json:"fills"`
+	// Fees paid on Fills, in BTC
+	Fees float64 `json:"fees"`
+
+	Pnl *PnlReport `json:"pnl,omitempty"`
+
+	// Seconds since each input was last refreshed, keyed like "rate.ETH-BTC", "quote.ETH-BTC",
+	// "balances" or "openOrders".
+	Staleness map[string]float64 `json:"staleness"`
+}
+
+type AssetReport struct {
+	Balance  float64 `json:"balance"`
+	ValueBtc float64 `json:"valueBtc"`
+	// Shares of total assets, 0 to 1
+	ActualWeight float64 `json:"actualWeight"`
+	TargetWeight float64 `json:"targetWeight"`
+	// Actual less target weight
+	Drift float64 `json:"drift"`
+}
+
+type OrderReport struct {
+	ID         string  `json:"id"`
+	ProductID  string  `json:"productId"`
+	Side       string  `json:"side"`
+	Price      float64 `json:"price"`
+	Size       float64 `json:"size"`
+	FilledSize float64 `json:"filledSize"`
+	Status     string  `json:"status"`
+}
+
+type FillReport struct {
+	TradeID   int64     `json:"tradeId"`
+	OrderID   string    `json:"orderId"`
+	ProductID string    `json:"productId"`
+	Side      string    `json:"side"`
+	Price     float64   `json:"price"`
+	Size      float64   `json:"size"`
+	Fee       float64   `json:"fee"`
+	Liquidity string    `json:"liquidity"`
+	Time      time.Time `json:"time"`
+}
+
+// PnlReport is cumulative since the bot started. See pnl.Summary.
+type PnlReport struct {
+	RealizedBtc   float64 `json:"realizedBtc"`
+	RealizedUsd   float64 `json:"realizedUsd"`
+	UnrealizedBtc float64 `json:"unrealizedBtc"`
+	UnrealizedUsd float64 `json:"unrealizedUsd"`
+	FeesBtc       float64 `json:"feesBtc"`
+	FeesUsd       float64 `json:"feesUsd"`
+	AlphaBtc      float64 `json:"alphaBtc"`
+	AlphaUsd      float64 `json:"alphaUsd"`
+}
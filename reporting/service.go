@@ -1,80 +1,89 @@
 package reporting
 
 import (
-	"os"
 	"log"
-	"net/url"
-	"fmt"
-	"net/http"
-	"encoding/json"
-	"bytes"
-)
-
-const (
-	dweetApiBaseUrl = "https://dweet.io:443/"
+	"os"
+	"sync"
+	"time"
 )
 
-
+// ReportingSvc fans each report out to every sink. Delivery is asynchronous, with retries and
+// optional disk spooling; see DeliveryConfig.
 type ReportingSvc struct {
-	dweetThingName string
-	dryRun bool
-	logger *log.Logger
+	deliveries []*delivery
+	logger     *log.Logger
+
+	mx     sync.RWMutex
+	closed bool
 }
 
-func NewService(dweetThingName string, dryRun bool) *ReportingSvc {
+// NewService starts a delivery worker per sink. A nil cfg uses DefaultDeliveryConfig.
+func NewService(sinks []Sink, cfg *DeliveryConfig) *ReportingSvc {
+	if cfg == nil {
+		cfg = DefaultDeliveryConfig()
+	}
+
 	svc := &ReportingSvc{
-		dweetThingName: dweetThingName,
-		dryRun: dryRun,
 		logger: log.New(os.Stdout, "[reporting] ", 0),
 	}
 
-	return svc
-}
+	if cfg.SpoolDir != "" {
+		if err := os.MkdirAll(cfg.SpoolDir, 0700); err != nil {
+			svc.logger.Println("spool dir:", err)
+		}
+	}
+
+	for _, sink := range sinks {
+		svc.logger.Println("Reporting to", sink.Name())
+		svc.deliveries = append(svc.deliveries, newDelivery(sink, cfg, svc.logger))
+	}
 
-type Report struct {
-	TotalAssets float64 `json:"totalAssets"`
-	AssetValueUsd float64 `json:"assetValueUsd"`
-	BtcBalance float64 `json:"btcBalance"`
-	EthBalance float64 `json:"ethBalance"`
-	LtcBalance float64 `json:"ltcBalance"`
-	EthRate float64 `json:"ethRate"`
-	LtcRate float64 `json:"ltcRate"`
-	UsdRate float64 `json:"usdRate"`
+	return svc
 }
 
+// ReportMetrics queues the report for every sink. It never blocks.
 func (svc *ReportingSvc) ReportMetrics(report *Report) {
-	go svc.sendReport(report)
-}
+	svc.mx.RLock()
+	defer svc.mx.RUnlock()
 
-func (svc *ReportingSvc) sendReport(dweetBody *Report) {
-	if svc.dryRun {
-		svc.logger.Println("DRY_RUN: skipping metrics report")
-		return
+	for _, d := range svc.deliveries {
+		if svc.closed {
+			d.overflow(report, "closed")
+			continue
+		}
+		d.enqueue(report)
 	}
+}
 
-	var body bytes.Buffer
-	encoder := json.NewEncoder(&body)
-	if err := encoder.Encode(&dweetBody); err != nil {
-		svc.logger.Println("encode:", err)
+// Close stops accepting reports and waits up to timeout for queued ones to be delivered.
+// Anything still undelivered after that is spooled, if configured, or dropped.
+func (svc *ReportingSvc) Close(timeout time.Duration) {
+	svc.mx.Lock()
+	if svc.closed {
+		svc.mx.Unlock()
 		return
 	}
-
-	svc.logger.Printf("Metrics sent to %s", svc.dweetThingName)
-	endpointUrl := dweetEndpointUrl(fmt.Sprintf("/dweet/quietly/for/%s", svc.dweetThingName), svc.logger)
-
-	_, err := http.Post(endpointUrl, "application/json", &body)
-	if err != nil {
-		svc.logger.Println("post:", err)
-	}
-}
-
-func dweetEndpointUrl(endpointPath string, logger *log.Logger) string {
-	apiUrl, err := url.Parse(dweetApiBaseUrl)
-	if err != nil {
-		logger.Panicln("dweet url parse:", err)
+	svc.closed = true
+	for _, d := range svc.deliveries {
+		close(d.queue)
 	}
+	svc.mx.Unlock()
 
-	endpointUrl := apiUrl.ResolveReference(&url.URL{Path: endpointPath})
+	deadline := time.After(timeout)
+	for _, d := range svc.deliveries {
+		select {
+		case <-d.done:
+			continue
+		case <-deadline:
+		}
 
-	return endpointUrl.String()
+		svc.logger.Println("Flush timed out. Spooling remaining reports.")
+		for _, d := range svc.deliveries {
+			close(d.stopping)
+		}
+		for _, d := range svc.deliveries {
+			<-d.done
+		}
+		return
+	}
 }
@@ -0,0 +1,112 @@
+package reporting
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Sink is a destination for reports.
+type Sink interface {
+	Name() string
+	Send(report *Report) error
+}
+
+// Field is a single numeric value from a report.
+type Field struct {
+	Name  string
+	Value float64
+}
+
+// Fields flattens the report's numeric values, sorted by name. Nested values are named by
+// their path joined with dots and booleans become 1 or 0. Strings and lists are dropped; lists
+// have a count field alongside.
+func (r *Report) Fields() ([]Field, error) {
+	content, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]interface{}
+	if err := json.Unmarshal(content, &tree); err != nil {
+		return nil, err
+	}
+
+	var fields []Field
+	flatten("", tree, &fields)
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+
+	return fields, nil
+}
+
+func flatten(prefix string, v interface{}, fields *[]Field) {
+	switch v := v.(type) {
+	case float64:
+		*fields = append(*fields, Field{Name: prefix, Value: v})
+	case bool:
+		value := 0.0
+		if v {
+			value = 1.0
+		}
+		*fields = append(*fields, Field{Name: prefix, Value: value})
+	case map[string]interface{}:
+		for k, child := range v {
+			name := k
+			if prefix != "" {
+				name = prefix + "." + k
+			}
+			flatten(name, child, fields)
+		}
+	}
+}
+
+// ParseSinks builds sinks from a comma separated list of kind:target pairs, eg
+//
+//	stdout,csv:/data/report.csv,influx:http://localhost:8086/write?db=frogger,
+//	influx-udp:localhost:8089,statsd:localhost:8125,webhook:https://example.com/hook,dweet:my-thing
+func ParseSinks(spec string) ([]Sink, error) {
+	var sinks []Sink
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kind, target := entry, ""
+		if i := strings.Index(entry, ":"); i >= 0 {
+			kind, target = entry[:i], entry[i+1:]
+		}
+
+		var sink Sink
+		var err error
+		switch kind {
+		case "stdout":
+			sink = NewStdoutSink(os.Stdout)
+		case "csv":
+			sink, err = NewCsvSink(target)
+		case "influx":
+			sink, err = NewInfluxHttpSink(target)
+		case "influx-udp":
+			sink, err = NewInfluxUdpSink(target)
+		case "statsd":
+			sink, err = NewStatsdSink(target)
+		case "webhook":
+			sink, err = NewWebhookSink(target)
+		case "dweet":
+			sink, err = NewDweetSink(target)
+		default:
+			err = errors.New("Unknown sink: " + kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
@@ -0,0 +1,451 @@
+package reporting
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testReport() *Report {
+	return &Report{
+		TotalAssets:    1.5,
+		EthRate:        0.05,
+		SchemaVersion:  ReportSchemaVersion,
+		Time:           time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+		State:          StatePaused,
+		StateReason:    "manual",
+		Paused:         true,
+		Assets:         map[string]*AssetReport{"ETH": {Balance: 2, Drift: -0.1}},
+		OpenOrderCount: 1,
+		OpenOrders:     []*OrderReport{{ID: "a", Price: 0.05}},
+		Pnl:            &PnlReport{RealizedBtc: 0.25},
+		Staleness:      map[string]float64{"rate.ETH-BTC": 3},
+	}
+}
+
+func TestParseSinks(t *testing.T) {
+	sinks, err := ParseSinks(" stdout, csv:/tmp/r.csv,influx:http://localhost:8086/write?db=f," +
+		"influx-udp:localhost:8089,statsd:localhost:8125,webhook:https://example.com/hook,dweet:thing,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"stdout",
+		"csv:/tmp/r.csv",
+		"influx:http://localhost:8086/write?db=f",
+		"influx-udp:localhost:8089",
+		"statsd:localhost:8125",
+		"webhook:https://example.com/hook",
+		"dweet:thing",
+	}
+	if len(sinks) != len(want) {
+		t.Fatalf("got %d sinks, want %d", len(sinks), len(want))
+	}
+	for i, sink := range sinks {
+		if sink.Name() != want[i] {
+			t.Errorf("sink %d: got %s, want %s", i, sink.Name(), want[i])
+		}
+	}
+
+	for _, spec := range []string{
+		"bogus:x",
+		"csv",
+		"influx:localhost:8086",
+		"influx-udp:localhost",
+		"statsd:",
+		"webhook:ftp://example.com",
+		"dweet",
+		"stdout,csv:",
+	} {
+		if _, err := ParseSinks(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	fields, err := testReport().Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]float64)
+	for i, f := range fields {
+		if i > 0 && fields[i-1].Name >= f.Name {
+			t.Errorf("fields not sorted: %s before %s", fields[i-1].Name, f.Name)
+		}
+		values[f.Name] = f.Value
+	}
+
+	for name, want := range map[string]float64{
+		"totalAssets":            1.5,
+		"schemaVersion":          ReportSchemaVersion,
+		"paused":                 1,
+		"dryRun":                 0,
+		"assets.ETH.balance":     2,
+		"assets.ETH.drift":       -0.1,
+		"openOrderCount":         1,
+		"pnl.realizedBtc":        0.25,
+		"staleness.rate.ETH-BTC": 3,
+	} {
+		got, ok := values[name]
+		if !ok {
+			t.Errorf("missing field %s", name)
+		} else if got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+
+	for _, name := range []string{"time", "state", "stateReason", "openOrders", "fills"} {
+		if _, ok := values[name]; ok {
+			t.Errorf("unexpected field %s", name)
+		}
+	}
+}
+
+func TestStdoutSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewStdoutSink(&buf)
+	if err := sink.Send(testReport()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	var got Report
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.TotalAssets != 1.5 || got.State != StatePaused {
+		t.Errorf("round trip lost values: %+v", got)
+	}
+}
+
+// captureServer records the path and body of each request and answers with status.
+func captureServer(t *testing.T, status int) (*httptest.Server, chan *http.Request, chan []byte) {
+	reqs := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		reqs <- r
+		bodies <- body
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, reqs, bodies
+}
+
+func TestWebhookSink(t *testing.T) {
+	srv, reqs, bodies := captureServer(t, http.StatusOK)
+
+	sink, err := NewWebhookSink(srv.URL + "/hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-reqs
+	if req.Method != http.MethodPost || req.URL.Path != "/hook" || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected request: %s %s %s", req.Method, req.URL.Path, req.Header.Get("Content-Type"))
+	}
+	var got Report
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.TotalAssets != 1.5 {
+		t.Errorf("body: %+v", got)
+	}
+}
+
+func TestWebhookSinkStatus(t *testing.T) {
+	srv, _, _ := captureServer(t, http.StatusInternalServerError)
+
+	sink, _ := NewWebhookSink(srv.URL)
+	if err := sink.Send(testReport()); err == nil {
+		t.Error("expected an error for a 500 response")
+	}
+}
+
+func TestDweetSink(t *testing.T) {
+	srv, reqs, bodies := captureServer(t, http.StatusOK)
+
+	sink, err := NewDweetSink("my-thing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.baseUrl = srv.URL + "/"
+	if err := sink.Send(testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	if req := <-reqs; req.URL.Path != "/dweet/quietly/for/my-thing" {
+		t.Errorf("posted to %s", req.URL.Path)
+	}
+	if body := <-bodies; !bytes.Contains(body, []byte(`"totalAssets":1.5`)) {
+		t.Errorf("body: %s", body)
+	}
+}
+
+const wantLinePrefix = "frogger assetValueUsd=0,assets.ETH.actualWeight=0,assets.ETH.balance=2,assets.ETH.drift=-0.1,"
+
+func TestInfluxHttpSink(t *testing.T) {
+	srv, reqs, bodies := captureServer(t, http.StatusNoContent)
+
+	sink, err := NewInfluxHttpSink(srv.URL + "/write?db=frogger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	if req := <-reqs; req.URL.Path != "/write" || req.URL.Query().Get("db") != "frogger" {
+		t.Errorf("posted to %s", req.URL)
+	}
+	line := string(<-bodies)
+	if !strings.HasPrefix(line, wantLinePrefix) {
+		t.Errorf("line: %s", line)
+	}
+	if !strings.HasSuffix(line, " 1514862245000000000\n") {
+		t.Errorf("line not timestamped with the report time: %s", line)
+	}
+	if !strings.Contains(line, ",staleness.rate.ETH-BTC=3,") {
+		t.Errorf("line missing staleness: %s", line)
+	}
+}
+
+func TestEscapeKey(t *testing.T) {
+	if got, want := escapeKey("a b,c=d"), `a\ b\,c\=d`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// listenUdp collects every datagram received until no more arrive.
+func listenUdp(t *testing.T) (string, func() string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn.LocalAddr().String(), func() string {
+		var out bytes.Buffer
+		buf := make([]byte, 64*1024)
+		for {
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return out.String()
+			}
+			out.Write(buf[:n])
+		}
+	}
+}
+
+func TestInfluxUdpSink(t *testing.T) {
+	addr, received := listenUdp(t)
+
+	sink, err := NewInfluxUdpSink(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	if line := received(); !strings.HasPrefix(line, wantLinePrefix) {
+		t.Errorf("line: %s", line)
+	}
+}
+
+func TestStatsdSink(t *testing.T) {
+	addr, received := listenUdp(t)
+
+	sink, err := NewStatsdSink(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := received()
+	for _, want := range []string{
+		"frogger.totalAssets:1.5|g\n",
+		"frogger.assets.ETH.drift:-0.1|g\n",
+		"frogger.paused:1|g\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in %q", want, got)
+		}
+	}
+}
+
+func readCsv(t *testing.T, path string) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestCsvSink(t *testing.T) {
+	path := filepath.Join(csvDir(t), "report.csv")
+
+	sink, err := NewCsvSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later run appends under the same header
+	sink, _ = NewCsvSink(path)
+	if err := sink.Send(testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := readCsv(t, path)
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want a header and 2 rows", len(rows))
+	}
+	header := rows[0]
+	if header[0] != "time" || rows[1][0] != "2018-01-02T03:04:05Z" {
+		t.Errorf("unexpected first column: %s, %s", header[0], rows[1][0])
+	}
+	for i, col := range header {
+		if col == "totalAssets" && (rows[1][i] != "1.5" || rows[2][i] != "1.5") {
+			t.Errorf("totalAssets: %s, %s", rows[1][i], rows[2][i])
+		}
+	}
+}
+
+func csvDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "reporting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func column(t *testing.T, rows [][]string, name string) int {
+	for i, col := range rows[0] {
+		if col == name {
+			return i
+		}
+	}
+	t.Fatalf("no %s column in %v", name, rows[0])
+	return -1
+}
+
+func TestCsvSinkRotate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		existing string
+		reports  []func(*Report)
+	}{
+		"v1 file": {
+			existing: "time,totalAssets\n2017-01-01T00:00:00Z,1\n",
+			reports:  []func(*Report){func(*Report) {}},
+		},
+		"foreign file": {
+			existing: "something,else\n",
+			reports:  []func(*Report){func(*Report) {}},
+		},
+		"new column": {
+			reports: []func(*Report){
+				func(r *Report) { r.Pnl = nil },
+				func(*Report) {},
+			},
+		},
+		"schema change": {
+			reports: []func(*Report){
+				func(r *Report) { r.SchemaVersion = ReportSchemaVersion - 1 },
+				func(*Report) {},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := csvDir(t)
+			path := filepath.Join(dir, "report.csv")
+			if tc.existing != "" {
+				if err := ioutil.WriteFile(path, []byte(tc.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			sink, _ := NewCsvSink(path)
+			for _, edit := range tc.reports {
+				report := testReport()
+				edit(report)
+				if err := sink.Send(report); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			files, _ := filepath.Glob(filepath.Join(dir, "report.*.csv"))
+			if len(files) != 1 {
+				t.Fatalf("got rotated files %v, want one", files)
+			}
+			rows := readCsv(t, path)
+			if len(rows) != 2 {
+				t.Fatalf("got %d rows, want a header and 1 row", len(rows))
+			}
+			if got := rows[1][column(t, rows, "pnl.realizedBtc")]; got != "0.25" {
+				t.Errorf("pnl.realizedBtc: %s", got)
+			}
+			if got := rows[1][column(t, rows, "schemaVersion")]; got != strconv.Itoa(ReportSchemaVersion) {
+				t.Errorf("schemaVersion: %s", got)
+			}
+		})
+	}
+}
+
+func TestCsvSinkMissingColumns(t *testing.T) {
+	path := filepath.Join(csvDir(t), "report.csv")
+
+	sink, _ := NewCsvSink(path)
+	if err := sink.Send(testReport()); err != nil {
+		t.Fatal(err)
+	}
+	report := testReport()
+	report.Staleness = nil
+	if err := sink.Send(report); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := readCsv(t, path)
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want a header and 2 rows", len(rows))
+	}
+	col := column(t, rows, "staleness.rate.ETH-BTC")
+	if rows[1][col] != "3" || rows[2][col] != "" {
+		t.Errorf("staleness.rate.ETH-BTC: %q, %q", rows[1][col], rows[2][col])
+	}
+}
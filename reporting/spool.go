@@ -0,0 +1,124 @@
+package reporting
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+var errSpoolFull = errors.New("spool full")
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// spool is an append-only file of undelivered reports, one JSON object per line.
+type spool struct {
+	path     string
+	maxBytes int64
+
+	mx sync.Mutex
+}
+
+func newSpool(dir string, sinkName string, maxBytes int64) *spool {
+	return &spool{
+		path:     filepath.Join(dir, unsafeFileChars.ReplaceAllString(sinkName, "_")+".jsonl"),
+		maxBytes: maxBytes,
+	}
+}
+
+func (s *spool) append(report *Report) error {
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.maxBytes > 0 {
+		if info, err := os.Stat(s.path); err == nil && info.Size()+int64(len(line)) > s.maxBytes {
+			return errSpoolFull
+		}
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(line); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// read returns every spooled report, oldest first, one per line. Unreadable lines are nil so
+// the result still lines up with the file for discard.
+func (s *spool) read() ([]*Report, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []*Report
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		var report *Report
+		if err := json.Unmarshal(scanner.Bytes(), &report); err != nil {
+			report = nil
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, scanner.Err()
+}
+
+// discard removes the n oldest lines. Reports appended since they were read are kept.
+func (s *spool) discard(n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n && len(content) > 0; i++ {
+		end := bytes.IndexByte(content, '\n')
+		if end < 0 {
+			content = nil
+			break
+		}
+		content = content[end+1:]
+	}
+
+	if len(content) == 0 {
+		return os.Remove(s.path)
+	}
+
+	// Write then rename so a crash never loses the spool
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
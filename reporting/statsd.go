@@ -0,0 +1,60 @@
+package reporting
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+)
+
+const (
+	statsdPrefix = "frogger."
+	// Keep datagrams under a typical MTU
+	maxStatsdPacket = 1400
+)
+
+// StatsdSink sends every report value as a StatsD gauge over UDP.
+type StatsdSink struct {
+	addr string
+}
+
+func NewStatsdSink(addr string) (*StatsdSink, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+
+	return &StatsdSink{addr: addr}, nil
+}
+
+func (s *StatsdSink) Name() string {
+	return "statsd:" + s.addr
+}
+
+func (s *StatsdSink) Send(report *Report) error {
+	fields, err := report.Fields()
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var packet bytes.Buffer
+	for _, f := range fields {
+		line := statsdPrefix + f.Name + ":" + strconv.FormatFloat(f.Value, 'f', -1, 64) + "|g\n"
+		if packet.Len()+len(line) > maxStatsdPacket {
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		_, err = conn.Write(packet.Bytes())
+	}
+
+	return err
+}
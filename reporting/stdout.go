@@ -0,0 +1,35 @@
+package reporting
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// StdoutSink writes each report as a line of JSON.
+type StdoutSink struct {
+	mx sync.Mutex
+	w  io.Writer
+}
+
+// NewStdoutSink writes to w, which is os.Stdout outside of tests.
+func NewStdoutSink(w io.Writer) *StdoutSink {
+	return &StdoutSink{w: w}
+}
+
+func (s *StdoutSink) Name() string {
+	return "stdout"
+}
+
+func (s *StdoutSink) Send(report *Report) error {
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,54 @@
+package reporting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WebhookSink POSTs each report as JSON to a URL.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string) (*WebhookSink, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, errors.New("webhook sink needs a URL")
+	}
+
+	return &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook:" + s.url
+}
+
+func (s *WebhookSink) Send(report *Report) error {
+	return postJson(s.client, s.url, report)
+}
+
+func postJson(client *http.Client, url string, report *Report) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(report); err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("Unexpected status code: " + resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package risk
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+// Limits are the hard pre-trade limits. Notional amounts are in native units of the quote
+// currency (BTC for every product we trade). A zero limit is not enforced.
+type Limits struct {
+	MaxOrderNotional  int64
+	MaxHourlyTurnover int64
+	MaxDailyTurnover  int64
+	// Largest allowed distance of a limit price from the reference rate, eg 0.02 for 2%.
+	MaxPriceDeviation float64
+	// Largest share of total portfolio value traded in a single rebalance cycle, eg 0.25.
+	MaxCycleShare float64
+}
+
+// RateSource provides reference prices. Satisfied by rates.RateSvc.
+type RateSource interface {
+	CurrentRate(from, to coinbase.Currency) (float64, bool)
+}
+
+// Rejection is returned for any order which breaches a limit.
+type Rejection struct {
+	Reason string
+}
+
+func (r *Rejection) Error() string {
+	return "risk rejected: " + r.Reason
+}
+
+type trade struct {
+	at        time.Time
+	notional  int64
+	size      int64 // what remains charged of the order's size
+	clientOID uuid.UUID
+	cycle     int
+}
+
+// Engine checks every order against Limits before it is sent. It fails closed: without a
+// reference rate or a portfolio value for the cycle, orders are rejected.
+type Engine struct {
+	limits *Limits
+	rates  RateSource
+	logger *log.Logger
+
+	mx            sync.Mutex
+	portfolio     int64
+	cycle         int
+	cycleNotional int64
+	trades        []trade
+}
+
+func NewEngine(limits *Limits, rates RateSource) *Engine {
+	return &Engine{
+		limits: limits,
+		rates:  rates,
+		logger: log.New(os.Stdout, "[risk] ", 0),
+	}
+}
+
+// StartCycle begins a new rebalance cycle with the portfolio's total value in native BTC.
+func (e *Engine) StartCycle(portfolioValue int64) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	e.portfolio = portfolioValue
+	e.cycle++
+	e.cycleNotional = 0
+}
+
+// Check returns a *Rejection if the order breaches any limit. It does not count the order
+// towards turnover; call Record once the order has been sent.
+func (e *Engine) Check(req *coinbase.OrderRequest) error {
+	err := e.check(req)
+	if err != nil {
+		e.logger.Printf("Rejected %s %s %s: %s\n", req.Side, coinbase.FormatAmount(req.Size), req.ProductID, err)
+	}
+
+	return err
+}
+
+func (e *Engine) check(req *coinbase.OrderRequest) error {
+	ref, err := e.referencePrice(req.ProductID)
+	if err != nil {
+		return err
+	}
+
+	if req.Price > 0 && e.limits.MaxPriceDeviation > 0 {
+		deviation := float64(req.Price-ref) / float64(ref)
+		if deviation < 0 {
+			deviation = -deviation
+		}
+		if deviation > e.limits.MaxPriceDeviation {
+			return reject("limit price %s deviates %.2f%% from reference %s", coinbase.FormatAmount(req.Price),
+				deviation*100, coinbase.FormatAmount(ref))
+		}
+	}
+
+	notional := orderNotional(req, ref)
+	if notional <= 0 {
+		return reject("could not determine notional")
+	}
+
+	if e.limits.MaxOrderNotional > 0 && notional > e.limits.MaxOrderNotional {
+		return reject("notional %s exceeds max order notional %s", coinbase.FormatAmount(notional),
+			coinbase.FormatAmount(e.limits.MaxOrderNotional))
+	}
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	if e.limits.MaxCycleShare > 0 {
+		if e.portfolio <= 0 {
+			return reject("portfolio value unknown for this cycle")
+		}
+
+		max := int64(float64(e.portfolio) * e.limits.MaxCycleShare)
+		if e.cycleNotional+notional > max {
+			return reject("cycle turnover %s would exceed %.0f%% of portfolio (%s)",
+				coinbase.FormatAmount(e.cycleNotional+notional), e.limits.MaxCycleShare*100, coinbase.FormatAmount(max))
+		}
+	}
+
+	e.prune()
+	now := time.Now()
+	var hourly, daily int64
+	for _, t := range e.trades {
+		daily += t.notional
+		if now.Sub(t.at) < time.Hour {
+			hourly += t.notional
+		}
+	}
+
+	if e.limits.MaxHourlyTurnover > 0 && hourly+notional > e.limits.MaxHourlyTurnover {
+		return reject("hourly turnover %s would exceed %s", coinbase.FormatAmount(hourly+notional),
+			coinbase.FormatAmount(e.limits.MaxHourlyTurnover))
+	}
+	if e.limits.MaxDailyTurnover > 0 && daily+notional > e.limits.MaxDailyTurnover {
+		return reject("daily turnover %s would exceed %s", coinbase.FormatAmount(daily+notional),
+			coinbase.FormatAmount(e.limits.MaxDailyTurnover))
+	}
+
+	return nil
+}
+
+// Record counts a sent order towards the cycle and turnover limits. The whole order is
+// charged up front; call Release with whatever is later cancelled unfilled.
+func (e *Engine) Record(req *coinbase.OrderRequest) {
+	ref, err := e.referencePrice(req.ProductID)
+	if err != nil {
+		return
+	}
+
+	notional := orderNotional(req, ref)
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	e.cycleNotional += notional
+	e.trades = append(e.trades, trade{
+		at:        time.Now(),
+		notional:  notional,
+		size:      req.Size,
+		clientOID: req.ClientOID,
+		cycle:     e.cycle,
+	})
+}
+
+// Release credits back the unfilled part of a recorded order once it has been cancelled, so
+// that only what actually traded counts towards turnover. Orders not recorded are ignored.
+func (e *Engine) Release(clientOID uuid.UUID, unfilled int64) {
+	if clientOID == uuid.Nil || unfilled <= 0 {
+		return
+	}
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	for i := range e.trades {
+		t := &e.trades[i]
+		if t.clientOID != clientOID || t.size <= 0 {
+			continue
+		}
+
+		if unfilled > t.size {
+			unfilled = t.size
+		}
+		credit := int64(float64(t.notional) * float64(unfilled) / float64(t.size))
+		t.notional -= credit
+		t.size -= unfilled
+		if t.cycle == e.cycle {
+			e.cycleNotional -= credit
+		}
+		return
+	}
+}
+
+// prune drops trades older than a day. Must be called with mx held.
+func (e *Engine) prune() {
+	cutoff := time.Now().Add(-24 * time.Hour)
+	recent := e.trades[:0]
+	for _, t := range e.trades {
+		if t.at.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	e.trades = recent
+}
+
+func (e *Engine) referencePrice(pid coinbase.ProductID) (int64, error) {
+	rate, ok := e.rates.CurrentRate(pid.BaseCurrency(), pid.QuoteCurrency())
+	if !ok {
+		return 0, reject("no reference rate for %s", pid)
+	}
+
+	ref := int64(rate * coinbase.AmountCoin)
+	if ref <= 0 {
+		return 0, reject("invalid reference rate for %s: %f", pid, rate)
+	}
+
+	return ref, nil
+}
+
+// orderNotional is the order's value in the quote currency.
+func orderNotional(req *coinbase.OrderRequest, ref int64) int64 {
+	if req.Funds > 0 {
+		return req.Funds
+	}
+
+	price := req.Price
+	if price <= 0 {
+		price = ref
+	}
+
+	return int64(float64(req.Size) * float64(price) / coinbase.AmountCoin)
+}
+
+func reject(format string, args ...interface{}) error {
+	return &Rejection{Reason: fmt.Sprintf(format, args...)}
+}
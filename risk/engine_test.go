@@ -0,0 +1,181 @@
+package risk
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+type fixedRates float64
+
+func (r fixedRates) CurrentRate(from, to coinbase.Currency) (float64, bool) {
+	return float64(r), true
+}
+
+func TestReleaseCreditsUnfilledTurnover(t *testing.T) {
+	e := NewEngine(&Limits{
+		MaxHourlyTurnover: coinbase.AmountCoin / 10,
+		MaxCycleShare:     0.5,
+	}, fixedRates(0.05))
+	e.StartCycle(coinbase.AmountCoin)
+
+	// 1.5 ETH at 0.05 is 0.075 BTC, leaving room for less than one more such order
+	order := func() *coinbase.OrderRequest {
+		return &coinbase.OrderRequest{
+			ProductID: coinbase.ProductEthBtc,
+			Side:      coinbase.SideBuy,
+			Price:     5000000,
+			Size:      150000000,
+			ClientOID: uuid.NewV4(),
+		}
+	}
+
+	first := order()
+	if err := e.Check(first); err != nil {
+		t.Fatal(err)
+	}
+	e.Record(first)
+
+	// A reprice of the same order must not be charged twice once the original is cancelled
+	second := order()
+	if err := e.Check(second); err == nil {
+		t.Fatal("expected the second order to breach the hourly limit")
+	}
+	e.Release(first.ClientOID, first.Size)
+	if err := e.Check(second); err != nil {
+		t.Fatalf("unfilled order still charged: %v", err)
+	}
+	e.Record(second)
+
+	// Only the filled third of it stays charged
+	e.Release(second.ClientOID, second.Size*2/3)
+	e.Release(first.ClientOID, first.Size) // nothing is left to credit
+	e.mx.Lock()
+	hourly := e.trades[0].notional + e.trades[1].notional
+	cycle := e.cycleNotional
+	e.mx.Unlock()
+	if want := int64(2500000); hourly != want || cycle != want {
+		t.Errorf("charged hourly %d, cycle %d; want %d", hourly, cycle, want)
+	}
+}
+
+type noRates struct{}
+
+func (noRates) CurrentRate(from, to coinbase.Currency) (float64, bool) {
+	return 0, false
+}
+
+// ethBuy is a limit buy of size ETH at price, both in native units.
+func ethBuy(size, price int64) *coinbase.OrderRequest {
+	return &coinbase.OrderRequest{
+		ProductID: coinbase.ProductEthBtc,
+		Side:      coinbase.SideBuy,
+		Price:     price,
+		Size:      size,
+		ClientOID: uuid.NewV4(),
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	const coin = coinbase.AmountCoin
+	// At the reference rate of 0.05 one ETH is worth 0.05 BTC
+	ref := int64(coin / 20)
+	tests := []struct {
+		name      string
+		limits    Limits
+		rates     RateSource
+		portfolio int64
+		// Recorded before the check, each the given time ago
+		prior    []*coinbase.OrderRequest
+		priorAge time.Duration
+		req      *coinbase.OrderRequest
+		wantErr  bool
+	}{
+		{name: "no limits", req: ethBuy(100*coin, ref)},
+		{name: "order notional at limit", limits: Limits{MaxOrderNotional: coin / 20}, req: ethBuy(coin, ref)},
+		{name: "order notional over limit", limits: Limits{MaxOrderNotional: coin / 20}, req: ethBuy(coin+coin/100, ref), wantErr: true},
+		// Market buys by funds are valued at the funds
+		{name: "funds over limit", limits: Limits{MaxOrderNotional: coin / 20}, req: &coinbase.OrderRequest{ProductID: coinbase.ProductEthBtc, Funds: coin / 10}, wantErr: true},
+		// Orders without a price are valued at the reference rate
+		{name: "market size over limit", limits: Limits{MaxOrderNotional: coin / 20}, req: ethBuy(2*coin, 0), wantErr: true},
+		{name: "price within deviation", limits: Limits{MaxPriceDeviation: 0.02}, req: ethBuy(coin, ref+ref/100)},
+		{name: "price above deviation", limits: Limits{MaxPriceDeviation: 0.02}, req: ethBuy(coin, ref+ref/20), wantErr: true},
+		{name: "price below deviation", limits: Limits{MaxPriceDeviation: 0.02}, req: ethBuy(coin, ref-ref/20), wantErr: true},
+		{name: "cycle share within", limits: Limits{MaxCycleShare: 0.25}, portfolio: coin, prior: []*coinbase.OrderRequest{ethBuy(2*coin, ref)}, req: ethBuy(3*coin, ref)},
+		{name: "cycle share exceeded", limits: Limits{MaxCycleShare: 0.25}, portfolio: coin, prior: []*coinbase.OrderRequest{ethBuy(2*coin, ref)}, req: ethBuy(4*coin, ref), wantErr: true},
+		{name: "cycle share without portfolio", limits: Limits{MaxCycleShare: 0.25}, req: ethBuy(coin/100, ref), wantErr: true},
+		{name: "hourly within", limits: Limits{MaxHourlyTurnover: coin / 10}, prior: []*coinbase.OrderRequest{ethBuy(coin, ref)}, req: ethBuy(coin, ref)},
+		{name: "hourly exceeded", limits: Limits{MaxHourlyTurnover: coin / 10}, prior: []*coinbase.OrderRequest{ethBuy(coin, ref)}, req: ethBuy(coin+coin/100, ref), wantErr: true},
+		{name: "hourly skips older trades", limits: Limits{MaxHourlyTurnover: coin / 10}, prior: []*coinbase.OrderRequest{ethBuy(2*coin, ref)}, priorAge: 2 * time.Hour, req: ethBuy(2*coin, ref)},
+		{name: "daily counts older trades", limits: Limits{MaxDailyTurnover: coin / 10}, prior: []*coinbase.OrderRequest{ethBuy(2*coin, ref)}, priorAge: 2 * time.Hour, req: ethBuy(coin/100, ref), wantErr: true},
+		{name: "daily skips trades over a day old", limits: Limits{MaxDailyTurnover: coin / 10}, prior: []*coinbase.OrderRequest{ethBuy(2*coin, ref)}, priorAge: 25 * time.Hour, req: ethBuy(2*coin, ref)},
+		{name: "no reference rate", rates: noRates{}, req: ethBuy(coin/100, ref), wantErr: true},
+		{name: "zero reference rate", rates: fixedRates(0), req: ethBuy(coin/100, ref), wantErr: true},
+	}
+
+	for _, tc := range tests {
+		rates := tc.rates
+		if rates == nil {
+			rates = fixedRates(0.05)
+		}
+		limits := tc.limits
+		e := NewEngine(&limits, rates)
+		e.logger = log.New(ioutil.Discard, "", 0)
+		if tc.portfolio > 0 {
+			e.StartCycle(tc.portfolio)
+		}
+		for _, req := range tc.prior {
+			e.Record(req)
+		}
+		for i := range e.trades {
+			e.trades[i].at = e.trades[i].at.Add(-tc.priorAge)
+		}
+
+		err := e.Check(tc.req)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got %v, want error %t", tc.name, err, tc.wantErr)
+		}
+		if _, ok := err.(*Rejection); err != nil && !ok {
+			t.Errorf("%s: got a %T, want a *Rejection", tc.name, err)
+		}
+	}
+}
+
+func TestStartCycleRollsOver(t *testing.T) {
+	e := NewEngine(&Limits{MaxCycleShare: 0.5}, fixedRates(0.05))
+	e.logger = log.New(ioutil.Discard, "", 0)
+	e.StartCycle(coinbase.AmountCoin / 10)
+
+	// 0.05 BTC of a 0.1 BTC portfolio uses up the cycle
+	first := ethBuy(coinbase.AmountCoin, 5000000)
+	if err := e.Check(first); err != nil {
+		t.Fatal(err)
+	}
+	e.Record(first)
+	if err := e.Check(ethBuy(coinbase.AmountCoin/100, 5000000)); err == nil {
+		t.Fatal("expected the cycle share to be used up")
+	}
+
+	e.StartCycle(coinbase.AmountCoin / 10)
+	second := ethBuy(coinbase.AmountCoin, 5000000)
+	if err := e.Check(second); err != nil {
+		t.Fatalf("new cycle still charged for the last: %v", err)
+	}
+	e.Record(second)
+
+	// Releasing an order from an earlier cycle doesn't free up this one
+	e.Release(first.ClientOID, first.Size)
+	if err := e.Check(ethBuy(coinbase.AmountCoin/100, 5000000)); err == nil {
+		t.Error("released an earlier cycle's order against this one")
+	}
+
+	// A new cycle takes the portfolio value it is given
+	e.StartCycle(0)
+	if err := e.Check(ethBuy(coinbase.AmountCoin/100, 5000000)); err == nil {
+		t.Error("checked against a stale portfolio value")
+	}
+}
@@ -2,8 +2,11 @@ package spread
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/tobyjsullivan/btc-frogger/coinbase"
@@ -11,24 +14,63 @@ import (
 
 const (
 	loopDuration = 1 * time.Second
+
+	// Feed quotes older than this are considered stale and REST polling takes over
+	maxFeedQuoteAge = 10 * time.Second
+	// REST quotes older than this are not served at all. Polling refreshes them every
+	// loopDuration, so this only trips when polling fails or was skipped for a healthy feed
+	// which has since gone quiet.
+	maxRestQuoteAge = 10 * time.Second
+	// Matches the depth returned by the REST level 2 book
+	feedDepthLevels = 50
+
+	SourceFeed = Source("feed")
+	SourceRest = Source("rest")
 )
 
-type spread struct {
-	bid int64
-	ask int64
+type Source string
+
+// Quote is the best bid and ask for a product along with where and when it was observed.
+// Depth is nil when the source only provides the top of book.
+type Quote struct {
+	Bid    int64
+	Ask    int64
+	Depth  *coinbase.Depth
+	Source Source
+	Time   time.Time
+}
+
+func (q *Quote) Age() time.Duration {
+	return time.Since(q.Time)
+}
+
+// QuoteSource provides live quotes, typically from the websocket feed. ok is false whenever
+// the source has nothing current for the product.
+type QuoteSource interface {
+	Quote(pid coinbase.ProductID) (bid int64, ask int64, at time.Time, ok bool)
+}
+
+// DepthSource is implemented by quote sources which can also provide aggregated book depth.
+type DepthSource interface {
+	Depth(pid coinbase.ProductID, levels int) (*coinbase.Depth, bool)
 }
 
 type SpreadSvc struct {
-	conn   *coinbase.Conn
-	spreads  map[coinbase.ProductID]*spread
-	logger *log.Logger
+	conn    *coinbase.Conn
+	feed    QuoteSource
+	mx      sync.Mutex
+	spreads map[coinbase.ProductID]*Quote
+	logger  *log.Logger
 }
 
-func NewService(ctx context.Context, conn *coinbase.Conn) *SpreadSvc {
+// NewService builds a SpreadSvc which prefers quotes from feed, when it is non-nil, and falls
+// back to polling the REST book whenever the feed has no fresh quote for a product.
+func NewService(ctx context.Context, conn *coinbase.Conn, feed QuoteSource) *SpreadSvc {
 	svc := &SpreadSvc{
-		conn:   conn,
-		spreads:  make(map[coinbase.ProductID]*spread),
-		logger: log.New(os.Stdout, "[spread] ", 0),
+		conn:    conn,
+		feed:    feed,
+		spreads: make(map[coinbase.ProductID]*Quote),
+		logger:  log.New(os.Stdout, "[spread] ", 0),
 	}
 
 	go svc.loop(ctx)
@@ -37,21 +79,63 @@ func NewService(ctx context.Context, conn *coinbase.Conn) *SpreadSvc {
 }
 
 func (svc *SpreadSvc) CurrentBid(pid coinbase.ProductID) (int64, bool) {
-	cur, ok := svc.spreads[pid]
+	cur, ok := svc.CurrentQuote(pid)
 	if !ok {
 		return 0, false
 	}
 
-	return cur.bid, true
+	return cur.Bid, true
 }
 
 func (svc *SpreadSvc) CurrentAsk(pid coinbase.ProductID) (int64, bool) {
-	cur, ok := svc.spreads[pid]
+	cur, ok := svc.CurrentQuote(pid)
 	if !ok {
 		return 0, false
 	}
 
-	return cur.ask, true
+	return cur.Ask, true
+}
+
+// CurrentQuote returns the freshest quote available for the product. ok is false when there
+// is no uncrossed quote younger than the max age for its source.
+func (svc *SpreadSvc) CurrentQuote(pid coinbase.ProductID) (*Quote, bool) {
+	if q, ok := svc.feedQuote(pid); ok {
+		return q, true
+	}
+
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	cur, ok := svc.spreads[pid]
+	if !ok || cur.Age() > maxRestQuoteAge {
+		return nil, false
+	}
+
+	return cur, true
+}
+
+func (svc *SpreadSvc) feedQuote(pid coinbase.ProductID) (*Quote, bool) {
+	if svc.feed == nil {
+		return nil, false
+	}
+
+	bid, ask, at, ok := svc.feed.Quote(pid)
+	if !ok || time.Since(at) > maxFeedQuoteAge || bid >= ask {
+		return nil, false
+	}
+
+	var depth *coinbase.Depth
+	if ds, ok := svc.feed.(DepthSource); ok {
+		depth, _ = ds.Depth(pid, feedDepthLevels)
+	}
+
+	return &Quote{
+		Bid:    bid,
+		Ask:    ask,
+		Depth:  depth,
+		Source: SourceFeed,
+		Time:   at,
+	}, true
 }
 
 func (svc *SpreadSvc) loop(ctx context.Context) {
@@ -71,15 +155,45 @@ func (svc *SpreadSvc) updateSpreads() {
 	ratesToGet := []coinbase.ProductID{coinbase.ProductEthBtc, coinbase.ProductLtcBtc}
 
 	for _, prodId := range ratesToGet {
-		book, err := svc.conn.CurrentBook(prodId)
+		if _, ok := svc.feedQuote(prodId); ok {
+			// No need to poll while the feed is healthy
+			continue
+		}
+
+		depth, err := svc.conn.CurrentDepth(prodId)
 		if err != nil {
 			svc.logger.Println("book:", err)
 			continue
 		}
-
-		svc.spreads[prodId] = &spread{
-			bid: book.Bid,
-			ask: book.Ask,
+		quote, err := restQuote(depth)
+		if err != nil {
+			svc.logger.Println("book:", prodId, err)
+			continue
 		}
+
+		svc.mx.Lock()
+		svc.spreads[prodId] = quote
+		svc.mx.Unlock()
 	}
 }
+
+// restQuote takes both sides of the quote from the same book snapshot, rejecting one which
+// is empty or crossed.
+func restQuote(depth *coinbase.Depth) (*Quote, error) {
+	if len(depth.Bids) == 0 || len(depth.Asks) == 0 {
+		return nil, coinbase.ErrEmptyBookSide
+	}
+
+	bid, ask := depth.Bids[0].Price, depth.Asks[0].Price
+	if bid >= ask {
+		return nil, errors.New(fmt.Sprintf("Crossed book: bid %d >= ask %d", bid, ask))
+	}
+
+	return &Quote{
+		Bid:    bid,
+		Ask:    ask,
+		Depth:  depth,
+		Source: SourceRest,
+		Time:   time.Now(),
+	}, nil
+}
@@ -0,0 +1,69 @@
+package spread
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+type fakeFeed struct {
+	bid, ask int64
+	at       time.Time
+}
+
+func (f *fakeFeed) Quote(pid coinbase.ProductID) (int64, int64, time.Time, bool) {
+	return f.bid, f.ask, f.at, true
+}
+
+func TestRestQuote(t *testing.T) {
+	level := func(price int64) []*coinbase.BookLevel {
+		return []*coinbase.BookLevel{{Price: price, Size: coinbase.AmountCoin}}
+	}
+
+	for name, tc := range map[string]struct {
+		depth   *coinbase.Depth
+		wantErr bool
+	}{
+		"normal":  {depth: &coinbase.Depth{Bids: level(100), Asks: level(101)}},
+		"locked":  {depth: &coinbase.Depth{Bids: level(100), Asks: level(100)}, wantErr: true},
+		"crossed": {depth: &coinbase.Depth{Bids: level(102), Asks: level(101)}, wantErr: true},
+		"empty":   {depth: &coinbase.Depth{Bids: level(100)}, wantErr: true},
+	} {
+		q, err := restQuote(tc.depth)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %d/%d, want an error", name, q.Bid, q.Ask)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if q.Bid != 100 || q.Ask != 101 || q.Source != SourceRest || q.Depth != tc.depth {
+			t.Errorf("%s: got %+v", name, q)
+		}
+	}
+}
+
+func TestCurrentQuoteSkipsCrossedFeed(t *testing.T) {
+	feed := &fakeFeed{bid: 101, ask: 100, at: time.Now()}
+	svc := &SpreadSvc{
+		feed: feed,
+		spreads: map[coinbase.ProductID]*Quote{
+			coinbase.ProductEthBtc: {Bid: 99, Ask: 100, Source: SourceRest, Time: time.Now()},
+		},
+	}
+
+	q, ok := svc.CurrentQuote(coinbase.ProductEthBtc)
+	if !ok || q.Source != SourceRest {
+		t.Fatalf("got %+v, want the REST quote", q)
+	}
+
+	feed.bid = 99
+	q, ok = svc.CurrentQuote(coinbase.ProductEthBtc)
+	if !ok || q.Source != SourceFeed {
+		t.Fatalf("got %+v, want the feed quote", q)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/pnl"
+)
+
+// Exports every disposal for tax and accounting, with USD values at the time of each trade.
+// The full fill history is always replayed so cost basis is correct; -year only limits which
+// disposals are written.
+//
+//	taxexport -method fifo -format 8949 -year 2017 -out 2017.csv
+func main() {
+	log.SetPrefix("[taxexport] ")
+	log.SetFlags(0)
+
+	products := flag.String("products", "ETH-BTC,LTC-BTC,BTC-USD", "comma separated products to export")
+	method := flag.String("method", string(pnl.MethodFIFO), "lot matching method (fifo, lifo, average)")
+	format := flag.String("format", "disposals", "CSV layout (disposals, 8949, fills)")
+	year := flag.Int("year", 0, "only disposals in this calendar year, UTC")
+	out := flag.String("out", "", "output file. Defaults to stdout")
+	flag.Parse()
+
+	lotMethod, err := pnl.ParseMethod(*method)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	conn := &coinbase.Conn{
+		Requester: &coinbase.SignedRequester{
+			ApiAccessKey:  os.Getenv("COINBASE_API_ACCESS_KEY"),
+			ApiSecretKey:  os.Getenv("COINBASE_API_SECRET_KEY"),
+			ApiPassphrase: os.Getenv("COINBASE_API_PASSPHRASE"),
+		},
+	}
+
+	var fills []*coinbase.Fill
+	for _, p := range strings.Split(*products, ",") {
+		pid := coinbase.ProductID(strings.TrimSpace(p))
+		log.Println("Fetching fills:", pid)
+		productFills, err := conn.AllFills(pid, time.Time{})
+		if err != nil {
+			log.Fatalln("fills:", pid, err)
+		}
+		fills = append(fills, productFills...)
+	}
+
+	// Replay oldest first across all products
+	sortFills(fills)
+	log.Printf("Replaying %d fills\n", len(fills))
+
+	rates := newUsdRates(conn)
+	ledger := pnl.NewLedger(lotMethod)
+	fillRates := make(map[fillKey]float64)
+	for _, f := range fills {
+		btcUsd, err := rates.at(f)
+		if err != nil {
+			log.Fatalln("BTC/USD rate:", f.CreatedAt, err)
+		}
+		fillRates[fillKey{f.ProductID, f.TradeID}] = btcUsd
+
+		if err := ledger.AddFill(f, btcUsd); err != nil {
+			log.Fatalln("fill:", f.TradeID, err)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	inYear := func(t time.Time) bool {
+		return *year == 0 || t.UTC().Year() == *year
+	}
+
+	cw := csv.NewWriter(w)
+	switch *format {
+	case "disposals":
+		writeDisposals(cw, ledger.Disposals(), inYear)
+	case "8949":
+		write8949(cw, ledger.Disposals(), lotMethod, inYear)
+	case "fills":
+		writeFills(cw, fills, fillRates, inYear)
+	default:
+		log.Fatalln("Unknown format:", *format)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Fatalln("write:", err)
+	}
+}
+
+// Trade IDs are only unique within a product.
+type fillKey struct {
+	productID coinbase.ProductID
+	tradeID   int64
+}
+
+// sortFills orders fills by time, breaking ties by product and then trade ID so the replay is
+// deterministic.
+func sortFills(fills []*coinbase.Fill) {
+	sort.Slice(fills, func(i, j int) bool {
+		a, b := fills[i], fills[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		if a.ProductID != b.ProductID {
+			return a.ProductID < b.ProductID
+		}
+		return a.TradeID < b.TradeID
+	})
+}
+
+func writeDisposals(cw *csv.Writer, disposals []*pnl.Disposal, include func(time.Time) bool) {
+	cw.Write([]string{"currency", "size", "acquired", "disposed", "proceeds_usd", "cost_basis_usd", "fee_usd",
+		"gain_usd", "proceeds_btc", "cost_basis_btc", "gain_btc", "trade_id"})
+	for _, d := range disposals {
+		if !include(d.Disposed) {
+			continue
+		}
+
+		acquired := ""
+		if !d.Acquired.IsZero() {
+			acquired = d.Acquired.UTC().Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			string(d.Currency),
+			coinbase.FormatAmount(d.Size),
+			acquired,
+			d.Disposed.UTC().Format(time.RFC3339),
+			fmtUsd(d.ProceedsUsd),
+			fmtUsd(d.CostUsd),
+			fmtUsd(d.FeeUsd),
+			fmtUsd(d.GainUsd()),
+			coinbase.FormatAmount(d.ProceedsBtc),
+			coinbase.FormatAmount(d.CostBtc),
+			coinbase.FormatAmount(d.GainBtc()),
+			fmt.Sprintf("%d", d.TradeID),
+		})
+	}
+}
+
+// write8949 follows the columns of IRS Form 8949. Proceeds are already net of fees.
+func write8949(cw *csv.Writer, disposals []*pnl.Disposal, method pnl.Method, include func(time.Time) bool) {
+	cw.Write([]string{"Description", "Date Acquired", "Date Sold", "Proceeds", "Cost Basis", "Gain or Loss"})
+	for _, d := range disposals {
+		if !include(d.Disposed) {
+			continue
+		}
+
+		acquired := d.Acquired.UTC().Format("01/02/2006")
+		if d.Acquired.IsZero() {
+			acquired = "UNKNOWN"
+		} else if method == pnl.MethodAverage {
+			acquired = "VARIOUS"
+		}
+		cw.Write([]string{
+			fmt.Sprintf("%s %s", coinbase.FormatAmount(d.Size), d.Currency),
+			acquired,
+			d.Disposed.UTC().Format("01/02/2006"),
+			fmtUsd(d.ProceedsUsd),
+			fmtUsd(d.CostUsd),
+			fmtUsd(d.GainUsd()),
+		})
+	}
+}
+
+func writeFills(cw *csv.Writer, fills []*coinbase.Fill, btcUsd map[fillKey]float64, include func(time.Time) bool) {
+	cw.Write([]string{"time", "trade_id", "product", "side", "price", "size", "fee", "btc_usd", "notional_usd",
+		"fee_usd", "liquidity"})
+	for _, f := range fills {
+		if !include(f.CreatedAt) {
+			continue
+		}
+
+		rate := btcUsd[fillKey{f.ProductID, f.TradeID}]
+		notional := float64(f.Price) * float64(f.Size) / coinbase.AmountCoin
+		toUsd := func(amt float64) float64 {
+			if f.ProductID.QuoteCurrency() == coinbase.CurrencyUsd {
+				return amt / coinbase.AmountCoin
+			}
+			return amt / coinbase.AmountCoin * rate
+		}
+		cw.Write([]string{
+			f.CreatedAt.UTC().Format(time.RFC3339),
+			fmt.Sprintf("%d", f.TradeID),
+			string(f.ProductID),
+			string(f.Side),
+			coinbase.FormatAmount(f.Price),
+			coinbase.FormatAmount(f.Size),
+			coinbase.FormatAmount(f.Fee),
+			fmtUsd(rate),
+			fmtUsd(toUsd(notional)),
+			fmtUsd(toUsd(float64(f.Fee))),
+			f.Liquidity,
+		})
+	}
+}
+
+func fmtUsd(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+	"github.com/tobyjsullivan/btc-frogger/pnl"
+)
+
+var (
+	buyTime  = time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+	sellTime = time.Date(2017, 6, 1, 12, 0, 30, 0, time.UTC)
+)
+
+// testFills buys ETH and LTC in the same second with the same trade ID on each product, then
+// sells the ETH.
+func testFills() []*coinbase.Fill {
+	return []*coinbase.Fill{
+		{TradeID: 2, ProductID: coinbase.ProductEthBtc, Side: coinbase.SideSell, Price: 8000000,
+			Size: coinbase.AmountCoin, Fee: 80000, CreatedAt: sellTime},
+		{TradeID: 1, ProductID: coinbase.ProductLtcBtc, Side: coinbase.SideBuy, Price: 1000000,
+			Size: 10 * coinbase.AmountCoin, CreatedAt: buyTime},
+		{TradeID: 1, ProductID: coinbase.ProductEthBtc, Side: coinbase.SideBuy, Price: 5000000,
+			Size: coinbase.AmountCoin, CreatedAt: buyTime},
+	}
+}
+
+// testRates returns rates cached so that no candles are fetched. There is no candle for the
+// minute of the sale, so it takes the one two minutes earlier.
+func testRates() *usdRates {
+	r := newUsdRates(nil)
+	cache := func(t time.Time, rate float64) {
+		for m := t.Add(-maxCandleGap - time.Minute); !m.After(t.Add(time.Minute)); m = m.Add(time.Minute) {
+			r.loaded[m.Truncate(chunkDuration).Unix()] = true
+		}
+		r.closes[t.Unix()] = rate
+	}
+	cache(buyTime, 1000)
+	cache(sellTime.Truncate(time.Minute).Add(-2*time.Minute), 2500)
+	return r
+}
+
+func replay(t *testing.T, method pnl.Method) ([]*coinbase.Fill, map[fillKey]float64, *pnl.Ledger) {
+	fills := testFills()
+	sortFills(fills)
+
+	rates := testRates()
+	ledger := pnl.NewLedger(method)
+	fillRates := make(map[fillKey]float64)
+	for _, f := range fills {
+		btcUsd, err := rates.at(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fillRates[fillKey{f.ProductID, f.TradeID}] = btcUsd
+		if err := ledger.AddFill(f, btcUsd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return fills, fillRates, ledger
+}
+
+func csvOutput(write func(cw *csv.Writer)) string {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	write(cw)
+	cw.Flush()
+	return buf.String()
+}
+
+func inYear(year int) func(time.Time) bool {
+	return func(t time.Time) bool {
+		return t.UTC().Year() == year
+	}
+}
+
+func TestSortFillsBreaksTiesByProduct(t *testing.T) {
+	fills := testFills()
+	sortFills(fills)
+
+	want := []fillKey{
+		{coinbase.ProductEthBtc, 1},
+		{coinbase.ProductLtcBtc, 1},
+		{coinbase.ProductEthBtc, 2},
+	}
+	for i, f := range fills {
+		if got := (fillKey{f.ProductID, f.TradeID}); got != want[i] {
+			t.Errorf("fill %d: got %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestUsdRatesAt(t *testing.T) {
+	r := testRates()
+
+	cases := []struct {
+		fill *coinbase.Fill
+		want float64
+	}{
+		{&coinbase.Fill{ProductID: coinbase.ProductEthBtc, CreatedAt: buyTime.Add(20 * time.Second)}, 1000},
+		{&coinbase.Fill{ProductID: coinbase.ProductEthBtc, CreatedAt: sellTime}, 2500},
+		// A BTC-USD fill is its own rate
+		{&coinbase.Fill{ProductID: coinbase.ProductBtcUsd, Price: 300000000000, CreatedAt: sellTime}, 3000},
+	}
+	for _, tc := range cases {
+		got, err := r.at(tc.fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%s at %s: got %f, want %f", tc.fill.ProductID, tc.fill.CreatedAt, got, tc.want)
+		}
+	}
+
+	// Nothing within the gap
+	if _, err := r.at(&coinbase.Fill{ProductID: coinbase.ProductEthBtc, CreatedAt: buyTime.Add(-time.Minute)}); err == nil {
+		t.Error("expected an error with no candle in range")
+	}
+}
+
+func TestWriteDisposals(t *testing.T) {
+	_, _, ledger := replay(t, pnl.MethodFIFO)
+
+	got := csvOutput(func(cw *csv.Writer) {
+		writeDisposals(cw, ledger.Disposals(), inYear(2017))
+	})
+	want := strings.Join([]string{
+		"currency,size,acquired,disposed,proceeds_usd,cost_basis_usd,fee_usd,gain_usd,proceeds_btc,cost_basis_btc,gain_btc,trade_id",
+		"BTC,0.05000000,,2017-03-01T10:00:00Z,50.00,0.00,0.00,50.00,0.05000000,0.00000000,0.05000000,1",
+		"BTC,0.10000000,,2017-03-01T10:00:00Z,100.00,0.00,0.00,100.00,0.10000000,0.00000000,0.10000000,1",
+		"ETH,1.00000000,2017-03-01T10:00:00Z,2017-06-01T12:00:30Z,198.00,50.00,2.00,148.00,0.07920000,0.05000000,0.02920000,2",
+	}, "\n") + "\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWrite8949(t *testing.T) {
+	cases := []struct {
+		method pnl.Method
+		year   int
+		want   []string
+	}{
+		{pnl.MethodFIFO, 2017, []string{
+			"Description,Date Acquired,Date Sold,Proceeds,Cost Basis,Gain or Loss",
+			"0.05000000 BTC,UNKNOWN,03/01/2017,50.00,0.00,50.00",
+			"0.10000000 BTC,UNKNOWN,03/01/2017,100.00,0.00,100.00",
+			"1.00000000 ETH,03/01/2017,06/01/2017,198.00,50.00,148.00",
+		}},
+		{pnl.MethodAverage, 2017, []string{
+			"Description,Date Acquired,Date Sold,Proceeds,Cost Basis,Gain or Loss",
+			"0.05000000 BTC,UNKNOWN,03/01/2017,50.00,0.00,50.00",
+			"0.10000000 BTC,UNKNOWN,03/01/2017,100.00,0.00,100.00",
+			"1.00000000 ETH,VARIOUS,06/01/2017,198.00,50.00,148.00",
+		}},
+		{pnl.MethodFIFO, 2016, []string{
+			"Description,Date Acquired,Date Sold,Proceeds,Cost Basis,Gain or Loss",
+		}},
+	}
+
+	for _, tc := range cases {
+		_, _, ledger := replay(t, tc.method)
+		got := csvOutput(func(cw *csv.Writer) {
+			write8949(cw, ledger.Disposals(), tc.method, inYear(tc.year))
+		})
+		if want := strings.Join(tc.want, "\n") + "\n"; got != want {
+			t.Errorf("%s %d: got:\n%s\nwant:\n%s", tc.method, tc.year, got, want)
+		}
+	}
+}
+
+func TestWriteFillsUsesEachProductsRate(t *testing.T) {
+	fills, fillRates, _ := replay(t, pnl.MethodFIFO)
+
+	got := csvOutput(func(cw *csv.Writer) {
+		writeFills(cw, fills, fillRates, inYear(2017))
+	})
+	want := strings.Join([]string{
+		"time,trade_id,product,side,price,size,fee,btc_usd,notional_usd,fee_usd,liquidity",
+		"2017-03-01T10:00:00Z,1,ETH-BTC,buy,0.05000000,1.00000000,0.00000000,1000.00,50.00,0.00,",
+		"2017-03-01T10:00:00Z,1,LTC-BTC,buy,0.01000000,10.00000000,0.00000000,1000.00,100.00,0.00,",
+		"2017-06-01T12:00:30Z,2,ETH-BTC,sell,0.08000000,1.00000000,0.00080000,2500.00,200.00,2.00,",
+	}, "\n") + "\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tobyjsullivan/btc-frogger/coinbase"
+)
+
+const (
+	candleGranularity = time.Minute
+	chunkDuration     = coinbase.MaxCandles * candleGranularity
+	// How far back to look for a candle when a minute saw no trades
+	maxCandleGap = 60 * time.Minute
+)
+
+// usdRates looks up the BTC/USD rate at the time of a fill from one minute candles, fetched in
+// chunks and cached.
+type usdRates struct {
+	conn   *coinbase.Conn
+	closes map[int64]float64
+	loaded map[int64]bool
+}
+
+func newUsdRates(conn *coinbase.Conn) *usdRates {
+	return &usdRates{
+		conn:   conn,
+		closes: make(map[int64]float64),
+		loaded: make(map[int64]bool),
+	}
+}
+
+func (r *usdRates) at(f *coinbase.Fill) (float64, error) {
+	// A BTC-USD fill is its own rate
+	if f.ProductID == coinbase.ProductBtcUsd {
+		return float64(f.Price) / coinbase.AmountCoin, nil
+	}
+
+	minute := f.CreatedAt.UTC().Truncate(candleGranularity)
+	for t := minute; minute.Sub(t) <= maxCandleGap; t = t.Add(-candleGranularity) {
+		if err := r.load(t); err != nil {
+			return 0, err
+		}
+		if rate, ok := r.closes[t.Unix()]; ok {
+			return rate, nil
+		}
+	}
+
+	return 0, errors.New("no BTC-USD candle near " + minute.Format(time.RFC3339))
+}
+
+func (r *usdRates) load(t time.Time) error {
+	start := t.Truncate(chunkDuration)
+	if r.loaded[start.Unix()] {
+		return nil
+	}
+
+	// Stay inside the public endpoint rate limit
+	time.Sleep(400 * time.Millisecond)
+	candles, err := r.conn.HistoricRates(coinbase.ProductBtcUsd, start, start.Add(chunkDuration), candleGranularity)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range candles {
+		r.closes[c.Time.Unix()] = c.Close
+	}
+	r.loaded[start.Unix()] = true
+
+	return nil
+}